@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// inari-compact takes a fresh snapshot and then bundles every event it
+// covers into one archive per year
+func main() {
+
+	s3Endpoint := os.Getenv("S3_ENDPOINT")
+	S3KeyPrefix := os.Getenv("S3_EVENTS_KEY")
+	S3Bucket := os.Getenv("S3_EVENTS_BUCKET")
+
+	// deps
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	s3Client, err := s3.NewClient(s3Endpoint)
+	if err != nil {
+		logger.WithError(err).Error("failed to connect to s3")
+		return
+	}
+
+	sqlDB, err := db.OpenDB()
+	if err != nil {
+		logger.WithError(err).Error("failed to open DB")
+		return
+	}
+
+	eventLog := eventlog.NewEventLog(
+		S3KeyPrefix,
+		S3Bucket,
+		s3Client,
+		sqlDB,
+		logger,
+	)
+
+	err = eventLog.Snapshot()
+	if err != nil {
+		logger.WithError(err).Error("failed to snapshot projections")
+		return
+	}
+
+	err = eventLog.Compact()
+	if err != nil {
+		logger.WithError(err).Error("failed to compact event log")
+		return
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// inari-export writes the whole event log to a gzip JSONL archive, with a
+// manifest alongside it named <archive>.manifest.json
+func main() {
+
+	out := flag.String("o", "events-"+time.Now().Format("20060102")+".jsonl.gz", "archive to write")
+	flag.Parse()
+
+	s3Endpoint := os.Getenv("S3_ENDPOINT")
+	S3KeyPrefix := os.Getenv("S3_EVENTS_KEY")
+	S3Bucket := os.Getenv("S3_EVENTS_BUCKET")
+
+	// deps
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	s3Client, err := s3.NewClient(s3Endpoint)
+	if err != nil {
+		logger.WithError(err).Error("failed to connect to s3")
+		return
+	}
+
+	sqlDB, err := db.OpenDB()
+	if err != nil {
+		logger.WithError(err).Error("failed to open DB")
+		return
+	}
+
+	eventLog := eventlog.NewEventLog(
+		S3KeyPrefix,
+		S3Bucket,
+		s3Client,
+		sqlDB,
+		logger,
+	)
+
+	// make sure journaled events are in the log before it is exported
+	_, err = eventLog.Ship()
+	if err != nil {
+		logger.WithError(err).Error("failed to ship events")
+		return
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		logger.WithError(err).Error("failed to create archive")
+		return
+	}
+	defer f.Close()
+
+	manifest, err := eventLog.Export(f)
+	if err != nil {
+		logger.WithError(err).Error("failed to export events")
+		return
+	}
+
+	mf, err := os.Create(*out + ".manifest.json")
+	if err != nil {
+		logger.WithError(err).Error("failed to create manifest")
+		return
+	}
+	defer mf.Close()
+
+	enc := json.NewEncoder(mf)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(manifest)
+	if err != nil {
+		logger.WithError(err).Error("failed to write manifest")
+		return
+	}
+
+	logger.Infof("exported %d events to %s", manifest.Events, *out)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/fsck"
+	"github.com/j4y_funabashi/inari-micropub/pkg/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// inari-fsck cross checks the event log, the database and the media
+// bucket. With -repair it appends an event for every finding that can be
+// fixed by one. It exits with status 1 if anything was found
+func main() {
+
+	repair := flag.Bool("repair", false, "append repair events for findings that have one")
+	flag.Parse()
+
+	s3Endpoint := os.Getenv("S3_ENDPOINT")
+	S3KeyPrefix := os.Getenv("S3_EVENTS_KEY")
+	S3Bucket := os.Getenv("S3_EVENTS_BUCKET")
+	mediaBucket := os.Getenv("S3_MEDIA_BUCKET")
+
+	// deps
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	s3Client, err := s3.NewClient(s3Endpoint)
+	if err != nil {
+		logger.WithError(err).Error("failed to connect to s3")
+		os.Exit(1)
+	}
+
+	sqlDB, err := db.OpenDB()
+	if err != nil {
+		logger.WithError(err).Error("failed to open DB")
+		os.Exit(1)
+	}
+
+	eventLog := eventlog.NewEventLog(
+		S3KeyPrefix,
+		S3Bucket,
+		s3Client,
+		sqlDB,
+		logger,
+	)
+
+	// check against the whole log, not just what this database has seen
+	err = eventLog.Replay()
+	if err != nil {
+		logger.WithError(err).Error("failed to replay event log")
+		os.Exit(1)
+	}
+
+	in, err := fsck.Load(sqlDB, s3Client, mediaBucket)
+	if err != nil {
+		logger.WithError(err).Error("failed to load fsck inputs")
+		os.Exit(1)
+	}
+
+	findings := fsck.Check(in)
+	repaired := 0
+	for _, f := range findings {
+		logger.
+			WithField("kind", f.Kind).
+			WithField("subject", f.Subject).
+			Warn(f.Detail)
+
+		if !*repair || f.Repair == nil {
+			continue
+		}
+		err = eventLog.Append(f.Repair)
+		if err != nil {
+			logger.WithField("subject", f.Subject).WithError(err).Error("failed to append repair event")
+			continue
+		}
+		repaired++
+	}
+
+	if repaired > 0 {
+		_, err = eventLog.Ship()
+		if err != nil {
+			logger.WithError(err).Error("failed to ship repair events")
+		}
+	}
+	logger.Infof("found %d problems, appended %d repair events", len(findings), repaired)
+
+	if len(findings) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// inari-import checks an archive written by inari-export against its
+// manifest, writes any events the log is missing and replays them
+func main() {
+
+	in := flag.String("i", "", "archive to import")
+	flag.Parse()
+
+	s3Endpoint := os.Getenv("S3_ENDPOINT")
+	S3KeyPrefix := os.Getenv("S3_EVENTS_KEY")
+	S3Bucket := os.Getenv("S3_EVENTS_BUCKET")
+
+	// deps
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	if *in == "" {
+		logger.Error("an archive is required, use -i")
+		return
+	}
+
+	manifest := eventlog.Manifest{}
+	mf, err := os.Open(*in + ".manifest.json")
+	if err != nil {
+		logger.WithError(err).Error("failed to open manifest")
+		return
+	}
+	err = json.NewDecoder(mf).Decode(&manifest)
+	mf.Close()
+	if err != nil {
+		logger.WithError(err).Error("failed to read manifest")
+		return
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		logger.WithError(err).Error("failed to open archive")
+		return
+	}
+	err = eventlog.VerifyArchive(f, manifest)
+	f.Close()
+	if err != nil {
+		logger.WithError(err).Error("archive does not match its manifest")
+		return
+	}
+
+	s3Client, err := s3.NewClient(s3Endpoint)
+	if err != nil {
+		logger.WithError(err).Error("failed to connect to s3")
+		return
+	}
+
+	sqlDB, err := db.OpenDB()
+	if err != nil {
+		logger.WithError(err).Error("failed to open DB")
+		return
+	}
+
+	eventLog := eventlog.NewEventLog(
+		S3KeyPrefix,
+		S3Bucket,
+		s3Client,
+		sqlDB,
+		logger,
+	)
+
+	// make sure journaled events are in the log before checking sequences
+	_, err = eventLog.Ship()
+	if err != nil {
+		logger.WithError(err).Error("failed to ship events")
+		return
+	}
+
+	f, err = os.Open(*in)
+	if err != nil {
+		logger.WithError(err).Error("failed to open archive")
+		return
+	}
+	defer f.Close()
+
+	result, err := eventLog.Import(f)
+	if err != nil {
+		logger.WithError(err).Error("failed to import events")
+		return
+	}
+	logger.Infof("imported %d events, skipped %d already in the log", result.Imported, result.Skipped)
+
+	err = eventLog.Replay()
+	if err != nil {
+		logger.WithError(err).Error("failed to replay event log")
+		return
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/sirupsen/logrus"
+)
+
+// inari-migrate shows which schema migrations have been applied to the
+// database at DATABASE_URL, or applies the pending ones
+//
+//	inari-migrate status
+//	inari-migrate up
+func main() {
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: inari-migrate status|up\n")
+	}
+	flag.Parse()
+
+	// deps
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	sqlDB, err := db.Connect(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		logger.WithError(err).Error("failed to open DB")
+		os.Exit(1)
+	}
+
+	switch flag.Arg(0) {
+	case "status":
+		list, err := db.Status(sqlDB)
+		if err != nil {
+			logger.WithError(err).Error("failed to read migrations")
+			os.Exit(1)
+		}
+		for _, m := range list {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-19s  %s\n", m.Version, applied, m.Name)
+		}
+
+	case "up":
+		applied, err := db.Migrate(sqlDB)
+		for _, m := range applied {
+			logger.
+				WithField("version", m.Version).
+				WithField("name", m.Name).
+				Info("applied migration")
+		}
+		if err != nil {
+			logger.WithError(err).Error("failed to migrate")
+			os.Exit(1)
+		}
+		logger.Infof("applied %d migrations", len(applied))
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
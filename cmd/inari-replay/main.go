@@ -1,7 +1,10 @@
 package main
 
 import (
+	"flag"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/j4y_funabashi/inari-micropub/pkg/db"
 	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
@@ -11,6 +14,19 @@ import (
 
 func main() {
 
+	rebuild := flag.Bool("rebuild", false, "rebuild the projections into shadow tables and swap them in")
+	allowShrink := flag.Bool("allow-shrink", false, "accept a rebuild with fewer rows than the live tables")
+	concurrency := flag.Int("concurrency", 8, "number of event files to download at once")
+	until := flag.String("until", "", "project the log up to a sequence number, RFC3339 time or date into -schema")
+	schema := flag.String("schema", eventlog.PreviewSchema, "schema to project into with -until")
+	types := flag.String("types", "", "comma separated event types to replay, e.g. MediaUploaded")
+	from := flag.String("from", "", "only replay events appended at or after this RFC3339 time or date")
+	to := flag.String("to", "", "only replay events appended before this RFC3339 time or date")
+	prefix := flag.String("prefix", "", "only replay keys under this prefix of S3_EVENTS_KEY, e.g. 2019")
+	reapply := flag.Bool("reapply", false, "reduce matching events again even if they have been replayed")
+	dryRun := flag.Bool("dry-run", false, "print the SQL each reducer would run without committing")
+	flag.Parse()
+
 	s3Endpoint := os.Getenv("S3_ENDPOINT")
 	S3KeyPrefix := os.Getenv("S3_EVENTS_KEY")
 	S3Bucket := os.Getenv("S3_EVENTS_BUCKET")
@@ -37,11 +53,65 @@ func main() {
 		s3Client,
 		sqlDB,
 		logger,
-	)
+	).WithFetchConcurrency(*concurrency)
 
-	err = eventLog.Replay()
+	opts := eventlog.ReplayOptions{
+		KeyPrefix: *prefix,
+		Reapply:   *reapply,
+		DryRun:    *dryRun,
+	}
+	if *types != "" {
+		opts.EventTypes = strings.Split(*types, ",")
+	}
+	opts.From, err = parseTime(*from)
+	if err != nil {
+		logger.WithError(err).Error("invalid -from")
+		return
+	}
+	opts.To, err = parseTime(*to)
+	if err != nil {
+		logger.WithError(err).Error("invalid -to")
+		return
+	}
+
+	err = eventLog.ReplayWith(opts)
 	if err != nil {
 		logger.WithError(err).Error("failed to replay event log")
 		return
 	}
+	if *dryRun {
+		return
+	}
+
+	if *until != "" {
+		untilSeq, untilTime, err := eventlog.ParseUntil(*until)
+		if err != nil {
+			logger.WithError(err).Error("invalid -until")
+			return
+		}
+		_, err = eventLog.BuildAsOf(*schema, untilSeq, untilTime)
+		if err != nil {
+			logger.WithError(err).Error("failed to project event log")
+		}
+		return
+	}
+
+	if *rebuild {
+		err = eventLog.Rebuild(*allowShrink)
+		if err != nil {
+			logger.WithError(err).Error("failed to rebuild projections")
+			return
+		}
+	}
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
 }
@@ -53,6 +53,12 @@ func main() {
 	addPost(body, eventLog)
 
 	addMedia(eventLog)
+
+	_, err = eventLog.Ship()
+	if err != nil {
+		logger.WithError(err).Error("failed to ship events")
+		return
+	}
 }
 
 func addPost(body string, eventLog eventlog.EventLog) error {
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"os"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// inari-verify walks the event log checking the hash chain, and the
+// signatures too when EVENT_PUBLIC_KEY is set. It exits with status 1 if
+// anything is wrong
+func main() {
+
+	s3Endpoint := os.Getenv("S3_ENDPOINT")
+	S3KeyPrefix := os.Getenv("S3_EVENTS_KEY")
+	S3Bucket := os.Getenv("S3_EVENTS_BUCKET")
+	publicKeyB64 := os.Getenv("EVENT_PUBLIC_KEY")
+
+	// deps
+	logger := logrus.New()
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	var publicKey ed25519.PublicKey
+	if publicKeyB64 != "" {
+		key, err := eventlog.ParsePublicKey(publicKeyB64)
+		if err != nil {
+			logger.WithError(err).Error("invalid EVENT_PUBLIC_KEY")
+			os.Exit(1)
+		}
+		publicKey = key
+	}
+
+	s3Client, err := s3.NewClient(s3Endpoint)
+	if err != nil {
+		logger.WithError(err).Error("failed to connect to s3")
+		os.Exit(1)
+	}
+
+	sqlDB, err := db.OpenDB()
+	if err != nil {
+		logger.WithError(err).Error("failed to open DB")
+		os.Exit(1)
+	}
+
+	eventLog := eventlog.NewEventLog(
+		S3KeyPrefix,
+		S3Bucket,
+		s3Client,
+		sqlDB,
+		logger,
+	)
+
+	checked, problems, err := eventLog.Verify(publicKey)
+	if err != nil {
+		logger.WithError(err).Error("failed to verify event log")
+		os.Exit(1)
+	}
+
+	for _, p := range problems {
+		logger.
+			WithField("key", p.Key).
+			WithField("eventID", p.EventID).
+			WithField("sequence", p.Sequence).
+			Warn(p.Problem)
+	}
+	logger.Infof("checked %d events, found %d problems", checked, len(problems))
+
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
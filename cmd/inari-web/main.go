@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/j4y_funabashi/inari-micropub/pkg/app"
@@ -15,6 +16,7 @@ import (
 	"github.com/j4y_funabashi/inari-micropub/pkg/session"
 	"github.com/j4y_funabashi/inari-micropub/pkg/view"
 	"github.com/j4y_funabashi/inari-micropub/pkg/web"
+	"github.com/j4y_funabashi/inari-micropub/pkg/websub"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,6 +32,10 @@ func main() {
 	mediaBucket := os.Getenv("S3_MEDIA_BUCKET")
 	geoAPIKey := os.Getenv("GEO_API_KEY")
 	geoBaseURL := os.Getenv("GEO_API_URL")
+	snapshotInterval := os.Getenv("SNAPSHOT_INTERVAL")
+	webSubHub := os.Getenv("WEBSUB_HUB")
+	siteURL := os.Getenv("SITE_URL")
+	signingKey := os.Getenv("EVENT_SIGNING_KEY")
 
 	// deps
 	logger := logrus.New()
@@ -48,6 +54,11 @@ func main() {
 
 	selecta := db.NewSelecta(sqlDB)
 
+	previewDB, err := db.OpenSchema(eventlog.PreviewSchema)
+	if err != nil {
+		logger.WithError(err).Warn("preview is unavailable")
+	}
+
 	eventLog := eventlog.NewEventLog(
 		S3KeyPrefix,
 		S3Bucket,
@@ -55,6 +66,19 @@ func main() {
 		sqlDB,
 		logger,
 	)
+	if signingKey != "" {
+		key, err := eventlog.ParseSigningKey(signingKey)
+		if err != nil {
+			logger.WithError(err).Error("invalid EVENT_SIGNING_KEY")
+			return
+		}
+		eventLog = eventLog.WithSigningKey(key)
+	}
+
+	dispatcher := eventlog.NewDispatcher(sqlDB, logger)
+	if webSubHub != "" {
+		dispatcher.Subscribe("websub", websub.New(webSubHub, siteURL).Handle)
+	}
 
 	mediaServer := micropub.NewMediaServer(
 		s3Client,
@@ -82,23 +106,52 @@ func main() {
 		geo,
 		eventLog,
 	)
-	presenter := view.NewPresenter()
-	reqParser := web.NewParser()
+	if previewDB != nil {
+		inari = inari.WithPreview(db.NewPreviewSelecta(previewDB))
+	}
+
+	// routing
+	router := newRouter(inari, micropubServer, "view", logger)
+
+	go func() {
+		err := eventLog.Replay()
+		if err != nil {
+			logger.WithError(err).Error("failed to replay events, snapshots are not scheduled")
+			return
+		}
+		if snapshotInterval == "" {
+			return
+		}
+		interval, err := time.ParseDuration(snapshotInterval)
+		if err != nil {
+			logger.WithError(err).Error("invalid SNAPSHOT_INTERVAL")
+			return
+		}
+		eventLog.RunSnapshots(interval)
+	}()
+
+	go eventLog.RunShipper(2 * time.Second)
+	go dispatcher.Run(5 * time.Second)
+
+	logger.Info("XX micropub server running on port " + port)
+	logger.Fatal(http.ListenAndServe(":"+port, router))
+}
+
+// newRouter routes the web server under /api and the micropub server
+// under /micropub, reading html templates from templateDir. Both servers
+// take their ports as arguments, so tests can boot them in process with
+// the ports from pkg/memory
+func newRouter(inari app.Server, micropubServer micropub.Server, templateDir string, logger *logrus.Logger) *mux.Router {
 	webServer := web.NewServer(
 		inari,
 		logger,
-		presenter,
-		reqParser,
-	)
+		view.NewPresenter(),
+		web.NewParser(),
+	).WithTemplateDir(templateDir)
 
-	// routing
 	router := mux.NewRouter()
 	router.StrictSlash(true)
-	micropubServer.Routes(router.PathPrefix("/micropub").Subrouter())
 	webServer.Routes(router.PathPrefix("/api").Subrouter())
-
-	go eventLog.Replay()
-
-	logger.Info("XX micropub server running on port " + port)
-	logger.Fatal(http.ListenAndServe(":"+port, router))
+	micropubServer.Routes(router.PathPrefix("/micropub").Subrouter())
+	return router
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+	"github.com/j4y_funabashi/inari-micropub/pkg/indieauth"
+	"github.com/j4y_funabashi/inari-micropub/pkg/memory"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+	"github.com/j4y_funabashi/inari-micropub/pkg/micropub"
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+// bootInari serves inari from memory until it is closed, the templates
+// are read from the root of the repo. Every micropub token is valid
+func bootInari(t *testing.T, events ...eventlog.Event) (*httptest.Server, func()) {
+	is := is.New(t)
+
+	store := memory.NewStore()
+	el := memory.NewEventLog(store)
+	for _, event := range events {
+		is.NoErr(el.Append(event))
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	inari := app.New(
+		memory.NewSelecta(store),
+		logger,
+		memory.NewSessionStore(),
+		memory.NewGeocoder(nil, nil),
+		el,
+	)
+	micropubServer := micropub.NewServer(
+		"",
+		"",
+		logger,
+		validToken,
+		memory.NewSelecta(store),
+		el,
+		micropub.MediaServer{},
+	)
+	srv := httptest.NewServer(newRouter(inari, micropubServer, "../../view", logger))
+	return srv, srv.Close
+}
+
+// validToken accepts any bearer token as the site owner's
+func validToken(tokenEndpoint, bearerToken string, logger *logrus.Logger) (indieauth.TokenResponse, error) {
+	return indieauth.TokenResponse{Me: "https://example.com/", Scope: "create update", StatusCode: http.StatusOK}, nil
+}
+
+// setenv sets an environment variable until the returned func restores
+// it
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+			return
+		}
+		os.Unsetenv(key)
+	}
+}
+
+func TestBootInMemory(t *testing.T) {
+	is := is.New(t)
+
+	taken := time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)
+	photo := eventlog.NewMediaUploaded(mf2.MediaMetadata{
+		Uid:      "leeds",
+		FileKey:  "2019/leeds.jpg",
+		MimeType: "image/jpeg",
+		DateTime: &taken,
+		Lat:      53.8,
+		Lng:      -1.55,
+	})
+	note := eventlog.NewPostCreated(mf2.MicroFormat{
+		Type: []string{"h-entry"},
+		Properties: map[string][]interface{}{
+			"uid":       {"note"},
+			"url":       {"https://example.com/p/note"},
+			"published": {"2019-05-01T08:00:00Z"},
+			"content":   {"a walk by the river"},
+			"location":  {"geo:53.79,-1.54"},
+		},
+	})
+	srv, closeInari := bootInari(t, photo, note)
+	defer closeInari()
+
+	// homepage
+	res, err := http.Get(srv.URL + "/api/")
+	is.NoErr(err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	is.NoErr(err)
+	is.Equal(res.StatusCode, http.StatusOK)
+	is.True(strings.Contains(string(body), "a walk by the river"))
+
+	// log in as admin
+	h := sha1.New()
+	h.Write([]byte("secret"))
+	defer setenv("ADMIN_PASSWORD", hex.EncodeToString(h.Sum(nil)))()
+	res, err = http.Post(srv.URL+"/api/login", "application/json", strings.NewReader(`{"password":"secret"}`))
+	is.NoErr(err)
+	res.Body.Close()
+	is.Equal(res.StatusCode, http.StatusOK)
+	cookies := res.Cookies()
+	is.Equal(len(cookies), 1)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	postForm := func(path string, form url.Values) *http.Response {
+		req, err := http.NewRequest("POST", srv.URL+path, strings.NewReader(form.Encode()))
+		is.NoErr(err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[0])
+		res, err := client.Do(req)
+		is.NoErr(err)
+		res.Body.Close()
+		return res
+	}
+
+	// publish the photo through the composer
+	res = postForm("/api/admin/composer/media", url.Values{"media_url": {photo.EventData.URL}})
+	is.Equal(res.StatusCode, http.StatusSeeOther)
+	res = postForm("/api/admin/composer", url.Values{"content": {"kirkgate market"}})
+	is.Equal(res.StatusCode, http.StatusSeeOther)
+
+	// reverting a missing post or revision is not found
+	res = postForm("/api/admin/posts/revert", url.Values{"url": {"https://example.com/p/missing"}, "revision": {"1"}})
+	is.Equal(res.StatusCode, http.StatusNotFound)
+	res = postForm("/api/admin/posts/revert", url.Values{"url": {"https://example.com/p/note"}, "revision": {"9"}})
+	is.Equal(res.StatusCode, http.StatusNotFound)
+
+	req, err := http.NewRequest("GET", srv.URL+"/api/media?bbox=-2,53.5,-1,54", nil)
+	is.NoErr(err)
+	req.AddCookie(cookies[0])
+	res, err = client.Do(req)
+	is.NoErr(err)
+	mediaList := mf2.MediaList{}
+	is.NoErr(json.NewDecoder(res.Body).Decode(&mediaList))
+	res.Body.Close()
+	is.Equal(len(mediaList.Items), 1)
+	is.True(mediaList.Items[0].IsPublished)
+
+	// only the note has a location
+	res, err = http.Get(srv.URL + "/api/posts.geojson")
+	is.NoErr(err)
+	body, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	is.NoErr(err)
+	is.Equal(res.StatusCode, http.StatusOK)
+	fc := geo.FeatureCollection{}
+	is.NoErr(json.Unmarshal(body, &fc))
+	is.Equal(len(fc.Features), 1)
+	is.Equal(fc.Features[0].Properties["url"], "https://example.com/p/note")
+
+	// micropub source query
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"me":"https://example.com/","scope":"create update"}`))
+	}))
+	defer tokenEndpoint.Close()
+	defer setenv("TOKEN_ENDPOINT", tokenEndpoint.URL)()
+
+	req, err = http.NewRequest("GET", srv.URL+"/api/micropub?q=source&limit=1", nil)
+	is.NoErr(err)
+	req.Header.Set("Authorization", "Bearer test-valid-token")
+	res, err = client.Do(req)
+	is.NoErr(err)
+	source := mf2.PostList{}
+	is.NoErr(json.NewDecoder(res.Body).Decode(&source))
+	res.Body.Close()
+	is.Equal(len(source.Items), 1)
+	is.Equal(source.Items[0].GetFirstString("content"), "kirkgate market")
+	is.True(source.Paging.After != "")
+
+	// the micropub server is mounted too
+	req, err = http.NewRequest("GET", srv.URL+"/micropub/oldendpoint?q=source&limit=1", nil)
+	is.NoErr(err)
+	req.Header.Set("Authorization", "Bearer test-valid-token")
+	res, err = client.Do(req)
+	is.NoErr(err)
+	source = mf2.PostList{}
+	is.NoErr(json.NewDecoder(res.Body).Decode(&source))
+	res.Body.Close()
+	is.Equal(len(source.Items), 1)
+	is.Equal(source.Items[0].GetFirstString("content"), "kirkgate market")
+
+	res, err = http.Get(srv.URL + "/micropub/media?q=source&limit=1")
+	is.NoErr(err)
+	media := mf2.MediaList{}
+	is.NoErr(json.NewDecoder(res.Body).Decode(&media))
+	res.Body.Close()
+	is.Equal(res.StatusCode, http.StatusOK)
+	is.Equal(len(media.Items), 1)
+
+	res, err = http.Get(srv.URL + "/micropub/media?q=source&after=nonsense")
+	is.NoErr(err)
+	res.Body.Close()
+	is.Equal(res.StatusCode, http.StatusBadRequest)
+}
@@ -16,19 +16,51 @@ import (
 	"strings"
 	"time"
 
+	"github.com/j4y_funabashi/inari-micropub/pkg/cursor"
 	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
 	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
 	"github.com/rwcarlsen/goexif/exif"
 	uuid "github.com/satori/go.uuid"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrPostNotFound is returned when a post url does not exist
+var ErrPostNotFound = errors.New("post not found")
+
+// ErrPreconditionFailed is returned when an update names a post version
+// that is no longer current
+var ErrPreconditionFailed = errors.New("post version does not match")
+
+// ErrRevisionNotFound is returned when a post has no such revision
+var ErrRevisionNotFound = errors.New("post revision not found")
+
+// ErrVersionConflict is returned when a post changed while an update was
+// being applied
+var ErrVersionConflict = eventlog.ErrVersionConflict
+
+// ErrPreviewUnavailable is returned when no preview projection has been
+// configured
+var ErrPreviewUnavailable = errors.New("preview is not available")
+
+// ErrInvalidCursor is returned when a list is paged by a cursor it did not
+// give out
+var ErrInvalidCursor = cursor.ErrInvalid
+
+// ErrArchiveNotFound is returned for an archive period with no posts
+var ErrArchiveNotFound = errors.New("archive not found")
+
+// ErrUnknownPostType is returned when posts are filtered by a type that
+// post type discovery never gives
+var ErrUnknownPostType = errors.New("unknown post type")
+
 type Server struct {
 	selecta      Selecta
 	logger       *logrus.Logger
 	sessionStore SessionStore
 	geo          Geocoder
 	el           EventLog
+	preview      Selecta
 }
 
 type Geocoder interface {
@@ -56,6 +88,16 @@ type Day struct {
 	PublishedCount int
 }
 
+type ArchiveLinkYear struct {
+	Year  string `json:"year"`
+	Count int    `json:"count"`
+}
+
+type ArchiveLinkMonth struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+}
+
 type Media struct {
 	URL         string     `json:"url"`
 	MimeType    string     `json:"mime_type"`
@@ -113,8 +155,18 @@ type Selecta interface {
 	SelectMediaDayList(currentYear, currentMonth string) ([]Day, error)
 	SelectMediaDay(year, month, day string) ([]Media, error)
 	SelectMediaByURL(url string) (Media, error)
-	SelectPostList(limit int, afterKey string) mf2.PostList
+	SelectPostList(limit int, cursor string) (mf2.PostList, error)
+	SelectPostListByType(postType string, limit int, cursor string) (mf2.PostList, error)
+	SelectArchivePostList(year, month string, limit int, cursor string) (mf2.PostList, error)
+	SelectYearList() ([]ArchiveLinkYear, error)
+	SelectMonthList(year string) ([]ArchiveLinkMonth, error)
 	SelectPostByURL(uid string) (mf2.MicroFormat, error)
+	SelectPostVersion(url string) (int, error)
+	SearchPosts(query string, limit int, cursor string) (mf2.PostList, error)
+	SelectPostsInBox(box geo.Box, limit int) (mf2.PostList, error)
+	SelectPostsNear(lat, lng, radius float64, limit int) (mf2.PostList, error)
+	SelectMediaInBox(box geo.Box, limit int) (mf2.MediaList, error)
+	SelectMediaNear(lat, lng, radius float64, limit int) (mf2.MediaList, error)
 }
 
 type SessionStore interface {
@@ -125,6 +177,7 @@ type SessionStore interface {
 
 type EventLog interface {
 	Append(event eventlog.Event) error
+	PostRevisions(postURL string) ([]eventlog.PostRevision, error)
 }
 
 func New(
@@ -241,9 +294,14 @@ func (s Server) DeleteMedia(mediaURL string) error {
 type UpdatePostRequest struct {
 	URL        string
 	Type       string
+	Version    int
 	Properties map[string][]interface{} `json:"properties"`
 }
 
+type UpdatePostResponse struct {
+	Version int
+}
+
 func (s Server) VerifyAccessToken(
 	tokenEndpoint,
 	bearerToken string,
@@ -311,14 +369,62 @@ func (tr TokenResponse) IsValid() bool {
 	return true
 }
 
-func (s Server) UpdatePost(req UpdatePostRequest) error {
+// UpdatePost applies an update to the current version of a post. If
+// req.Version is set it must match the current version
+func (s Server) UpdatePost(req UpdatePostRequest) (UpdatePostResponse, error) {
+	res := UpdatePostResponse{}
+
+	version, err := s.selecta.SelectPostVersion(req.URL)
+	if err != nil {
+		return res, err
+	}
+	if version == 0 {
+		return res, ErrPostNotFound
+	}
+	res.Version = version
+	if req.Version != 0 && req.Version != version {
+		return res, ErrPreconditionFailed
+	}
+
 	mf, err := s.selecta.SelectPostByURL(req.URL)
 	if err != nil {
-		return err
+		return res, err
 	}
 	mf.ApplyUpdate(req.Properties)
-	event := eventlog.NewPostUpdated(mf)
-	return s.el.Append(event)
+	event := eventlog.NewPostUpdated(mf, version)
+	err = s.el.Append(event)
+	if err != nil {
+		return res, err
+	}
+
+	res.Version = version + 1
+	return res, nil
+}
+
+type QueryPostResponse struct {
+	Post    mf2.MicroFormat
+	Version int
+}
+
+// QueryPost fetches a post along with its current version
+func (s Server) QueryPost(url string) (QueryPostResponse, error) {
+	res := QueryPostResponse{}
+
+	version, err := s.selecta.SelectPostVersion(url)
+	if err != nil {
+		return res, err
+	}
+	if version == 0 {
+		return res, ErrPostNotFound
+	}
+
+	mf, err := s.selecta.SelectPostByURL(url)
+	if err != nil {
+		return res, err
+	}
+	res.Post = mf
+	res.Version = version
+	return res, nil
 }
 
 func (s Server) CreatePost(sess SessionData) error {
@@ -469,17 +575,272 @@ func parseCurrentYear(selectedYear string, years []Year) Year {
 	return Year{}
 }
 
+// QueryPostListResponse is a page of posts along with the cursors of the
+// pages of newer and older posts
 type QueryPostListResponse struct {
-	PostList []mf2.MicroFormat
-	AfterKey string
+	PostList  []mf2.MicroFormat
+	BeforeKey string
+	AfterKey  string
 }
 
-func (s Server) QueryPostList(limit int, after string) (*QueryPostListResponse, error) {
-	pl := s.selecta.SelectPostList(limit, after)
+func newQueryPostListResponse(pl mf2.PostList) *QueryPostListResponse {
 	return &QueryPostListResponse{
-		PostList: pl.Items,
-		AfterKey: pl.Paging.After,
-	}, nil
+		PostList:  pl.Items,
+		BeforeKey: pl.Paging.Before,
+		AfterKey:  pl.Paging.After,
+	}
+}
+
+// WithPreview sets the Selecta that reads a projection built as of an
+// earlier point in the event log
+func (s Server) WithPreview(preview Selecta) Server {
+	s.preview = preview
+	return s
+}
+
+// QueryPreviewPostList is QueryPostList against the preview projection
+func (s Server) QueryPreviewPostList(limit int, cursor string) (*QueryPostListResponse, error) {
+	if s.preview == nil {
+		return nil, ErrPreviewUnavailable
+	}
+	s.selecta = s.preview
+	return s.QueryPostList(limit, cursor)
+}
+
+// QueryPostList lists the page of posts cursor selects, newest first
+func (s Server) QueryPostList(limit int, cursor string) (*QueryPostListResponse, error) {
+	pl, err := s.selecta.SelectPostList(limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return newQueryPostListResponse(pl), nil
+}
+
+// QueryPostListByType is QueryPostList for the posts of postType only
+func (s Server) QueryPostListByType(postType string, limit int, cursor string) (*QueryPostListResponse, error) {
+	if !mf2.IsPostType(postType) {
+		return nil, ErrUnknownPostType
+	}
+	pl, err := s.selecta.SelectPostListByType(postType, limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return newQueryPostListResponse(pl), nil
+}
+
+// SearchPosts lists the posts matching query, newest first
+func (s Server) SearchPosts(query string, limit int, cursor string) (*QueryPostListResponse, error) {
+	pl, err := s.selecta.SearchPosts(query, limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return newQueryPostListResponse(pl), nil
+}
+
+// Place is where to look for posts or media, either inside Box or, when
+// Radius is set, within Radius kilometres of Lat, Lng
+type Place struct {
+	Box    geo.Box
+	Lat    float64
+	Lng    float64
+	Radius float64
+}
+
+// QueryPostsByPlace lists up to limit of the posts made at place, newest
+// first
+func (s Server) QueryPostsByPlace(place Place, limit int) (*QueryPostListResponse, error) {
+	var pl mf2.PostList
+	var err error
+	if place.Radius > 0 {
+		pl, err = s.selecta.SelectPostsNear(place.Lat, place.Lng, place.Radius, limit)
+	} else {
+		pl, err = s.selecta.SelectPostsInBox(place.Box, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newQueryPostListResponse(pl), nil
+}
+
+// QueryMediaByPlace lists up to limit of the media taken at place, newest
+// first
+func (s Server) QueryMediaByPlace(place Place, limit int) (mf2.MediaList, error) {
+	if place.Radius > 0 {
+		return s.selecta.SelectMediaNear(place.Lat, place.Lng, place.Radius, limit)
+	}
+	return s.selecta.SelectMediaInBox(place.Box, limit)
+}
+
+// QueryArchiveResponse is a page of an archive period along with the post
+// counts of every year and, within a year, of its months
+type QueryArchiveResponse struct {
+	Year      string
+	Month     string
+	Years     []ArchiveLinkYear
+	Months    []ArchiveLinkMonth
+	PostList  []mf2.MicroFormat
+	BeforeKey string
+	AfterKey  string
+}
+
+// QueryArchive lists the posts published in year and month, newest
+// first. The whole archive is listed when year is empty and the whole
+// year when month is empty
+func (s Server) QueryArchive(year, month string, limit int, cursor string) (*QueryArchiveResponse, error) {
+	out := &QueryArchiveResponse{
+		Year:  year,
+		Month: month,
+	}
+
+	years, err := s.selecta.SelectYearList()
+	if err != nil {
+		return nil, err
+	}
+	out.Years = years
+
+	if year != "" {
+		if !archiveHasYear(years, year) {
+			return nil, ErrArchiveNotFound
+		}
+		out.Months, err = s.selecta.SelectMonthList(year)
+		if err != nil {
+			return nil, err
+		}
+		if month != "" && !archiveHasMonth(out.Months, month) {
+			return nil, ErrArchiveNotFound
+		}
+	}
+
+	pl, err := s.selecta.SelectArchivePostList(year, month, limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+	out.PostList = pl.Items
+	out.BeforeKey = pl.Paging.Before
+	out.AfterKey = pl.Paging.After
+	return out, nil
+}
+
+func archiveHasYear(years []ArchiveLinkYear, year string) bool {
+	for _, y := range years {
+		if y.Year == year {
+			return true
+		}
+	}
+	return false
+}
+
+func archiveHasMonth(months []ArchiveLinkMonth, month string) bool {
+	for _, m := range months {
+		if m.Month == month {
+			return true
+		}
+	}
+	return false
+}
+
+// PostRevision is a version of a post along with the properties that
+// changed from the previous version
+type PostRevision struct {
+	Version   int                  `json:"version"`
+	EventType string               `json:"event_type"`
+	Timestamp time.Time            `json:"timestamp"`
+	Changes   []mf2.PropertyChange `json:"changes"`
+	Post      mf2.MicroFormat      `json:"-"`
+}
+
+type PostRevisionsResponse struct {
+	URL       string         `json:"url"`
+	Revisions []PostRevision `json:"revisions"`
+	Selected  PostRevision   `json:"-"`
+}
+
+// ShowPostRevisions lists every revision of a post, selecting the
+// requested revision or the latest when revision is 0
+func (s Server) ShowPostRevisions(url string, revision int) (PostRevisionsResponse, error) {
+	out := PostRevisionsResponse{
+		URL:       url,
+		Revisions: []PostRevision{},
+	}
+
+	history, err := s.el.PostRevisions(url)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to fetch post revisions")
+		return out, err
+	}
+	if len(history) == 0 {
+		return out, ErrPostNotFound
+	}
+
+	previous := mf2.MicroFormat{}
+	for _, rev := range history {
+		out.Revisions = append(out.Revisions, PostRevision{
+			Version:   rev.Version,
+			EventType: rev.EventType,
+			Timestamp: rev.Time(),
+			Changes:   mf2.DiffProperties(previous, rev.Post),
+			Post:      rev.Post,
+		})
+		previous = rev.Post
+	}
+
+	out.Selected = out.Revisions[len(out.Revisions)-1]
+	if revision == 0 {
+		return out, nil
+	}
+	for _, rev := range out.Revisions {
+		if rev.Version == revision {
+			out.Selected = rev
+			return out, nil
+		}
+	}
+	return out, ErrRevisionNotFound
+}
+
+type RevertPostRequest struct {
+	URL      string
+	Revision int
+	Version  int
+}
+
+// RevertPost restores the properties a post had at an earlier revision by
+// appending them as a new update, the history itself is never rewritten
+func (s Server) RevertPost(req RevertPostRequest) (UpdatePostResponse, error) {
+	res := UpdatePostResponse{}
+
+	version, err := s.selecta.SelectPostVersion(req.URL)
+	if err != nil {
+		return res, err
+	}
+	if version == 0 {
+		return res, ErrPostNotFound
+	}
+	res.Version = version
+	if req.Version != 0 && req.Version != version {
+		return res, ErrPreconditionFailed
+	}
+	if req.Revision == 0 {
+		return res, ErrRevisionNotFound
+	}
+
+	revisions, err := s.ShowPostRevisions(req.URL, req.Revision)
+	if err != nil {
+		return res, err
+	}
+
+	latest := revisions.Revisions[len(revisions.Revisions)-1]
+	if len(mf2.DiffProperties(latest.Post, revisions.Selected.Post)) == 0 {
+		return res, nil
+	}
+
+	event := eventlog.NewPostUpdated(revisions.Selected.Post, version)
+	err = s.el.Append(event)
+	if err != nil {
+		return res, err
+	}
+
+	res.Version = version + 1
+	return res, nil
 }
 
 type MediaMetadataResponse struct {
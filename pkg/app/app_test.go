@@ -8,17 +8,24 @@ import (
 	"github.com/go-test/deep"
 	"github.com/j4y_funabashi/inari-micropub/pkg/app"
 	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
 	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
 	"github.com/matryer/is"
 	"github.com/sirupsen/logrus"
 )
 
-type mockEventlog struct{}
+type mockEventlog struct {
+	revisions []eventlog.PostRevision
+}
 
 func (el mockEventlog) Append(ev eventlog.Event) error {
 	return nil
 }
 
+func (el mockEventlog) PostRevisions(postURL string) ([]eventlog.PostRevision, error) {
+	return el.revisions, nil
+}
+
 func newEventlog() mockEventlog {
 	return mockEventlog{}
 }
@@ -47,11 +54,16 @@ func newGeocoder() mockGeocoder {
 }
 
 type mockSelecta struct {
-	years     []app.Year
-	months    []app.Month
-	days      []app.Day
-	mediaList []app.Media
-	media     app.Media
+	years       []app.Year
+	months      []app.Month
+	days        []app.Day
+	mediaList   []app.Media
+	media       app.Media
+	post        mf2.MicroFormat
+	postVersion int
+	postList    []mf2.MicroFormat
+	postYears   []app.ArchiveLinkYear
+	postMonths  []app.ArchiveLinkMonth
 }
 
 var stubYear1 = app.Year{
@@ -96,12 +108,58 @@ func (s mockSelecta) SelectMediaByURL(mediaURL string) (app.Media, error) {
 	return s.media, nil
 }
 
-func (s mockSelecta) SelectPostList(limit int, afterKey string) mf2.PostList {
-	return mf2.PostList{}
+func (s mockSelecta) SelectPostList(limit int, cursor string) (mf2.PostList, error) {
+	return mf2.PostList{Items: s.postList, Paging: &mf2.ListPaging{}}, nil
+}
+
+func (s mockSelecta) SelectPostListByType(postType string, limit int, cursor string) (mf2.PostList, error) {
+	items := []mf2.MicroFormat{}
+	for _, post := range s.postList {
+		if post.PostType() == postType {
+			items = append(items, post)
+		}
+	}
+	return mf2.PostList{Items: items, Paging: &mf2.ListPaging{}}, nil
+}
+
+func (s mockSelecta) SelectArchivePostList(year, month string, limit int, cursor string) (mf2.PostList, error) {
+	return mf2.PostList{Items: s.postList, Paging: &mf2.ListPaging{}}, nil
+}
+
+func (s mockSelecta) SelectYearList() ([]app.ArchiveLinkYear, error) {
+	return s.postYears, nil
+}
+
+func (s mockSelecta) SelectMonthList(year string) ([]app.ArchiveLinkMonth, error) {
+	return s.postMonths, nil
 }
 
 func (s mockSelecta) SelectPostByURL(uid string) (mf2.MicroFormat, error) {
-	return mf2.MicroFormat{}, nil
+	return s.post, nil
+}
+
+func (s mockSelecta) SelectPostVersion(url string) (int, error) {
+	return s.postVersion, nil
+}
+
+func (s mockSelecta) SearchPosts(query string, limit int, cursor string) (mf2.PostList, error) {
+	return mf2.PostList{Items: s.postList, Paging: &mf2.ListPaging{}}, nil
+}
+
+func (s mockSelecta) SelectPostsInBox(box geo.Box, limit int) (mf2.PostList, error) {
+	return mf2.PostList{Items: s.postList, Paging: &mf2.ListPaging{}}, nil
+}
+
+func (s mockSelecta) SelectPostsNear(lat, lng, radius float64, limit int) (mf2.PostList, error) {
+	return mf2.PostList{Paging: &mf2.ListPaging{}}, nil
+}
+
+func (s mockSelecta) SelectMediaInBox(box geo.Box, limit int) (mf2.MediaList, error) {
+	return mf2.MediaList{Paging: &mf2.ListPaging{}}, nil
+}
+
+func (s mockSelecta) SelectMediaNear(lat, lng, radius float64, limit int) (mf2.MediaList, error) {
+	return mf2.MediaList{Paging: &mf2.ListPaging{}}, nil
 }
 
 func newMockSelecta(years []app.Year, months []app.Month) mockSelecta {
@@ -223,6 +281,7 @@ func TestSessionToMf2View(t *testing.T) {
 			},
 			expected: mf2.MicroFormatView{
 				Type:      "entry",
+				PostType:  "note",
 				Published: "1984-01-28T10:10:10Z",
 				Uid:       "test-uuid-123",
 				Url:       "/p/test-uuid-123",
@@ -254,6 +313,7 @@ func TestSessionToMf2View(t *testing.T) {
 			},
 			expected: mf2.MicroFormatView{
 				Type:      "entry",
+				PostType:  "photo",
 				Published: "1984-01-28T10:10:10Z",
 				Uid:       "test-uuid-1234",
 				Url:       "/p/test-uuid-1234",
@@ -326,6 +386,194 @@ func TestShowMediaMonths(t *testing.T) {
 	}
 }
 
+func TestUpdatePost(t *testing.T) {
+	var tests = []struct {
+		name            string
+		postVersion     int
+		requestVersion  int
+		expectedVersion int
+		expectedErr     error
+	}{
+		{
+			name:            "post does not exist",
+			postVersion:     0,
+			requestVersion:  0,
+			expectedVersion: 0,
+			expectedErr:     app.ErrPostNotFound,
+		},
+		{
+			name:            "update without a version",
+			postVersion:     3,
+			requestVersion:  0,
+			expectedVersion: 4,
+			expectedErr:     nil,
+		},
+		{
+			name:            "update with the current version",
+			postVersion:     3,
+			requestVersion:  3,
+			expectedVersion: 4,
+			expectedErr:     nil,
+		},
+		{
+			name:            "update with a stale version",
+			postVersion:     3,
+			requestVersion:  2,
+			expectedVersion: 3,
+			expectedErr:     app.ErrPreconditionFailed,
+		},
+	}
+
+	logger := logrus.New()
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		selecta := mockSelecta{
+			post: mf2.MicroFormat{
+				Type:       []string{"h-entry"},
+				Properties: map[string][]interface{}{},
+			},
+			postVersion: tt.postVersion,
+		}
+
+		t.Run(tt.name, func(t *testing.T) {
+			// arrange
+			sut := app.New(selecta, logger, newMockSessionStore(), newGeocoder(), newEventlog())
+
+			// act
+			result, err := sut.UpdatePost(app.UpdatePostRequest{
+				URL:     "https://example.com/p/1",
+				Version: tt.requestVersion,
+				Properties: map[string][]interface{}{
+					"content": []interface{}{"hello"},
+				},
+			})
+
+			// assert
+			is.Equal(err, tt.expectedErr)
+			is.Equal(result.Version, tt.expectedVersion)
+		})
+	}
+}
+
+func TestShowPostRevisions(t *testing.T) {
+	created := mf2.MicroFormat{
+		Type:       []string{"h-entry"},
+		Properties: map[string][]interface{}{"content": []interface{}{"hello"}},
+	}
+	updated := mf2.MicroFormat{
+		Type:       []string{"h-entry"},
+		Properties: map[string][]interface{}{"content": []interface{}{"hello world"}},
+	}
+	el := mockEventlog{
+		revisions: []eventlog.PostRevision{
+			{Version: 1, EventType: "PostCreated", EventVersion: "20191128101010.0000", Post: created},
+			{Version: 2, EventType: "PostUpdated", EventVersion: "20191129101010.0000", Post: updated},
+		},
+	}
+
+	var tests = []struct {
+		name             string
+		revision         int
+		expectedSelected int
+		expectedErr      error
+	}{
+		{name: "latest revision by default", revision: 0, expectedSelected: 2},
+		{name: "earlier revision", revision: 1, expectedSelected: 1},
+		{name: "missing revision", revision: 3, expectedSelected: 2, expectedErr: app.ErrRevisionNotFound},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// arrange
+			sut := app.New(mockSelecta{}, logrus.New(), newMockSessionStore(), newGeocoder(), el)
+
+			// act
+			result, err := sut.ShowPostRevisions("https://example.com/p/1", tt.revision)
+
+			// assert
+			is.Equal(err, tt.expectedErr)
+			is.Equal(len(result.Revisions), 2)
+			is.Equal(result.Selected.Version, tt.expectedSelected)
+			is.Equal(result.Revisions[1].Changes, []mf2.PropertyChange{
+				{
+					Property: "content",
+					Before:   []interface{}{"hello"},
+					After:    []interface{}{"hello world"},
+				},
+			})
+		})
+	}
+}
+
+func TestRevertPost(t *testing.T) {
+	created := mf2.MicroFormat{
+		Type:       []string{"h-entry"},
+		Properties: map[string][]interface{}{"content": []interface{}{"hello"}},
+	}
+	wiped := mf2.MicroFormat{
+		Type:       []string{"h-entry"},
+		Properties: map[string][]interface{}{},
+	}
+	el := mockEventlog{
+		revisions: []eventlog.PostRevision{
+			{Version: 1, EventType: "PostCreated", Post: created},
+			{Version: 2, EventType: "PostUpdated", Post: wiped},
+		},
+	}
+
+	var tests = []struct {
+		name            string
+		request         app.RevertPostRequest
+		expectedVersion int
+		expectedErr     error
+	}{
+		{
+			name:            "restore earlier revision",
+			request:         app.RevertPostRequest{Revision: 1},
+			expectedVersion: 3,
+		},
+		{
+			name:            "restore latest revision is a no-op",
+			request:         app.RevertPostRequest{Revision: 2},
+			expectedVersion: 2,
+		},
+		{
+			name:            "stale version",
+			request:         app.RevertPostRequest{Revision: 1, Version: 1},
+			expectedVersion: 2,
+			expectedErr:     app.ErrPreconditionFailed,
+		},
+		{
+			name:            "missing revision",
+			request:         app.RevertPostRequest{Revision: 5},
+			expectedVersion: 2,
+			expectedErr:     app.ErrRevisionNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// arrange
+			selecta := mockSelecta{postVersion: 2}
+			sut := app.New(selecta, logrus.New(), newMockSessionStore(), newGeocoder(), el)
+
+			// act
+			tt.request.URL = "https://example.com/p/1"
+			result, err := sut.RevertPost(tt.request)
+
+			// assert
+			is.Equal(err, tt.expectedErr)
+			is.Equal(result.Version, tt.expectedVersion)
+		})
+	}
+}
+
 func TestExtractMediaMetadata(t *testing.T) {
 
 	t1, err := time.Parse(time.RFC3339, "2019-11-09T01:57:37Z")
@@ -370,3 +618,75 @@ func TestExtractMediaMetadata(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryPreviewPostList(t *testing.T) {
+	is := is.New(t)
+
+	live := mockSelecta{postList: []mf2.MicroFormat{{Type: []string{"h-entry"}}}}
+	preview := mockSelecta{postList: []mf2.MicroFormat{}}
+
+	sut := app.New(live, logrus.New(), newMockSessionStore(), newGeocoder(), newEventlog())
+	_, err := sut.QueryPreviewPostList(12, "")
+	is.Equal(err, app.ErrPreviewUnavailable)
+
+	result, err := sut.WithPreview(preview).QueryPreviewPostList(12, "")
+	is.NoErr(err)
+	is.Equal(len(result.PostList), 0)
+
+	result, err = sut.WithPreview(preview).QueryPostList(12, "")
+	is.NoErr(err)
+	is.Equal(len(result.PostList), 1)
+}
+
+func TestQueryPostListByType(t *testing.T) {
+	is := is.New(t)
+
+	note := mf2.MicroFormat{Type: []string{"h-entry"}}
+	event := mf2.MicroFormat{Type: []string{"h-event"}}
+	selecta := mockSelecta{postList: []mf2.MicroFormat{note, event}}
+
+	sut := app.New(selecta, logrus.New(), newMockSessionStore(), newGeocoder(), newEventlog())
+	result, err := sut.QueryPostListByType("event", 12, "")
+	is.NoErr(err)
+	is.Equal(len(result.PostList), 1)
+
+	_, err = sut.QueryPostListByType("entry", 12, "")
+	is.Equal(err, app.ErrUnknownPostType)
+}
+
+func TestQueryArchive(t *testing.T) {
+	selecta := mockSelecta{
+		postList:   []mf2.MicroFormat{{Type: []string{"h-entry"}}},
+		postYears:  []app.ArchiveLinkYear{{Year: "2019", Count: 1}},
+		postMonths: []app.ArchiveLinkMonth{{Month: "06", Count: 1}},
+	}
+
+	var tests = []struct {
+		name        string
+		year        string
+		month       string
+		expectedErr error
+	}{
+		{name: "whole archive"},
+		{name: "year", year: "2019"},
+		{name: "month", year: "2019", month: "06"},
+		{name: "year without posts", year: "2018", expectedErr: app.ErrArchiveNotFound},
+		{name: "month without posts", year: "2019", month: "05", expectedErr: app.ErrArchiveNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			sut := app.New(selecta, logrus.New(), newMockSessionStore(), newGeocoder(), newEventlog())
+
+			result, err := sut.QueryArchive(tt.year, tt.month, 12, "")
+			is.Equal(err, tt.expectedErr)
+			if err != nil {
+				return
+			}
+			is.Equal(len(result.Years), 1)
+			is.Equal(len(result.PostList), 1)
+			is.Equal(len(result.Months) > 0, tt.year != "")
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Package cursor makes the opaque cursors lists are paged by. A cursor
+// names the sort key a page starts from and which way it runs, and is
+// signed so clients can not page by keys they have not been given
+package cursor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+)
+
+// ErrInvalid is returned for a cursor that was not made by this package
+// with the current secret
+var ErrInvalid = errors.New("invalid cursor")
+
+// Secret signs cursors. It is read from CURSOR_SECRET, without one a
+// random secret is used and cursors only last as long as the process
+var Secret = secret()
+
+func secret() []byte {
+	s := os.Getenv("CURSOR_SECRET")
+	if s != "" {
+		return []byte(s)
+	}
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Cursor is a position in a list, the page it starts runs from Key
+// towards older items, or towards newer items when Before is set. The
+// zero Cursor is the first page
+type Cursor struct {
+	Key    string
+	Before bool
+}
+
+// After is the cursor of the page of items older than key
+func After(key string) Cursor {
+	return Cursor{Key: key}
+}
+
+// Before is the cursor of the page of items newer than key
+func Before(key string) Cursor {
+	return Cursor{Key: key, Before: true}
+}
+
+// IsZero reports whether c is the first page
+func (c Cursor) IsZero() bool {
+	return c.Key == ""
+}
+
+// String is the signed, opaque form of c, empty for the zero Cursor
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	payload := c.payload()
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(payload))
+}
+
+// Parse returns the Cursor s is the String of, the zero Cursor for an
+// empty s
+func Parse(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return Cursor{}, ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Cursor{}, ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Cursor{}, ErrInvalid
+	}
+	if !hmac.Equal(mac, sign(payload)) || len(payload) < 2 {
+		return Cursor{}, ErrInvalid
+	}
+
+	switch payload[0] {
+	case 'a':
+		return After(string(payload[1:])), nil
+	case 'b':
+		return Before(string(payload[1:])), nil
+	}
+	return Cursor{}, ErrInvalid
+}
+
+func (c Cursor) payload() []byte {
+	buf := new(bytes.Buffer)
+	if c.Before {
+		buf.WriteByte('b')
+	} else {
+		buf.WriteByte('a')
+	}
+	buf.WriteString(c.Key)
+	return buf.Bytes()
+}
+
+func sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, Secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,68 @@
+package cursor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/cursor"
+	"github.com/matryer/is"
+)
+
+func TestParse(t *testing.T) {
+	after := cursor.After("2019-06-01T08:00:00Zabc").String()
+	before := cursor.Before("2019-06-01T08:00:00Zabc").String()
+
+	var tests = []struct {
+		name        string
+		in          string
+		expected    cursor.Cursor
+		expectedErr error
+	}{
+		{
+			name:     "empty is the first page",
+			in:       "",
+			expected: cursor.Cursor{},
+		},
+		{
+			name:     "after",
+			in:       after,
+			expected: cursor.After("2019-06-01T08:00:00Zabc"),
+		},
+		{
+			name:     "before",
+			in:       before,
+			expected: cursor.Before("2019-06-01T08:00:00Zabc"),
+		},
+		{
+			name:        "raw sort key",
+			in:          "2019-06-01T08:00:00Zabc",
+			expectedErr: cursor.ErrInvalid,
+		},
+		{
+			name:        "direction swapped",
+			in:          strings.Split(before, ".")[0] + "." + strings.Split(after, ".")[1],
+			expectedErr: cursor.ErrInvalid,
+		},
+		{
+			name:        "bad encoding",
+			in:          "!!.!!",
+			expectedErr: cursor.ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			result, err := cursor.Parse(tt.in)
+			is.Equal(err, tt.expectedErr)
+			is.Equal(result, tt.expected)
+		})
+	}
+}
+
+func TestStringIsOpaque(t *testing.T) {
+	is := is.New(t)
+	is.Equal(cursor.Cursor{}.String(), "")
+	is.True(!strings.Contains(cursor.After("2019-06-01").String(), "2019-06-01"))
+	is.True(cursor.After("a").String() != cursor.Before("a").String())
+}
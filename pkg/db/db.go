@@ -3,57 +3,70 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
 
+	"github.com/j4y_funabashi/inari-micropub/pkg/dialect"
 	_ "github.com/lib/pq"
 )
 
-func createDB() string {
-	return `
-CREATE TABLE IF NOT EXISTS "posts" (
-	"id" TEXT PRIMARY KEY,
-	"year" INTEGER NOT NULL,
-	"sort_key" TEXT NOT NULL,
-	"month" INTEGER NOT NULL,
-	"data" TEXT NOT NULL
-);
-CREATE INDEX IF NOT EXISTS "idx_posts_year" ON "posts"("year");
-CREATE INDEX IF NOT EXISTS "idx_posts_month" ON "posts"("month");
-
-CREATE TABLE IF NOT EXISTS "media" (
-	"id" TEXT PRIMARY KEY,
-	"year" INTEGER NOT NULL,
-	"month" INTEGER NOT NULL,
-	"day" INTEGER NOT NULL,
-	"sort_key" TEXT NOT NULL,
-	"data" TEXT NOT NULL
-);
-CREATE INDEX IF NOT EXISTS "idx_media_year" ON "media"("year");
-CREATE INDEX IF NOT EXISTS "idx_media_month" ON "media"("month");
-
-CREATE TABLE IF NOT EXISTS "media_published" (
-	"id" TEXT PRIMARY KEY
-);
-
-CREATE TABLE IF NOT EXISTS "sessions" (
-	"id" TEXT PRIMARY KEY,
-	"data" TEXT NOT NULL
-);
-`
+// OpenDB opens and migrates the database at DATABASE_URL
+func OpenDB() (*sql.DB, error) {
+	return Open(os.Getenv("DATABASE_URL"))
 }
 
-func OpenDB() (*sql.DB, error) {
+// Open opens the database at databaseURL, a SQLite file for sqlite://
+// urls and Postgres otherwise, and applies any pending migrations
+func Open(databaseURL string) (*sql.DB, error) {
 
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	db, err := Connect(databaseURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed opening database: %s", err.Error())
+		return nil, err
 	}
 
-	// ensure DB is provisioned
-	_, err = db.Exec(createDB())
+	_, err = Migrate(db)
+	if err != nil {
+		return nil, fmt.Errorf("migrating database: %v", err)
+	}
+
+	return db, nil
+}
+
+// Connect opens the database at databaseURL without migrating it
+func Connect(databaseURL string) (*sql.DB, error) {
+	d, dsn := dialect.Parse(databaseURL)
+	db, err := sql.Open(d.Driver, dsn)
 	if err != nil {
-		return nil, fmt.Errorf("setting up database: %v", err)
+		return nil, fmt.Errorf("failed opening database: %s", err.Error())
 	}
+	return db, nil
+}
 
+// OpenSchema opens the database with schema as its only search_path, so
+// unqualified table names resolve to that schema. The schema is not
+// provisioned. Only Postgres has schemas
+func OpenSchema(schema string) (*sql.DB, error) {
+	d, dsn := dialect.Parse(os.Getenv("DATABASE_URL"))
+	if d != dialect.Postgres {
+		return nil, fmt.Errorf("%s databases have no schemas", d.Name)
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing database url: %s", err.Error())
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn = dsn + " search_path=" + schema
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening database: %s", err.Error())
+	}
 	return db, nil
 }
@@ -0,0 +1,631 @@
+package db_test
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/cursor"
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+// forEachDatabase runs a test against a fresh SQLite file, and against
+// the Postgres database at TEST_DATABASE_URL when it is set
+func forEachDatabase(t *testing.T, test func(t *testing.T, sqlDB *sql.DB)) {
+	t.Run("sqlite", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "inari")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		sqlDB, err := db.Open("sqlite://" + filepath.Join(dir, "inari.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sqlDB.Close()
+		test(t, sqlDB)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		databaseURL := os.Getenv("TEST_DATABASE_URL")
+		if databaseURL == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		sqlDB, err := db.Open(databaseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sqlDB.Close()
+		for _, stmt := range []string{
+			`DELETE FROM posts`,
+			`DELETE FROM media`,
+			`DELETE FROM media_published`,
+			`DELETE FROM events`,
+			`DELETE FROM outbox`,
+			`UPDATE event_sequence SET last = 0`,
+		} {
+			_, err = sqlDB.Exec(stmt)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		test(t, sqlDB)
+	})
+}
+
+func appendEvents(t *testing.T, sqlDB *sql.DB, events ...eventlog.Event) {
+	el := eventlog.NewEventLog("events", "bucket", nil, sqlDB, logrus.New())
+	for _, event := range events {
+		err := el.Append(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newPhotoPost(uid, published string) mf2.MicroFormat {
+	return mf2.MicroFormat{
+		Type: []string{"h-entry"},
+		Properties: map[string][]interface{}{
+			"uid":       {uid},
+			"url":       {"https://example.com/p/" + uid},
+			"published": {published},
+			"photo":     {"https://media.example.com/" + uid + ".jpg"},
+		},
+	}
+}
+
+func TestSelectPosts(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		appendEvents(
+			t,
+			sqlDB,
+			eventlog.NewPostCreated(newPhotoPost("a", "2018-04-12T09:45:00Z")),
+			eventlog.NewPostCreated(newPhotoPost("b", "2019-06-01T08:00:00Z")),
+			eventlog.NewPostCreated(newPhotoPost("c", "2019-06-02T08:00:00Z")),
+		)
+		selecta := db.NewSelecta(sqlDB)
+
+		first, err := selecta.SelectPostList(2, "")
+		is.NoErr(err)
+		is.Equal(len(first.Items), 2)
+		is.Equal(first.Items[0].GetFirstString("uid"), "c")
+		is.True(first.Paging.After != "")
+
+		rest, err := selecta.SelectPostList(2, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(len(rest.Items), 1)
+		is.Equal(rest.Items[0].GetFirstString("uid"), "a")
+
+		post, err := selecta.SelectPostByURL("https://example.com/p/b")
+		is.NoErr(err)
+		is.Equal(post.GetFirstString("uid"), "b")
+
+		years, err := selecta.SelectYearList()
+		is.NoErr(err)
+		is.Equal(len(years), 2)
+
+		version, err := selecta.SelectPostVersion("https://example.com/p/b")
+		is.NoErr(err)
+		is.Equal(version, 1)
+	})
+}
+
+func TestSelectPostVersionAfterUpdate(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		post := newPhotoPost("a", "2018-04-12T09:45:00Z")
+		appendEvents(t, sqlDB, eventlog.NewPostCreated(post))
+		post.Properties["content"] = []interface{}{"hello"}
+		appendEvents(t, sqlDB, eventlog.NewPostUpdated(post, 1))
+
+		selecta := db.NewSelecta(sqlDB)
+		version, err := selecta.SelectPostVersion("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(version, 2)
+
+		updated, err := selecta.SelectPostByURL("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(updated.GetFirstString("content"), "hello")
+
+		found, err := selecta.SearchPosts("hello", 10, "")
+		is.NoErr(err)
+		is.Equal(len(found.Items), 1)
+
+		version, err = selecta.SelectPostVersion("https://example.com/p/missing")
+		is.NoErr(err)
+		is.Equal(version, 0)
+	})
+}
+
+func TestSelectMedia(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		taken := time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)
+		upload := eventlog.NewMediaUploaded(mf2.MediaMetadata{
+			Uid:      "a",
+			FileKey:  "2019/a.jpg",
+			MimeType: "image/jpeg",
+			DateTime: &taken,
+		})
+		post := newPhotoPost("p", "2019-06-01T09:00:00Z")
+		post.Properties["photo"] = []interface{}{upload.EventData.URL}
+		appendEvents(t, sqlDB, upload, eventlog.NewPostCreated(post))
+		selecta := db.NewSelecta(sqlDB)
+
+		years := selecta.SelectMediaYearList()
+		is.Equal(len(years), 1)
+		is.Equal(years[0].Year, "2019")
+		is.Equal(years[0].Count, 1)
+		is.Equal(years[0].PublishedCount, 1)
+
+		days, err := selecta.SelectMediaDayList("2019", "06")
+		is.NoErr(err)
+		is.Equal(len(days), 1)
+
+		media, err := selecta.SelectMediaDay("2019", "06", "01")
+		is.NoErr(err)
+		is.Equal(len(media), 1)
+		is.Equal(media[0].URL, upload.EventData.URL)
+		is.True(media[0].IsPublished)
+
+		found, err := selecta.SelectMediaByURL(upload.EventData.URL)
+		is.NoErr(err)
+		is.Equal(found.MimeType, "image/jpeg")
+
+		list, err := selecta.SelectMediaList(10, "")
+		is.NoErr(err)
+		is.Equal(len(list.Items), 1)
+	})
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		applied, err := db.Migrate(sqlDB)
+		is.NoErr(err)
+		is.Equal(len(applied), 0)
+
+		status, err := db.Status(sqlDB)
+		is.NoErr(err)
+		is.True(len(status) > 0)
+		for _, s := range status {
+			is.True(s.AppliedAt != nil)
+		}
+	})
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	is := is.New(t)
+	dir, err := ioutil.TempDir("", "inari")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	sqlDB, err := db.Connect("sqlite://" + filepath.Join(dir, "inari.db"))
+	is.NoErr(err)
+	defer sqlDB.Close()
+
+	status, err := db.Status(sqlDB)
+	is.NoErr(err)
+	is.True(len(status) > 0)
+	is.Equal(status[0].AppliedAt, nil)
+
+	applied, err := db.Migrate(sqlDB)
+	is.NoErr(err)
+	is.Equal(len(applied), len(status))
+}
+
+// TestProjectionSchemaMatchesMigrations guards rebuilds, which create the
+// projection tables from eventlog.ProjectionSchema rather than migrating
+func TestProjectionSchemaMatchesMigrations(t *testing.T) {
+	is := is.New(t)
+	dir, err := ioutil.TempDir("", "inari")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	migrated, err := db.Open("sqlite://" + filepath.Join(dir, "migrated.db"))
+	is.NoErr(err)
+	defer migrated.Close()
+
+	rebuilt, err := db.Connect("sqlite://" + filepath.Join(dir, "rebuilt.db"))
+	is.NoErr(err)
+	defer rebuilt.Close()
+	_, err = rebuilt.Exec(eventlog.ProjectionSchema())
+	is.NoErr(err)
+
+	for _, table := range []string{"posts", "media", "media_published"} {
+		is.Equal(tableInfo(t, rebuilt, table), tableInfo(t, migrated, table))
+	}
+}
+
+func tableInfo(t *testing.T, sqlDB *sql.DB, table string) []string {
+	rows, err := sqlDB.Query(`SELECT name, type, "notnull", COALESCE(dflt_value, ''), pk FROM pragma_table_info($1) ORDER BY name`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var name, colType, dflt string
+		var notNull, pk int
+		err := rows.Scan(&name, &colType, &notNull, &dflt, &pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, fmt.Sprintf("%s %s %d %s %d", name, colType, notNull, dflt, pk))
+	}
+	return columns
+}
+
+func TestSearchPosts(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		hill := newPhotoPost("a", "2018-04-12T09:45:00Z")
+		hill.Properties["content"] = []interface{}{"walking up the hill"}
+		hills := newPhotoPost("b", "2019-06-01T08:00:00Z")
+		hills.Properties["category"] = []interface{}{"hill", "walking"}
+		ramen := newPhotoPost("c", "2019-06-02T08:00:00Z")
+		ramen.Properties["name"] = []interface{}{"100% ramen"}
+		appendEvents(
+			t,
+			sqlDB,
+			eventlog.NewPostCreated(hill),
+			eventlog.NewPostCreated(hills),
+			eventlog.NewPostCreated(ramen),
+		)
+		selecta := db.NewSelecta(sqlDB)
+
+		first, err := selecta.SearchPosts("walking hill", 1, "")
+		is.NoErr(err)
+		is.Equal(len(first.Items), 1)
+		is.Equal(first.Items[0].GetFirstString("uid"), "b")
+		is.True(first.Paging.After != "")
+
+		rest, err := selecta.SearchPosts("walking hill", 1, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(len(rest.Items), 1)
+		is.Equal(rest.Items[0].GetFirstString("uid"), "a")
+		is.Equal(rest.Paging.After, "")
+
+		ramens, err := selecta.SearchPosts("Ramen", 10, "")
+		is.NoErr(err)
+		is.Equal(len(ramens.Items), 1)
+
+		none, err := selecta.SearchPosts("  ", 10, "")
+		is.NoErr(err)
+		is.Equal(len(none.Items), 0)
+	})
+}
+
+func TestSelectPostsOfEveryType(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		note := newPhotoPost("a", "2019-06-01T10:00:00+02:00")
+		delete(note.Properties, "photo")
+		note.Properties["content"] = []interface{}{"just a note"}
+		like := newPhotoPost("b", "2019-06-01T09:30:00Z")
+		delete(like.Properties, "photo")
+		like.Properties["like-of"] = []interface{}{"https://example.com/liked"}
+		appendEvents(
+			t,
+			sqlDB,
+			eventlog.NewPostCreated(note),
+			eventlog.NewPostCreated(like),
+			eventlog.NewPostCreated(newPhotoPost("c", "2019-06-01T09:00:00Z")),
+		)
+		selecta := db.NewSelecta(sqlDB)
+
+		first, err := selecta.SelectPostList(2, "")
+		is.NoErr(err)
+		is.Equal(len(first.Items), 2)
+		is.Equal(first.Items[0].GetFirstString("uid"), "b")
+		is.Equal(first.Items[1].GetFirstString("uid"), "c")
+
+		rest, err := selecta.SelectPostList(2, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(len(rest.Items), 1)
+		is.Equal(rest.Items[0].GetFirstString("uid"), "a")
+		is.Equal(rest.Items[0].PostType(), "note")
+	})
+}
+
+func TestSelectPostListByType(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		note := newPhotoPost("a", "2019-06-01T08:00:00Z")
+		delete(note.Properties, "photo")
+		event := newPhotoPost("b", "2019-06-02T08:00:00Z")
+		event.Type = []string{"h-event"}
+		appendEvents(
+			t,
+			sqlDB,
+			eventlog.NewPostCreated(newPhotoPost("c", "2019-06-01T09:00:00Z")),
+			eventlog.NewPostCreated(note),
+			eventlog.NewPostCreated(event),
+			eventlog.NewPostCreated(newPhotoPost("d", "2019-06-03T08:00:00Z")),
+		)
+		selecta := db.NewSelecta(sqlDB)
+
+		first, err := selecta.SelectPostListByType("photo", 1, "")
+		is.NoErr(err)
+		is.Equal(len(first.Items), 1)
+		is.Equal(first.Items[0].GetFirstString("uid"), "d")
+		is.True(first.Paging.After != "")
+
+		rest, err := selecta.SelectPostListByType("photo", 1, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(len(rest.Items), 1)
+		is.Equal(rest.Items[0].GetFirstString("uid"), "c")
+		is.Equal(rest.Paging.After, "")
+
+		events, err := selecta.SelectPostListByType("event", 10, "")
+		is.NoErr(err)
+		is.Equal(len(events.Items), 1)
+		is.Equal(events.Items[0].GetFirstString("uid"), "b")
+
+		all, err := selecta.SelectPostListByType("", 10, "")
+		is.NoErr(err)
+		is.Equal(len(all.Items), 4)
+	})
+}
+
+func TestSelectArchive(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		appendEvents(
+			t,
+			sqlDB,
+			eventlog.NewPostCreated(newPhotoPost("a", "2018-12-31T09:00:00Z")),
+			eventlog.NewPostCreated(newPhotoPost("b", "2019-01-01T09:00:00Z")),
+			eventlog.NewPostCreated(newPhotoPost("c", "2019-06-01T09:00:00Z")),
+			eventlog.NewPostCreated(newPhotoPost("d", "2019-06-02T09:00:00Z")),
+		)
+		selecta := db.NewSelecta(sqlDB)
+
+		years, err := selecta.SelectYearList()
+		is.NoErr(err)
+		is.Equal(years, []app.ArchiveLinkYear{{Year: "2019", Count: 3}, {Year: "2018", Count: 1}})
+
+		months, err := selecta.SelectMonthList("2019")
+		is.NoErr(err)
+		is.Equal(months, []app.ArchiveLinkMonth{{Month: "06", Count: 2}, {Month: "01", Count: 1}})
+
+		first, err := selecta.SelectArchivePostList("2019", "06", 1, "")
+		is.NoErr(err)
+		is.Equal(len(first.Items), 1)
+		is.Equal(first.Items[0].GetFirstString("uid"), "d")
+
+		rest, err := selecta.SelectArchivePostList("2019", "06", 1, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(len(rest.Items), 1)
+		is.Equal(rest.Items[0].GetFirstString("uid"), "c")
+		is.Equal(rest.Paging.After, "")
+
+		year, err := selecta.SelectArchivePostList("2019", "", 10, "")
+		is.NoErr(err)
+		is.Equal(len(year.Items), 3)
+	})
+}
+
+func TestSelectArchiveInUTC(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		// published on new year's day in Paris, still 2018 in UTC
+		appendEvents(
+			t,
+			sqlDB,
+			eventlog.NewPostCreated(newPhotoPost("a", "2019-01-01T00:30:00+01:00")),
+		)
+		selecta := db.NewSelecta(sqlDB)
+
+		months, err := selecta.SelectMonthList("2018")
+		is.NoErr(err)
+		is.Equal(months, []app.ArchiveLinkMonth{{Month: "12", Count: 1}})
+	})
+}
+
+func TestPagingBothWays(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		events := []eventlog.Event{}
+		for i, uid := range []string{"a", "b", "c", "d", "e"} {
+			published := fmt.Sprintf("2019-06-0%dT08:00:00Z", i+1)
+			events = append(events, eventlog.NewPostCreated(newPhotoPost(uid, published)))
+		}
+		appendEvents(t, sqlDB, events...)
+		selecta := db.NewSelecta(sqlDB)
+
+		uids := func(pl mf2.PostList) string {
+			out := ""
+			for _, item := range pl.Items {
+				out += item.GetFirstString("uid")
+			}
+			return out
+		}
+
+		first, err := selecta.SelectPostList(2, "")
+		is.NoErr(err)
+		is.Equal(uids(first), "ed")
+		is.Equal(first.Paging.Before, "")
+
+		second, err := selecta.SelectPostList(2, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(uids(second), "cb")
+		is.True(second.Paging.Before != "")
+
+		last, err := selecta.SelectPostList(2, second.Paging.After)
+		is.NoErr(err)
+		is.Equal(uids(last), "a")
+		is.Equal(last.Paging.After, "")
+
+		back, err := selecta.SelectPostList(2, last.Paging.Before)
+		is.NoErr(err)
+		is.Equal(uids(back), "cb")
+		is.True(back.Paging.After != "")
+
+		top, err := selecta.SelectPostList(2, back.Paging.Before)
+		is.NoErr(err)
+		is.Equal(uids(top), "ed")
+		is.Equal(top.Paging.Before, "")
+		is.True(top.Paging.After != "")
+
+		_, err = selecta.SelectPostList(2, "2019-06-03T08:00:00Zc")
+		is.Equal(err, cursor.ErrInvalid)
+
+		_, err = selecta.SelectMediaList(2, "2019-06-03T08:00:00Zc")
+		is.Equal(err, cursor.ErrInvalid)
+	})
+}
+
+func TestSelectMediaListPaging(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		for i, uid := range []string{"a", "b", "c"} {
+			taken := time.Date(2019, 6, i+1, 8, 0, 0, 0, time.UTC)
+			appendEvents(t, sqlDB, eventlog.NewMediaUploaded(mf2.MediaMetadata{
+				Uid:      uid,
+				FileKey:  "2019/" + uid + ".jpg",
+				MimeType: "image/jpeg",
+				DateTime: &taken,
+			}))
+		}
+		selecta := db.NewSelecta(sqlDB)
+
+		first, err := selecta.SelectMediaList(2, "")
+		is.NoErr(err)
+		is.Equal(len(first.Items), 2)
+		is.Equal(first.Items[0].Uid, "c")
+		is.Equal(first.Paging.Before, "")
+
+		rest, err := selecta.SelectMediaList(2, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(len(rest.Items), 1)
+		is.Equal(rest.Items[0].Uid, "a")
+		is.Equal(rest.Paging.After, "")
+
+		back, err := selecta.SelectMediaList(2, rest.Paging.Before)
+		is.NoErr(err)
+		is.Equal(len(back.Items), 2)
+		is.Equal(back.Items[0].Uid, "c")
+		is.Equal(back.Items[1].Uid, "b")
+	})
+}
+
+func TestSelectByPlace(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		places := []struct {
+			uid      string
+			lat, lng float64
+		}{
+			{"leeds", 53.8, -1.55},
+			{"otley", 53.905, -1.69},
+			{"london", 51.5, -0.12},
+			{"fiji", -17.7, 178.4},
+			{"samoa", -13.8, -171.8},
+			{"nowhere", 0, 0},
+		}
+		for i, p := range places {
+			published := time.Date(2019, 6, i+1, 8, 0, 0, 0, time.UTC)
+			post := newPhotoPost(p.uid, published.Format(time.RFC3339))
+			media := mf2.MediaMetadata{
+				Uid:      p.uid,
+				FileKey:  "2019/" + p.uid + ".jpg",
+				MimeType: "image/jpeg",
+				DateTime: &published,
+			}
+			if p.uid != "nowhere" {
+				post.Properties["location"] = []interface{}{fmt.Sprintf("geo:%f,%f", p.lat, p.lng)}
+				media.Lat, media.Lng = p.lat, p.lng
+			}
+			appendEvents(t, sqlDB, eventlog.NewMediaUploaded(media), eventlog.NewPostCreated(post))
+		}
+		selecta := db.NewSelecta(sqlDB)
+
+		uids := func(pl mf2.PostList) []string {
+			out := []string{}
+			for _, item := range pl.Items {
+				out = append(out, item.GetFirstString("uid"))
+			}
+			return out
+		}
+
+		yorkshire := geo.Box{West: -2, South: 53.5, East: -1, North: 54}
+		posts, err := selecta.SelectPostsInBox(yorkshire, 10)
+		is.NoErr(err)
+		is.Equal(uids(posts), []string{"otley", "leeds"})
+
+		pacific := geo.Box{West: 170, South: -20, East: -170, North: -10}
+		posts, err = selecta.SelectPostsInBox(pacific, 10)
+		is.NoErr(err)
+		is.Equal(uids(posts), []string{"samoa", "fiji"})
+
+		posts, err = selecta.SelectPostsInBox(geo.World, 10)
+		is.NoErr(err)
+		is.Equal(len(posts.Items), 5) // nowhere has no location
+
+		posts, err = selecta.SelectPostsNear(53.8, -1.55, 5, 10)
+		is.NoErr(err)
+		is.Equal(uids(posts), []string{"leeds"})
+
+		posts, err = selecta.SelectPostsNear(53.8, -1.55, 300, 1)
+		is.NoErr(err)
+		is.Equal(uids(posts), []string{"london"})
+
+		media, err := selecta.SelectMediaInBox(yorkshire, 10)
+		is.NoErr(err)
+		is.Equal(len(media.Items), 2)
+		is.Equal(media.Items[0].Uid, "otley")
+
+		media, err = selecta.SelectMediaInBox(geo.World, 10)
+		is.NoErr(err)
+		is.Equal(len(media.Items), 5)
+
+		media, err = selecta.SelectMediaNear(-17.7, 179.9, 400, 10)
+		is.NoErr(err)
+		is.Equal(len(media.Items), 1)
+		is.Equal(media.Items[0].Uid, "fiji")
+	})
+}
+
+func TestPreviewUnavailableBeforeItIsBuilt(t *testing.T) {
+	is := is.New(t)
+	dir, err := ioutil.TempDir("", "inari")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	// nothing has been migrated, as with a preview schema never built
+	sqlDB, err := db.Connect("sqlite://" + filepath.Join(dir, "preview.db"))
+	is.NoErr(err)
+	defer sqlDB.Close()
+
+	_, err = db.NewPreviewSelecta(sqlDB).SelectPostList(10, "")
+	is.Equal(err, app.ErrPreviewUnavailable)
+	_, err = db.NewSelecta(sqlDB).SelectPostList(10, "")
+	is.True(err != nil && err != app.ErrPreviewUnavailable)
+}
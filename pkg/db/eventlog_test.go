@@ -0,0 +1,444 @@
+package db_test
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/dialect"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/memory"
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+// objectStore is the memory ObjectStore with faults tests can turn on.
+// It counts reads, refuses writes to keys containing fail, and accepts
+// bundles without saving them when dropBundles is set
+type objectStore struct {
+	memory.ObjectStore
+	reads       map[string]int
+	fail        string
+	dropBundles bool
+}
+
+func newObjectStore() *objectStore {
+	return &objectStore{ObjectStore: memory.NewObjectStore(), reads: map[string]int{}}
+}
+
+func (o *objectStore) ReadObject(key, bucket string) (*bytes.Buffer, error) {
+	o.reads[key]++
+	return o.ObjectStore.ReadObject(key, bucket)
+}
+
+func (o *objectStore) WriteObject(key, bucket string, body io.Reader, isPrivate bool) error {
+	if o.fail != "" && strings.Contains(key, o.fail) {
+		return errors.New("write refused")
+	}
+	if o.dropBundles && strings.HasPrefix(key, "archive/") {
+		return nil
+	}
+	return o.ObjectStore.WriteObject(key, bucket, body, isPrivate)
+}
+
+// bundleReads is how often bundles of compacted events have been read
+func (o *objectStore) bundleReads() int {
+	count := 0
+	for key, n := range o.reads {
+		if strings.HasPrefix(key, "archive/") {
+			count += n
+		}
+	}
+	return count
+}
+
+// count is how many objects have keys starting with prefix
+func (o *objectStore) count(t *testing.T, prefix string) int {
+	keys, err := o.ListKeys("bucket", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(keys)
+}
+
+func newEventLog(store eventlog.ObjectStore, sqlDB *sql.DB) eventlog.EventLog {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	return eventlog.NewEventLog("events", "bucket", store, sqlDB, logger)
+}
+
+// forget empties the log and the projection so events can be replayed
+// into them
+func forget(t *testing.T, sqlDB *sql.DB) {
+	for _, stmt := range []string{
+		`DELETE FROM posts`,
+		`DELETE FROM media`,
+		`DELETE FROM media_published`,
+		`DELETE FROM events`,
+		`DELETE FROM outbox`,
+		`UPDATE event_sequence SET last = 0`,
+	} {
+		_, err := sqlDB.Exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAppendRejectsStaleUpdate(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		el := newEventLog(newObjectStore(), sqlDB)
+
+		post := newPhotoPost("a", "2018-04-12T09:45:00Z")
+		is.NoErr(el.Append(eventlog.NewPostCreated(post)))
+		post.Properties["content"] = []interface{}{"first"}
+		is.NoErr(el.Append(eventlog.NewPostUpdated(post, 1)))
+		post.Properties["content"] = []interface{}{"stale"}
+		is.Equal(el.Append(eventlog.NewPostUpdated(post, 1)), eventlog.ErrVersionConflict)
+		is.Equal(el.Append(eventlog.NewPostUpdated(newPhotoPost("missing", "2018-04-12T09:45:00Z"), 1)), eventlog.ErrVersionConflict)
+
+		var count int
+		is.NoErr(sqlDB.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&count))
+		is.Equal(count, 2)
+
+		selecta := db.NewSelecta(sqlDB)
+		version, err := selecta.SelectPostVersion("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(version, 2)
+	})
+}
+
+func TestReplayPostWithTwoUpdates(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		store := newObjectStore()
+		el := newEventLog(store, sqlDB)
+
+		post := newPhotoPost("a", "2018-04-12T09:45:00Z")
+		is.NoErr(el.Append(eventlog.NewPostCreated(post)))
+		post.Properties["content"] = []interface{}{"first"}
+		is.NoErr(el.Append(eventlog.NewPostUpdated(post, 1)))
+		post.Properties["content"] = []interface{}{"second"}
+		is.NoErr(el.Append(eventlog.NewPostUpdated(post, 2)))
+		shipped, err := el.Ship()
+		is.NoErr(err)
+		is.Equal(shipped, 3)
+
+		forget(t, sqlDB)
+		is.NoErr(el.Replay())
+
+		selecta := db.NewSelecta(sqlDB)
+		version, err := selecta.SelectPostVersion("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(version, 3)
+		replayed, err := selecta.SelectPostByURL("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(replayed.GetFirstString("content"), "second")
+
+		// reapplying the recorded events lands on the same version
+		is.NoErr(el.ReplayWith(eventlog.ReplayOptions{Reapply: true}))
+		version, err = selecta.SelectPostVersion("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(version, 3)
+		replayed, err = selecta.SelectPostByURL("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(replayed.GetFirstString("content"), "second")
+	})
+}
+
+func TestReplaySkipsRecordedBundles(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		store := newObjectStore()
+		el := newEventLog(store, sqlDB)
+
+		is.NoErr(el.Append(eventlog.NewPostCreated(newPhotoPost("a", "2018-04-12T09:45:00Z"))))
+		is.NoErr(el.Append(eventlog.NewPostCreated(newPhotoPost("b", "2018-04-13T09:45:00Z"))))
+		_, err := el.Ship()
+		is.NoErr(err)
+		is.NoErr(el.Snapshot())
+		is.NoErr(el.Compact())
+		is.Equal(store.count(t, "archive/"), 1)
+		// compacting reads the bundle back, only replays are counted
+		for key := range store.reads {
+			delete(store.reads, key)
+		}
+
+		// every bundled event is recorded already
+		is.NoErr(el.Replay())
+		is.Equal(store.bundleReads(), 0)
+
+		// the snapshot covers the bundle
+		forget(t, sqlDB)
+		is.NoErr(el.Replay())
+		is.Equal(store.bundleReads(), 0)
+		version, err := db.NewSelecta(sqlDB).SelectPostVersion("https://example.com/p/b")
+		is.NoErr(err)
+		is.Equal(version, 1)
+
+		// the snapshot is not restored over rows it does not know about,
+		// so the events are read from the bundle instead
+		forget(t, sqlDB)
+		_, err = sqlDB.Exec(`INSERT INTO media_published (id) VALUES ('https://media.example.com/stray.jpg')`)
+		is.NoErr(err)
+		is.NoErr(el.Replay())
+		is.Equal(store.bundleReads(), 1)
+		version, err = db.NewSelecta(sqlDB).SelectPostVersion("https://example.com/p/b")
+		is.NoErr(err)
+		is.Equal(version, 1)
+	})
+}
+
+func unshipped(t *testing.T, sqlDB *sql.DB) int {
+	var count int
+	err := sqlDB.QueryRow(`SELECT COUNT(*) FROM events WHERE NOT shipped`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestShip(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		store := newObjectStore()
+		el := newEventLog(store, sqlDB)
+
+		events := []eventlog.PostCreatedEvent{
+			eventlog.NewPostCreated(newPhotoPost("a", "2018-04-12T09:45:00Z")),
+			eventlog.NewPostCreated(newPhotoPost("b", "2018-04-13T09:45:00Z")),
+			eventlog.NewPostCreated(newPhotoPost("c", "2018-04-14T09:45:00Z")),
+		}
+		for _, event := range events {
+			is.NoErr(el.Append(event))
+		}
+
+		// the upload of b fails, a is still shipped
+		store.fail = events[1].EventID
+		shipped, err := el.Ship()
+		is.True(err != nil)
+		is.Equal(shipped, 1)
+		is.Equal(store.count(t, ""), 1)
+		is.Equal(unshipped(t, sqlDB), 2)
+
+		// the next run ships the rest
+		store.fail = ""
+		shipped, err = el.Ship()
+		is.NoErr(err)
+		is.Equal(shipped, 2)
+		is.Equal(unshipped(t, sqlDB), 0)
+		is.Equal(store.count(t, "events/"), 3)
+
+		// and nothing is shipped twice
+		shipped, err = el.Ship()
+		is.NoErr(err)
+		is.Equal(shipped, 0)
+	})
+}
+
+func TestImportRefusesSequencesTakenByUnshippedEvents(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+
+		// export a log holding one event at sequence 1
+		source := newEventLog(newObjectStore(), sqlDB)
+		is.NoErr(source.Append(eventlog.NewPostCreated(newPhotoPost("a", "2018-04-12T09:45:00Z"))))
+		_, err := source.Ship()
+		is.NoErr(err)
+		archive := &bytes.Buffer{}
+		_, err = source.Export(archive)
+		is.NoErr(err)
+		forget(t, sqlDB)
+
+		// a different event is journaled at sequence 1 but never shipped
+		store := newObjectStore()
+		target := newEventLog(store, sqlDB)
+		is.NoErr(target.Append(eventlog.NewPostCreated(newPhotoPost("b", "2018-04-13T09:45:00Z"))))
+
+		_, err = target.Import(bytes.NewReader(archive.Bytes()))
+		is.True(err != nil)
+		is.Equal(store.count(t, ""), 0)
+	})
+}
+
+func TestReplayDryRun(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		store := newObjectStore()
+		el := newEventLog(store, sqlDB)
+
+		post := newPhotoPost("a", "2018-04-12T09:45:00Z")
+		created := eventlog.NewPostCreated(post)
+		is.NoErr(el.Append(created))
+		post.Properties["content"] = []interface{}{"first"}
+		updated := eventlog.NewPostUpdated(post, 1)
+		is.NoErr(el.Append(updated))
+		_, err := el.Ship()
+		is.NoErr(err)
+
+		out := new(bytes.Buffer)
+		is.NoErr(el.ReplayWith(eventlog.ReplayOptions{DryRun: true, Out: out}))
+		is.True(strings.Contains(out.String(), "-- skip 1 "+created.EventVersion+" PostCreated "+created.EventID))
+		is.True(strings.Contains(out.String(), "-- skip 2 "+updated.EventVersion+" PostUpdated "+updated.EventID))
+
+		out.Reset()
+		is.NoErr(el.ReplayWith(eventlog.ReplayOptions{DryRun: true, Reapply: true, Out: out}))
+		is.True(strings.Contains(out.String(), "-- reapply 2 "+updated.EventVersion+" PostUpdated "+updated.EventID))
+		is.True(strings.Contains(out.String(), "UPDATE posts"))
+	})
+}
+
+func TestPostRevisions(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		el := newEventLog(newObjectStore(), sqlDB)
+
+		post := newPhotoPost("a", "2018-04-12T09:45:00Z")
+		is.NoErr(el.Append(eventlog.NewPostCreated(post)))
+		is.NoErr(el.Append(eventlog.NewPostCreated(newPhotoPost("b", "2018-04-13T09:45:00Z"))))
+		post.Properties["content"] = []interface{}{"first"}
+		is.NoErr(el.Append(eventlog.NewPostUpdated(post, 1)))
+
+		// events recorded before post_url existed are matched on their data
+		_, err := sqlDB.Exec(`UPDATE events SET post_url = NULL WHERE sequence < 3`)
+		is.NoErr(err)
+
+		revisions, err := el.PostRevisions("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(len(revisions), 2)
+		is.Equal(revisions[0].EventType, "PostCreated")
+		is.Equal(revisions[0].Version, 1)
+		is.Equal(revisions[1].EventType, "PostUpdated")
+		is.Equal(revisions[1].Version, 2)
+		is.Equal(revisions[1].Post.GetFirstString("content"), "first")
+	})
+}
+
+func TestRebuild(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		el := newEventLog(newObjectStore(), sqlDB)
+
+		post := newPhotoPost("a", "2018-04-12T09:45:00Z")
+		is.NoErr(el.Append(eventlog.NewPostCreated(post)))
+		post.Properties["content"] = []interface{}{"first"}
+		is.NoErr(el.Append(eventlog.NewPostUpdated(post, 1)))
+		is.NoErr(el.Append(eventlog.NewPostCreated(newPhotoPost("b", "2018-04-13T09:45:00Z"))))
+
+		// a projection that drifted from the log is put right
+		_, err := sqlDB.Exec(`UPDATE posts SET data = '{}', version = 7`)
+		is.NoErr(err)
+		is.NoErr(el.Rebuild(false))
+
+		selecta := db.NewSelecta(sqlDB)
+		version, err := selecta.SelectPostVersion("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(version, 2)
+		rebuilt, err := selecta.SelectPostByURL("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(rebuilt.GetFirstString("content"), "first")
+
+		// rows the log does not know about are only dropped when allowed
+		_, err = sqlDB.Exec(`INSERT INTO media_published (id) VALUES ('https://media.example.com/stray.jpg')`)
+		is.NoErr(err)
+		is.True(el.Rebuild(false) != nil)
+		is.NoErr(el.Rebuild(true))
+	})
+}
+
+func TestBuildAsOfNeedsPostgres(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		if dialect.Of(sqlDB) != dialect.SQLite {
+			t.Skip("postgres builds previews")
+		}
+		is := is.New(t)
+		el := newEventLog(newObjectStore(), sqlDB)
+
+		_, err := el.BuildAsOf("preview", 1, time.Time{})
+		is.True(err != nil)
+	})
+}
+
+func TestCompactKeepsEventsUntilTheBundleIsSaved(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		store := newObjectStore()
+		store.dropBundles = true
+		el := newEventLog(store, sqlDB)
+
+		is.NoErr(el.Append(eventlog.NewPostCreated(newPhotoPost("a", "2018-04-12T09:45:00Z"))))
+		is.NoErr(el.Append(eventlog.NewPostCreated(newPhotoPost("b", "2018-04-13T09:45:00Z"))))
+		_, err := el.Ship()
+		is.NoErr(err)
+		is.NoErr(el.Snapshot())
+
+		is.True(el.Compact() != nil)
+		is.Equal(store.count(t, "events/"), 2)
+	})
+}
+
+func outboxSize(t *testing.T, sqlDB *sql.DB) int {
+	var count int
+	err := sqlDB.QueryRow(`SELECT COUNT(*) FROM outbox`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestDispatch(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		el := newEventLog(newObjectStore(), sqlDB)
+		for _, uid := range []string{"a", "b", "c"} {
+			is.NoErr(el.Append(eventlog.NewPostCreated(newPhotoPost(uid, "2018-04-12T09:45:00Z"))))
+		}
+
+		logger := logrus.New()
+		logger.Out = ioutil.Discard
+		dispatcher := eventlog.NewDispatcher(sqlDB, logger)
+
+		// appends are not held up while a handler runs
+		delivered := []int64{}
+		dispatcher.Subscribe("appender", func(h eventlog.EventHeader, event eventlog.Event) error {
+			if len(delivered) == 0 {
+				err := el.Append(eventlog.NewPostCreated(newPhotoPost("d", "2018-04-12T09:45:00Z")))
+				if err != nil {
+					return err
+				}
+			}
+			delivered = append(delivered, h.EventSequence)
+			return nil
+		})
+		// the outbox is kept for a subscriber that has not had every event
+		failing := true
+		dispatcher.Subscribe("failing", func(h eventlog.EventHeader, event eventlog.Event) error {
+			if failing && h.EventSequence == 2 {
+				return errors.New("hub is down")
+			}
+			return nil
+		})
+
+		dispatcher.Dispatch()
+		is.Equal(delivered, []int64{1, 2, 3})
+		is.Equal(outboxSize(t, sqlDB), 3)
+
+		// the failing subscriber waits out its backoff
+		failing = false
+		dispatcher.Dispatch()
+		is.Equal(delivered, []int64{1, 2, 3, 4})
+		is.Equal(outboxSize(t, sqlDB), 3)
+
+		_, err := sqlDB.Exec(`UPDATE subscriber_cursors SET retry_at = NULL`)
+		is.NoErr(err)
+		dispatcher.Dispatch()
+		is.Equal(outboxSize(t, sqlDB), 0)
+	})
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+// SelectPostsInBox returns up to limit of the posts made inside box,
+// newest first
+func (s Selecta) SelectPostsInBox(box geo.Box, limit int) (mf2.PostList, error) {
+	return s.selectPostsInBox(box, limit, nil)
+}
+
+// SelectPostsNear returns up to limit of the posts made within radius
+// kilometres of lat, lng, newest first
+func (s Selecta) SelectPostsNear(lat, lng, radius float64, limit int) (mf2.PostList, error) {
+	return s.selectPostsInBox(geo.BoxAround(lat, lng, radius), limit, func(pLat, pLng float64) bool {
+		return geo.Distance(lat, lng, pLat, pLng) <= radius
+	})
+}
+
+// SelectMediaInBox returns up to limit of the media taken inside box,
+// newest first
+func (s Selecta) SelectMediaInBox(box geo.Box, limit int) (mf2.MediaList, error) {
+	return s.selectMediaInBox(box, limit, nil)
+}
+
+// SelectMediaNear returns up to limit of the media taken within radius
+// kilometres of lat, lng, newest first
+func (s Selecta) SelectMediaNear(lat, lng, radius float64, limit int) (mf2.MediaList, error) {
+	return s.selectMediaInBox(geo.BoxAround(lat, lng, radius), limit, func(mLat, mLng float64) bool {
+		return geo.Distance(lat, lng, mLat, mLng) <= radius
+	})
+}
+
+// selectPostsInBox returns the posts inside box that keep, when it is not
+// nil, also holds for
+func (s Selecta) selectPostsInBox(box geo.Box, limit int, keep func(lat, lng float64) bool) (mf2.PostList, error) {
+	postList := mf2.PostList{
+		Paging: &mf2.ListPaging{},
+	}
+
+	where, args := boxCondition(box, "posts")
+	query := `SELECT data, lat, lng FROM posts WHERE ` + where + ` ORDER BY sort_key DESC`
+	if keep == nil {
+		args = append(args, limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return postList, err
+	}
+	defer rows.Close()
+
+	for len(postList.Items) < limit && rows.Next() {
+		var mfJSON string
+		var lat, lng float64
+		err := rows.Scan(&mfJSON, &lat, &lng)
+		if err != nil {
+			return postList, err
+		}
+		if keep != nil && !keep(lat, lng) {
+			continue
+		}
+		mf := mf2.MicroFormat{}
+		err = json.NewDecoder(strings.NewReader(mfJSON)).Decode(&mf)
+		if err != nil {
+			return postList, err
+		}
+		postList.Add(mf)
+	}
+	return postList, rows.Err()
+}
+
+// selectMediaInBox returns the media inside box that keep, when it is not
+// nil, also holds for
+func (s Selecta) selectMediaInBox(box geo.Box, limit int, keep func(lat, lng float64) bool) (mf2.MediaList, error) {
+	mediaList := mf2.MediaList{
+		Paging: &mf2.ListPaging{},
+	}
+
+	where, args := boxCondition(box, "media")
+	query := `SELECT data, COALESCE(media_published.id, '0'), lat, lng
+FROM media
+LEFT JOIN media_published ON media.id = media_published.id
+WHERE ` + where + ` ORDER BY sort_key DESC`
+	if keep == nil {
+		args = append(args, limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return mediaList, err
+	}
+	defer rows.Close()
+
+	for len(mediaList.Items) < limit && rows.Next() {
+		var mfJSON, isPublished string
+		var lat, lng float64
+		err := rows.Scan(&mfJSON, &isPublished, &lat, &lng)
+		if err != nil {
+			return mediaList, err
+		}
+		if keep != nil && !keep(lat, lng) {
+			continue
+		}
+		media := mf2.MediaMetadata{}
+		err = json.NewDecoder(strings.NewReader(mfJSON)).Decode(&media)
+		if err != nil {
+			return mediaList, err
+		}
+		media.IsPublished = isPublished != "0"
+		mediaList.Add(media)
+	}
+	return mediaList, rows.Err()
+}
+
+// boxCondition returns the condition selecting the rows of table located
+// inside box, which rows without a location never meet
+func boxCondition(box geo.Box, table string) (string, []interface{}) {
+	args := []interface{}{box.South, box.North, box.West, box.East}
+	lng := `%[1]s.lng >= $3 AND %[1]s.lng <= $4`
+	if box.CrossesAntimeridian() {
+		lng = `(%[1]s.lng >= $3 OR %[1]s.lng <= $4)`
+	}
+	return fmt.Sprintf(`%[1]s.lat >= $1 AND %[1]s.lat <= $2 AND `+lng, table), args
+}
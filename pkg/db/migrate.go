@@ -0,0 +1,156 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/dialect"
+)
+
+// migrationLock is the Postgres advisory lock held while a migration is
+// applied, so instances booting together take turns. SQLite has no
+// advisory locks, dialect.Parse opens it with _txlock=immediate so each
+// migration's transaction takes the write lock as it begins instead
+const migrationLock = 4657327
+
+// MigrationStatus is a migration and when it was applied, AppliedAt is
+// nil while it is pending
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// execer is what *sql.DB and *sql.Tx share for running statements
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func createMigrationsTable(sqlClient execer) error {
+	_, err := sqlClient.Exec(`
+CREATE TABLE IF NOT EXISTS "schema_migrations" (
+	"version" INTEGER PRIMARY KEY,
+	"name" TEXT NOT NULL,
+	"applied_at" TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+// Status lists every migration known to this binary, oldest first
+func Status(sqlDB *sql.DB) ([]MigrationStatus, error) {
+	list := []MigrationStatus{}
+
+	err := createMigrationsTable(sqlDB)
+	if err != nil {
+		return list, err
+	}
+
+	applied := map[int]time.Time{}
+	rows, err := sqlDB.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return list, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return list, err
+	}
+
+	for _, m := range sortedMigrations(migrations) {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		list = append(list, status)
+	}
+	return list, nil
+}
+
+// Migrate applies every pending migration in order, each in its own
+// transaction, and returns the ones it applied. A migration another
+// instance applied while this one waited for the lock is skipped
+func Migrate(sqlDB *sql.DB) ([]Migration, error) {
+	d := dialect.Of(sqlDB)
+	applied := []Migration{}
+	for _, m := range sortedMigrations(migrations) {
+		ran, err := migrate(sqlDB, d, m)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+		if ran {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+func migrate(sqlDB *sql.DB, d dialect.Dialect, m Migration) (bool, error) {
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if d == dialect.Postgres {
+		_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLock)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = createMigrationsTable(tx)
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	err = tx.QueryRow(
+		`SELECT count(*) FROM schema_migrations WHERE version = $1`,
+		m.Version,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(m.Up)
+	if err != nil {
+		return false, err
+	}
+	if d == dialect.Postgres && m.Postgres != "" {
+		_, err = tx.Exec(m.Postgres)
+		if err != nil {
+			return false, err
+		}
+	}
+	_, err = tx.Exec(
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+		m.Version,
+		m.Name,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func sortedMigrations(list []Migration) []Migration {
+	sorted := make([]Migration, len(list))
+	copy(sorted, list)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
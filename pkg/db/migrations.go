@@ -0,0 +1,141 @@
+package db
+
+// Migration is one numbered change to the schema. Migrations are applied
+// in Version order and never edited once released, a change to the schema
+// is always a new migration at the end of the list
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	// Postgres runs after Up on Postgres only, for what SQLite lacks
+	Postgres string
+}
+
+// migrations are compiled into every binary so a deploy always carries
+// the schema it expects.
+// The projection tables must end up matching eventlog.ProjectionSchema,
+// which rebuilds them from scratch
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// written with IF NOT EXISTS so databases provisioned before
+		// migrations existed are adopted as they are
+		Up: `
+CREATE TABLE IF NOT EXISTS "posts" (
+	"id" TEXT PRIMARY KEY,
+	"year" INTEGER NOT NULL,
+	"sort_key" TEXT NOT NULL,
+	"month" INTEGER NOT NULL,
+	"data" TEXT NOT NULL,
+	"version" INTEGER NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS "idx_posts_year" ON "posts"("year");
+CREATE INDEX IF NOT EXISTS "idx_posts_month" ON "posts"("month");
+ALTER TABLE "posts" ADD COLUMN IF NOT EXISTS "version" INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS "media" (
+	"id" TEXT PRIMARY KEY,
+	"year" INTEGER NOT NULL,
+	"month" INTEGER NOT NULL,
+	"day" INTEGER NOT NULL,
+	"sort_key" TEXT NOT NULL,
+	"data" TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS "idx_media_year" ON "media"("year");
+CREATE INDEX IF NOT EXISTS "idx_media_month" ON "media"("month");
+
+CREATE TABLE IF NOT EXISTS "media_published" (
+	"id" TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS "sessions" (
+	"id" TEXT PRIMARY KEY,
+	"data" TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS "events" (
+	"id" TEXT PRIMARY KEY,
+	"sequence" BIGINT UNIQUE,
+	"event_type" TEXT NOT NULL,
+	"event_version" TEXT NOT NULL,
+	"data" TEXT NOT NULL,
+	"shipped" BOOLEAN NOT NULL DEFAULT true,
+	"hash" TEXT
+);
+ALTER TABLE "events" ADD COLUMN IF NOT EXISTS "shipped" BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE "events" ADD COLUMN IF NOT EXISTS "hash" TEXT;
+CREATE INDEX IF NOT EXISTS "idx_events_unshipped" ON "events"("sequence") WHERE NOT "shipped";
+
+CREATE TABLE IF NOT EXISTS "event_sequence" (
+	"id" INTEGER PRIMARY KEY,
+	"last" BIGINT NOT NULL
+);
+INSERT INTO "event_sequence" ("id", "last") VALUES (1, 0) ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS "outbox" (
+	"position" BIGINT PRIMARY KEY,
+	"event_id" TEXT NOT NULL,
+	"event_type" TEXT NOT NULL,
+	"data" TEXT NOT NULL,
+	"created_at" TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS "subscriber_cursors" (
+	"subscriber" TEXT PRIMARY KEY,
+	"position" BIGINT NOT NULL DEFAULT 0,
+	"attempts" INTEGER NOT NULL DEFAULT 0,
+	"retry_at" TIMESTAMPTZ,
+	"last_error" TEXT
+);
+`,
+	}, {
+		Version: 2,
+		Name:    "post search",
+		// existing posts are indexed by inari-replay -rebuild
+		Up: `
+ALTER TABLE "posts" ADD COLUMN "search_text" TEXT NOT NULL DEFAULT '';
+`,
+		Postgres: `
+CREATE INDEX IF NOT EXISTS "idx_posts_search" ON "posts" USING gin (to_tsvector('english', "search_text"));
+`,
+	}, {
+		Version: 3,
+		Name:    "post types",
+		// only photo posts were projected until now. inari-replay -rebuild
+		// is required after it: posts of every other type appear, and the
+		// sort_key, year and month of existing posts are recomputed from
+		// their published time in UTC
+		Up: `
+ALTER TABLE "posts" ADD COLUMN "post_type" TEXT NOT NULL DEFAULT '';
+UPDATE "posts" SET "post_type" = 'photo';
+CREATE INDEX IF NOT EXISTS "idx_posts_post_type" ON "posts"("post_type", "sort_key");
+`,
+	}, {
+		Version: 4,
+		Name:    "geo",
+		// plain columns so both dialects can answer bounding box queries,
+		// existing rows are located by inari-replay -rebuild
+		Up: `
+ALTER TABLE "posts" ADD COLUMN "lat" DOUBLE PRECISION;
+ALTER TABLE "posts" ADD COLUMN "lng" DOUBLE PRECISION;
+CREATE INDEX IF NOT EXISTS "idx_posts_lat_lng" ON "posts"("lat", "lng");
+ALTER TABLE "media" ADD COLUMN "lat" DOUBLE PRECISION;
+ALTER TABLE "media" ADD COLUMN "lng" DOUBLE PRECISION;
+CREATE INDEX IF NOT EXISTS "idx_media_lat_lng" ON "media"("lat", "lng");
+`,
+	}, {
+		Version: 5,
+		Name:    "event post urls",
+		// SQLite cannot read json, events recorded there before this
+		// migration are matched by eventlog.PostRevisions itself
+		Up: `
+ALTER TABLE "events" ADD COLUMN "post_url" TEXT;
+CREATE INDEX IF NOT EXISTS "idx_events_post_url" ON "events"("post_url");
+`,
+		Postgres: `
+UPDATE "events" SET "post_url" = "data"::json #>> '{eventData,properties,url,0}'
+	WHERE "event_type" IN ('PostCreated', 'PostUpdated');
+`,
+	},
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/cursor"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+// page is the slice of a list, newest first by sort_key, that a cursor
+// selects. One row more than the limit is fetched to tell whether the
+// list goes on past the page
+type page struct {
+	cursor cursor.Cursor
+	limit  int
+}
+
+func newPage(c string, limit int) (page, error) {
+	cur, err := cursor.Parse(c)
+	return page{cursor: cur, limit: limit}, err
+}
+
+// query completes selectFrom, which selects from a table, into the query
+// for the rows of the page that also meet conditions. args are the
+// arguments of conditions, which are numbered from $1
+func (p page) query(selectFrom, column string, conditions []string, args []interface{}) (string, []interface{}) {
+	if !p.cursor.IsZero() {
+		op := "<"
+		if p.cursor.Before {
+			op = ">"
+		}
+		args = append(args, p.cursor.Key)
+		conditions = append(conditions, fmt.Sprintf(`%s %s $%d`, column, op, len(args)))
+	}
+
+	query := selectFrom
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// pages before a cursor are read oldest first so the rows nearest
+	// the cursor are the ones kept
+	dir := "DESC"
+	if p.cursor.Before {
+		dir = "ASC"
+	}
+	args = append(args, p.limit+1)
+	query += fmt.Sprintf(` ORDER BY %s %s LIMIT $%d`, column, dir, len(args))
+	return query, args
+}
+
+// paging returns how many of the fetched rows are on the page and the
+// cursors either side of it, keys are the sort keys of the rows as read
+func (p page) paging(keys []string) (int, mf2.ListPaging) {
+	n := len(keys)
+	more := n > p.limit
+	if more {
+		n = p.limit
+	}
+	paging := mf2.ListPaging{}
+	if n == 0 {
+		return n, paging
+	}
+
+	// a page reached through a cursor has the cursor's item on its
+	// other side
+	first, last := keys[0], keys[n-1]
+	older, newer := more, !p.cursor.IsZero()
+	if p.cursor.Before {
+		first, last = last, first
+		older, newer = newer, more
+	}
+	if older {
+		paging.After = cursor.After(last).String()
+	}
+	if newer {
+		paging.Before = cursor.Before(first).String()
+	}
+	return n, paging
+}
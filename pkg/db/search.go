@@ -0,0 +1,59 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/dialect"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchPosts returns the posts matching every word of query, newest
+// first and paged like SelectPostList. Postgres matches stemmed words
+// through the full text index, SQLite matches substrings
+func (s Selecta) SearchPosts(query string, limit int, cursor string) (mf2.PostList, error) {
+	postList := mf2.PostList{
+		Paging: &mf2.ListPaging{},
+	}
+
+	p, err := newPage(cursor, limit)
+	if err != nil {
+		return postList, err
+	}
+
+	where, args := searchCondition(dialect.Of(s.db), query)
+	if where == "" {
+		return postList, nil
+	}
+
+	q, args := p.query(`SELECT data, sort_key FROM posts`, "sort_key", []string{where}, args)
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return postList, err
+	}
+	return rowsToPostList(rows, p)
+}
+
+// searchCondition returns the WHERE clause matching query and its
+// arguments, or an empty clause when there is nothing to search for
+func searchCondition(d dialect.Dialect, query string) (string, []interface{}) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	if d == dialect.Postgres {
+		return `to_tsvector('english', search_text) @@ plainto_tsquery('english', $1)`,
+			[]interface{}{query}
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	for _, word := range words {
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(word))+"%")
+		conditions = append(conditions, fmt.Sprintf(`lower(search_text) LIKE $%d ESCAPE '\'`, len(args)))
+	}
+	return strings.Join(conditions, " AND "), args
+}
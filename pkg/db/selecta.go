@@ -4,10 +4,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/j4y_funabashi/inari-micropub/pkg/app"
 	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+	"github.com/lib/pq"
 )
 
 func NewSelecta(db *sql.DB) Selecta {
@@ -16,18 +18,36 @@ func NewSelecta(db *sql.DB) Selecta {
 	}
 }
 
-type ArchiveLinkYear struct {
-	Year  string `json:"year"`
-	Count int    `json:"count"`
-}
-
-type ArchiveLinkMonth struct {
-	Month string `json:"month"`
-	Count int    `json:"count"`
+// NewPreviewSelecta reads the preview projection, which only exists once
+// it has been built, so a missing table or schema is reported as
+// app.ErrPreviewUnavailable
+func NewPreviewSelecta(db *sql.DB) Selecta {
+	return Selecta{
+		db:      db,
+		missing: app.ErrPreviewUnavailable,
+	}
 }
 
 type Selecta struct {
 	db *sql.DB
+	// missing replaces errors from queries against tables that do not
+	// exist, when it is set
+	missing error
+}
+
+// queryError is err, or s.missing when err is from a query against a
+// table or schema that does not exist
+func (s Selecta) queryError(err error) error {
+	if s.missing == nil || err == nil {
+		return err
+	}
+	if pqErr, ok := err.(*pq.Error); ok && (pqErr.Code == "42P01" || pqErr.Code == "3F000") {
+		return s.missing
+	}
+	if strings.Contains(err.Error(), "no such table") {
+		return s.missing
+	}
+	return err
 }
 
 func (s Selecta) SelectMediaYearList() []app.Year {
@@ -41,7 +61,7 @@ FROM
 LEFT JOIN
 (SELECT year,count(*) as published_count FROM media INNER JOIN media_published ON media.id = media_published.id GROUP BY year) published
 ON med1.year = published.year
- ORDER BY year DESC;`,
+ ORDER BY med1.year DESC;`,
 	)
 	if err != nil {
 		return list
@@ -60,16 +80,16 @@ ON med1.year = published.year
 	return list
 }
 
-func (s Selecta) SelectMonthList(year string) ([]ArchiveLinkMonth, error) {
+func (s Selecta) SelectMonthList(year string) ([]app.ArchiveLinkMonth, error) {
 
-	list := []ArchiveLinkMonth{}
+	list := []app.ArchiveLinkMonth{}
 
 	if year == "" {
 		return list, nil
 	}
 
 	rows, err := s.db.Query(
-		`SELECT month,count(*) as count FROM posts WHERE year = $1 GROUP BY month ORDER BY sort_key DESC `,
+		`SELECT month,count(*) as count FROM posts WHERE year = $1 GROUP BY month ORDER BY month DESC`,
 		year,
 	)
 	if err != nil {
@@ -79,11 +99,14 @@ func (s Selecta) SelectMonthList(year string) ([]ArchiveLinkMonth, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		item := ArchiveLinkMonth{}
-		err := rows.Scan(&item.Month, &item.Count)
+		item := app.ArchiveLinkMonth{}
+		var month int
+		err := rows.Scan(&month, &item.Count)
 		if err != nil {
 			return list, err
 		}
+		// months are zero padded as they are in archive urls
+		item.Month = fmt.Sprintf("%02d", month)
 		list = append(list, item)
 	}
 	return list, nil
@@ -105,7 +128,7 @@ FROM
 LEFT JOIN
 (SELECT month,count(*) as published_count FROM media INNER JOIN media_published ON media.id = media_published.id WHERE year = $2 GROUP BY month) published
 ON med1.month = published.month
- ORDER BY month DESC;`,
+ ORDER BY med1.month DESC;`,
 		year,
 		year,
 	)
@@ -159,7 +182,7 @@ GROUP BY year,month,day
 ) published
 
 ON med1.day = published.day
-ORDER BY day DESC;`,
+ORDER BY med1.day DESC;`,
 		year,
 		month,
 	)
@@ -180,13 +203,13 @@ ORDER BY day DESC;`,
 	return list, nil
 }
 
-func (s Selecta) SelectYearList() ([]ArchiveLinkYear, error) {
+func (s Selecta) SelectYearList() ([]app.ArchiveLinkYear, error) {
 
-	list := []ArchiveLinkYear{}
+	list := []app.ArchiveLinkYear{}
 
 	rows, err := s.db.Query(
 		`SELECT
-year,count(*) as count FROM posts GROUP BY year ORDER BY sort_key DESC `,
+year,count(*) as count FROM posts GROUP BY year ORDER BY year DESC`,
 	)
 	if err != nil {
 		return list, err
@@ -195,7 +218,7 @@ year,count(*) as count FROM posts GROUP BY year ORDER BY sort_key DESC `,
 	defer rows.Close()
 
 	for rows.Next() {
-		item := ArchiveLinkYear{}
+		item := app.ArchiveLinkYear{}
 		err := rows.Scan(&item.Year, &item.Count)
 		if err != nil {
 			return list, err
@@ -205,19 +228,39 @@ year,count(*) as count FROM posts GROUP BY year ORDER BY sort_key DESC `,
 	return list, nil
 }
 
-func (s Selecta) SelectPostList(limit int, afterKey string) mf2.PostList {
+func (s Selecta) SelectPostList(limit int, cursor string) (mf2.PostList, error) {
+	return s.selectPostList(postFilter{}, limit, cursor)
+}
+
+// SelectPostListByType is SelectPostList for the posts of postType only,
+// every post when postType is empty
+func (s Selecta) SelectPostListByType(postType string, limit int, cursor string) (mf2.PostList, error) {
+	return s.selectPostList(postFilter{postType: postType}, limit, cursor)
+}
 
+// SelectArchivePostList is SelectPostList for the posts published in year,
+// and in month of that year when month is not empty
+func (s Selecta) SelectArchivePostList(year, month string, limit int, cursor string) (mf2.PostList, error) {
+	return s.selectPostList(postFilter{year: year, month: month}, limit, cursor)
+}
+
+func (s Selecta) selectPostList(filter postFilter, limit int, cursor string) (mf2.PostList, error) {
 	postList := mf2.PostList{
 		Paging: &mf2.ListPaging{},
 	}
 
-	count, err := s.fetchPostCount(afterKey, limit)
+	p, err := newPage(cursor, limit)
 	if err != nil {
-		return postList
+		return postList, err
 	}
 
-	postList, err = s.fetchPostList(afterKey, count, limit)
-	return postList
+	conditions, args := filter.conditions()
+	query, args := p.query(`SELECT data, sort_key FROM posts`, "sort_key", conditions, args)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return postList, s.queryError(err)
+	}
+	return rowsToPostList(rows, p)
 }
 
 func (s Selecta) SelectPostByURL(uid string) (mf2.MicroFormat, error) {
@@ -248,6 +291,20 @@ func (s Selecta) SelectPostByURL(uid string) (mf2.MicroFormat, error) {
 	return mf, nil
 }
 
+// SelectPostVersion returns the current version of a post, 0 if the post
+// does not exist
+func (s Selecta) SelectPostVersion(url string) (int, error) {
+	var version int
+	err := s.db.QueryRow(
+		`SELECT version FROM posts WHERE id = $1`,
+		url,
+	).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
 func (s Selecta) SelectMediaByURL(uid string) (app.Media, error) {
 
 	media := app.Media{}
@@ -320,26 +377,55 @@ ORDER BY media.sort_key DESC`,
 	return mediaList, nil
 }
 
-func (s Selecta) SelectMediaList(limit int, afterKey string) (mf2.MediaList, error) {
-
-	postList := mf2.MediaList{
+func (s Selecta) SelectMediaList(limit int, cursor string) (mf2.MediaList, error) {
+	mediaList := mf2.MediaList{
 		Paging: &mf2.ListPaging{},
 	}
 
-	count, err := s.fetchMediaCount(afterKey, limit)
+	p, err := newPage(cursor, limit)
 	if err != nil {
-		return postList, err
+		return mediaList, err
 	}
-	postList, err = s.fetchMediaList(afterKey, count, limit)
-	return postList, err
+
+	query, args := p.query(
+		`SELECT data, sort_key, COALESCE(media_published.id, '0')
+FROM media
+LEFT JOIN media_published ON media.id = media_published.id`,
+		"sort_key",
+		nil,
+		nil,
+	)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return mediaList, err
+	}
+	items, keys, err := scanMediaList(rows)
+	if err != nil {
+		return mediaList, err
+	}
+
+	n, paging := p.paging(keys)
+	for i := 0; i < n; i++ {
+		if p.cursor.Before {
+			mediaList.Add(items[n-1-i])
+		} else {
+			mediaList.Add(items[i])
+		}
+	}
+	mediaList.Paging = &paging
+	return mediaList, nil
 }
 
-func rowsToPostList(rows *sql.Rows, count, limit int) (mf2.PostList, error) {
+// rowsToPostList reads the rows of p, which were selected along with
+// their sort keys, into a page of posts
+func rowsToPostList(rows *sql.Rows, p page) (mf2.PostList, error) {
 	postList := mf2.PostList{
 		Paging: &mf2.ListPaging{},
 	}
 
 	defer rows.Close()
+	items := []mf2.MicroFormat{}
+	keys := []string{}
 	for rows.Next() {
 		var mfJSON string
 		var sortKey string
@@ -352,23 +438,30 @@ func rowsToPostList(rows *sql.Rows, count, limit int) (mf2.PostList, error) {
 		if err != nil {
 			return postList, err
 		}
-		postList.Add(mf)
-		if count > limit {
-			paging := mf2.ListPaging{
-				After: sortKey,
-			}
-			postList.Paging = &paging
-		}
+		items = append(items, mf)
+		keys = append(keys, sortKey)
+	}
+	err := rows.Err()
+	if err != nil {
+		return postList, err
 	}
 
+	n, paging := p.paging(keys)
+	for i := 0; i < n; i++ {
+		if p.cursor.Before {
+			postList.Add(items[n-1-i])
+		} else {
+			postList.Add(items[i])
+		}
+	}
+	postList.Paging = &paging
 	return postList, nil
 }
 
-func rowsToMediaList(rows *sql.Rows, count, limit int) (mf2.MediaList, error) {
-
-	postList := mf2.MediaList{
-		Paging: &mf2.ListPaging{},
-	}
+// scanMediaList reads rows of media data, sort key and published id
+func scanMediaList(rows *sql.Rows) ([]mf2.MediaMetadata, []string, error) {
+	items := []mf2.MediaMetadata{}
+	keys := []string{}
 
 	defer rows.Close()
 	for rows.Next() {
@@ -377,66 +470,58 @@ func rowsToMediaList(rows *sql.Rows, count, limit int) (mf2.MediaList, error) {
 		var isPublished string
 		err := rows.Scan(&mfJSON, &sortKey, &isPublished)
 		if err != nil {
-			return postList, err
+			return items, keys, err
 		}
 		mf := mf2.MediaMetadata{}
 		err = json.NewDecoder(strings.NewReader(mfJSON)).Decode(&mf)
 		if err != nil {
-			return postList, err
+			return items, keys, err
 		}
 
 		if len(isPublished) > 0 && isPublished != "0" {
 			mf.IsPublished = true
 		}
 
-		postList.Add(mf)
-		if count > limit {
-			paging := mf2.ListPaging{
-				After: sortKey,
-			}
-			postList.Paging = &paging
-		}
+		items = append(items, mf)
+		keys = append(keys, sortKey)
 	}
 
-	return postList, nil
+	return items, keys, rows.Err()
 }
 
-func (s Selecta) fetchPostList(afterKey string, count, limit int) (mf2.PostList, error) {
-	postList := mf2.PostList{
-		Paging: &mf2.ListPaging{},
-	}
+// postFilter narrows a post list, each of its fields is ignored when
+// empty
+type postFilter struct {
+	postType string
+	year     string
+	month    string
+}
 
-	if len(afterKey) > 0 {
-		rows, err := s.db.Query(
-			`SELECT data, sort_key FROM posts WHERE sort_key < $1 ORDER BY sort_key DESC LIMIT $2`,
-			afterKey,
-			limit,
-		)
-		if err != nil {
-			return postList, err
+// conditions returns the conditions selecting the posts of the filter and
+// their arguments
+func (f postFilter) conditions() ([]string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+	for _, column := range []struct{ name, value string }{
+		{"post_type", f.postType},
+		{"year", f.year},
+		{"month", f.month},
+	} {
+		if len(column.value) > 0 {
+			args = append(args, column.value)
+			conditions = append(conditions, fmt.Sprintf(`%s = $%d`, column.name, len(args)))
 		}
-		postList, err = rowsToPostList(rows, count, limit)
-		return postList, err
-	}
-
-	rows, err := s.db.Query(
-		`SELECT data, sort_key FROM posts ORDER BY sort_key DESC LIMIT $1`,
-		limit,
-	)
-	if err != nil {
-		return postList, err
 	}
-	postList, err = rowsToPostList(rows, count, limit)
-	return postList, err
+	return conditions, args
 }
 
 func (s Selecta) fetchMediaMonth(year, month string) (mf2.MediaList, error) {
-	postList := mf2.MediaList{
+	mediaList := mf2.MediaList{
 		Paging: &mf2.ListPaging{},
 	}
 
 	rows, err := s.db.Query(
-		`SELECT data, sort_key, COALESCE(media_published.id, 0)
+		`SELECT data, sort_key, COALESCE(media_published.id, '0')
 FROM media
 LEFT JOIN media_published ON media.id = media_published.id
 WHERE media.year = $1 AND media.month = $2
@@ -445,81 +530,8 @@ ORDER BY sort_key DESC;`,
 		month,
 	)
 	if err != nil {
-		return postList, err
-	}
-	postList, err = rowsToMediaList(rows, 0, 0)
-	return postList, err
-}
-
-func (s Selecta) fetchMediaList(afterKey string, count, limit int) (mf2.MediaList, error) {
-	postList := mf2.MediaList{
-		Paging: &mf2.ListPaging{},
-	}
-
-	if len(afterKey) > 0 {
-		rows, err := s.db.Query(
-			`SELECT data, sort_key, COALESCE(media_published.id, 0)
-FROM media
-LEFT JOIN media_published ON media.id = media_published.id
-WHERE sort_key < $1 ORDER BY sort_key DESC LIMIT $2;`,
-			afterKey,
-			limit,
-		)
-		if err != nil {
-			return postList, err
-		}
-		postList, err := rowsToMediaList(rows, count, limit)
-		return postList, err
-	}
-
-	rows, err := s.db.Query(
-		`SELECT data, sort_key, COALESCE(media_published.id, 0)
-FROM media
-LEFT JOIN media_published ON media.id = media_published.id
-ORDER BY sort_key DESC LIMIT $1;`,
-		limit,
-	)
-	if err != nil {
-		return postList, err
+		return mediaList, err
 	}
-	postList, err = rowsToMediaList(rows, count, limit)
-	return postList, err
-}
-
-func (s Selecta) fetchPostCount(afterKey string, limit int) (int, error) {
-	var count int
-	if len(afterKey) > 0 {
-		row := s.db.QueryRow(
-			`SELECT count(sort_key) FROM posts WHERE sort_key < $1 LIMIT $2`,
-			afterKey,
-			limit+1,
-		)
-		err := row.Scan(&count)
-		return count, err
-	}
-	row := s.db.QueryRow(
-		`SELECT count(sort_key) FROM posts LIMIT $1`,
-		limit+1,
-	)
-	err := row.Scan(&count)
-	return count, err
-}
-
-func (s Selecta) fetchMediaCount(afterKey string, limit int) (int, error) {
-	var count int
-	if len(afterKey) > 0 {
-		row := s.db.QueryRow(
-			`SELECT count(*) FROM media WHERE sort_key < $1 ORDER BY sort_key DESC LIMIT $2`,
-			afterKey,
-			limit+1,
-		)
-		err := row.Scan(&count)
-		return count, err
-	}
-	row := s.db.QueryRow(
-		`SELECT count(*) FROM media ORDER BY sort_key DESC LIMIT $1`,
-		limit+1,
-	)
-	err := row.Scan(&count)
-	return count, err
+	mediaList.Items, _, err = scanMediaList(rows)
+	return mediaList, err
 }
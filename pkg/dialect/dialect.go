@@ -0,0 +1,101 @@
+// Package dialect lets the Postgres flavoured SQL used throughout inari run
+// against SQLite. Queries are written once, with $n placeholders, and
+// translated by the driver for dialects that need it
+package dialect
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Dialect is the SQL understood by a database driver
+type Dialect struct {
+	Name   string
+	Driver string
+}
+
+var (
+	// Postgres is the default, queries are run as written
+	Postgres = Dialect{Name: "postgres", Driver: "postgres"}
+	// SQLite opens a single database file through a driver that
+	// translates every query
+	SQLite = Dialect{Name: "sqlite", Driver: "inari-sqlite3"}
+)
+
+// sqliteDefaults make concurrent writers wait for each other instead of
+// failing, a url can set its own
+var sqliteDefaults = []string{
+	"_busy_timeout=5000",
+	"_journal_mode=WAL",
+}
+
+// sqliteTxLock makes every transaction take the write lock when it
+// begins, so a read followed by a write cannot deadlock and migrations
+// take turns. It replaces whatever lock a url asks for
+const sqliteTxLock = "_txlock=immediate"
+
+var txLockOption = regexp.MustCompile(`_txlock=[^&]*`)
+
+// Parse returns the dialect of a database url and the data source name
+// to open it with. sqlite:// and file: urls are SQLite files, anything
+// else is handed to lib/pq
+func Parse(databaseURL string) (Dialect, string) {
+	var dsn string
+	switch {
+	case strings.HasPrefix(databaseURL, "sqlite://"):
+		dsn = "file:" + strings.TrimPrefix(databaseURL, "sqlite://")
+	case strings.HasPrefix(databaseURL, "file:"):
+		dsn = databaseURL
+	default:
+		return Postgres, databaseURL
+	}
+
+	if txLockOption.MatchString(dsn) {
+		dsn = txLockOption.ReplaceAllString(dsn, sqliteTxLock)
+	} else if strings.Contains(dsn, "?") {
+		dsn += "&" + sqliteTxLock
+	} else {
+		dsn += "?" + sqliteTxLock
+	}
+	for _, option := range sqliteDefaults {
+		name := option[:strings.Index(option, "=")+1]
+		if strings.Contains(dsn, name) {
+			continue
+		}
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + option
+		} else {
+			dsn += "?" + option
+		}
+	}
+	return SQLite, dsn
+}
+
+var (
+	placeholder = regexp.MustCompile(`\$(\d+)`)
+	lockingRead = regexp.MustCompile(`(?i)\s+FOR\s+UPDATE(\s+SKIP\s+LOCKED)?`)
+	addColumn   = regexp.MustCompile(`(?i)ALTER\s+TABLE\s+[^;]+\s+ADD\s+COLUMN\s+IF\s+NOT\s+EXISTS\s+[^;]*;`)
+)
+
+// Rebind rewrites $n placeholders as ?n, which SQLite binds to the same
+// argument however often and in whatever order they appear
+func (d Dialect) Rebind(query string) string {
+	if d != SQLite {
+		return query
+	}
+	return placeholder.ReplaceAllString(query, "?$1")
+}
+
+// Translate rewrites a Postgres query for the dialect. For SQLite that
+// means rebinding placeholders and dropping what it has no use for: row
+// locks, as SQLite locks the whole database for a writer, and ADD COLUMN
+// IF NOT EXISTS, which only upgrades Postgres databases created before a
+// column was part of its CREATE TABLE
+func (d Dialect) Translate(query string) string {
+	if d != SQLite {
+		return query
+	}
+	query = lockingRead.ReplaceAllString(query, "")
+	query = addColumn.ReplaceAllString(query, "")
+	return d.Rebind(query)
+}
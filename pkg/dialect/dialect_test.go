@@ -0,0 +1,118 @@
+package dialect_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/dialect"
+	"github.com/matryer/is"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		name        string
+		databaseURL string
+		expected    dialect.Dialect
+		expectedDSN string
+	}{
+		{
+			name:        "postgres url",
+			databaseURL: "postgresql://postgres:example@db:5432?sslmode=disable",
+			expected:    dialect.Postgres,
+			expectedDSN: "postgresql://postgres:example@db:5432?sslmode=disable",
+		},
+		{
+			name:        "postgres key value",
+			databaseURL: "host=db user=postgres",
+			expected:    dialect.Postgres,
+			expectedDSN: "host=db user=postgres",
+		},
+		{
+			name:        "sqlite url",
+			databaseURL: "sqlite:///var/lib/inari/inari.db",
+			expected:    dialect.SQLite,
+			expectedDSN: "file:/var/lib/inari/inari.db?_txlock=immediate&_busy_timeout=5000&_journal_mode=WAL",
+		},
+		{
+			name:        "sqlite file keeps its options",
+			databaseURL: "file:inari.db?_busy_timeout=100",
+			expected:    dialect.SQLite,
+			expectedDSN: "file:inari.db?_busy_timeout=100&_txlock=immediate&_journal_mode=WAL",
+		},
+		{
+			name:        "sqlite transactions always take the write lock",
+			databaseURL: "file:inari.db?_txlock=deferred&_busy_timeout=100",
+			expected:    dialect.SQLite,
+			expectedDSN: "file:inari.db?_txlock=immediate&_busy_timeout=100&_journal_mode=WAL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			d, dsn := dialect.Parse(tt.databaseURL)
+
+			is.Equal(d, tt.expected)
+			is.Equal(dsn, tt.expectedDSN)
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	var tests = []struct {
+		name     string
+		dialect  dialect.Dialect
+		query    string
+		expected string
+	}{
+		{
+			name:     "postgres is unchanged",
+			dialect:  dialect.Postgres,
+			query:    `SELECT id FROM events WHERE id = $1 FOR UPDATE SKIP LOCKED`,
+			expected: `SELECT id FROM events WHERE id = $1 FOR UPDATE SKIP LOCKED`,
+		},
+		{
+			name:     "placeholders are numbered",
+			dialect:  dialect.SQLite,
+			query:    `UPDATE posts SET data = $1 WHERE id = $2 AND ($3 = 0 OR version = $3)`,
+			expected: `UPDATE posts SET data = ?1 WHERE id = ?2 AND (?3 = 0 OR version = ?3)`,
+		},
+		{
+			name:    "row locks are dropped",
+			dialect: dialect.SQLite,
+			query: `SELECT id, data FROM events
+			WHERE NOT shipped
+			FOR UPDATE SKIP LOCKED`,
+			expected: `SELECT id, data FROM events
+			WHERE NOT shipped`,
+		},
+		{
+			name:    "column upgrades are dropped",
+			dialect: dialect.SQLite,
+			query: `CREATE TABLE IF NOT EXISTS "events" ("id" TEXT, "hash" TEXT);
+ALTER TABLE "events" ADD COLUMN IF NOT EXISTS "hash" TEXT;
+CREATE INDEX IF NOT EXISTS "idx" ON "events"("id");`,
+			expected: `CREATE TABLE IF NOT EXISTS "events" ("id" TEXT, "hash" TEXT);
+
+CREATE INDEX IF NOT EXISTS "idx" ON "events"("id");`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(tt.dialect.Translate(tt.query), tt.expected)
+		})
+	}
+}
+
+func TestOf(t *testing.T) {
+	is := is.New(t)
+
+	sqlDB, err := sql.Open(dialect.SQLite.Driver, "file::memory:")
+	is.NoErr(err)
+	defer sqlDB.Close()
+
+	is.Equal(dialect.Of(sqlDB), dialect.SQLite)
+}
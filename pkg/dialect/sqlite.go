@@ -0,0 +1,63 @@
+package dialect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	sql.Register(SQLite.Driver, translatingDriver{&sqlite3.SQLiteDriver{}})
+}
+
+// Of returns the dialect of an open database
+func Of(sqlDB *sql.DB) Dialect {
+	if _, ok := sqlDB.Driver().(translatingDriver); ok {
+		return SQLite
+	}
+	return Postgres
+}
+
+// translatingDriver opens sqlite3 connections that translate every query
+// before it is prepared
+type translatingDriver struct {
+	driver *sqlite3.SQLiteDriver
+}
+
+func (d translatingDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return translatingConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type translatingConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c translatingConn) Prepare(query string) (driver.Stmt, error) {
+	return c.SQLiteConn.Prepare(SQLite.Translate(query))
+}
+
+func (c translatingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.SQLiteConn.PrepareContext(ctx, SQLite.Translate(query))
+}
+
+func (c translatingConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.SQLiteConn.Exec(SQLite.Translate(query), args)
+}
+
+func (c translatingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.SQLiteConn.ExecContext(ctx, SQLite.Translate(query), args)
+}
+
+func (c translatingConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.SQLiteConn.Query(SQLite.Translate(query), args)
+}
+
+func (c translatingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.SQLiteConn.QueryContext(ctx, SQLite.Translate(query), args)
+}
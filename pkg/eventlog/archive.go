@@ -0,0 +1,267 @@
+package eventlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+// archiveFormatVersion is bumped whenever the layout of an export changes
+const archiveFormatVersion = 1
+
+// Manifest describes an exported archive so it can be checked before it
+// is imported. SHA256 covers the gzip file as written, ContentSHA256 the
+// uncompressed lines so an archive that has been recompressed still
+// verifies
+type Manifest struct {
+	FormatVersion int            `json:"formatVersion"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	Events        int            `json:"events"`
+	EventTypes    map[string]int `json:"eventTypes"`
+	FirstSequence int64          `json:"firstSequence"`
+	LastSequence  int64          `json:"lastSequence"`
+	SHA256        string         `json:"sha256"`
+	ContentSHA256 string         `json:"contentSHA256"`
+}
+
+// ImportResult counts what happened to each event in an archive
+type ImportResult struct {
+	Imported int
+	Skipped  int
+}
+
+func newManifest() Manifest {
+	return Manifest{
+		FormatVersion: archiveFormatVersion,
+		CreatedAt:     time.Now(),
+		EventTypes:    map[string]int{},
+	}
+}
+
+// add counts an event line in the manifest and its content checksum
+func (m *Manifest) add(h EventHeader, line string, content hash.Hash) {
+	m.Events++
+	m.EventTypes[h.EventType]++
+	if h.EventSequence > 0 && (m.FirstSequence == 0 || h.EventSequence < m.FirstSequence) {
+		m.FirstSequence = h.EventSequence
+	}
+	if h.EventSequence > m.LastSequence {
+		m.LastSequence = h.EventSequence
+	}
+	io.WriteString(content, line+"\n")
+}
+
+// Export streams every event in the log to w as gzip compressed JSON
+// lines, in replay order, and returns the manifest describing it
+func (el EventLog) Export(w io.Writer) (Manifest, error) {
+	manifest := newManifest()
+
+	keys, err := el.logKeys(0)
+	if err != nil {
+		return manifest, err
+	}
+
+	fileHash := sha256.New()
+	contentHash := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(w, fileHash))
+
+	prog := newProgress(len(keys))
+	for fetched := range fetchEvents(el.s3Client, el.s3Bucket, keys, el.fetchConcurrency) {
+		if fetched.err != nil {
+			return manifest, fmt.Errorf("reading %s: %v", fetched.key.key, fetched.err)
+		}
+		line := strings.TrimSpace(fetched.data)
+		h := EventHeader{}
+		err := json.Unmarshal([]byte(line), &h)
+		if err != nil {
+			return manifest, fmt.Errorf("decoding %s: %v", fetched.key.key, err)
+		}
+
+		_, err = io.WriteString(zw, line+"\n")
+		if err != nil {
+			return manifest, err
+		}
+		manifest.add(h, line, contentHash)
+
+		if prog.tick(time.Now()) {
+			el.logger.Infof("exported %d/%d events", prog.done, prog.total)
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return manifest, err
+	}
+	manifest.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+	manifest.ContentSHA256 = hex.EncodeToString(contentHash.Sum(nil))
+
+	return manifest, nil
+}
+
+// readArchive calls fn with the header and json of every event in an
+// archive, and returns the manifest describing what was read
+func readArchive(r io.Reader, fn func(h EventHeader, line string) error) (Manifest, error) {
+	manifest := newManifest()
+
+	fileHash := sha256.New()
+	contentHash := sha256.New()
+	zr, err := gzip.NewReader(io.TeeReader(r, fileHash))
+	if err != nil {
+		return manifest, err
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		h := EventHeader{}
+		err := json.Unmarshal([]byte(line), &h)
+		if err != nil {
+			return manifest, fmt.Errorf("decoding event %d: %v", manifest.Events+1, err)
+		}
+		if h.EventID == "" {
+			return manifest, fmt.Errorf("event %d has no eventID", manifest.Events+1)
+		}
+		manifest.add(h, line, contentHash)
+
+		err = fn(h, line)
+		if err != nil {
+			return manifest, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return manifest, err
+	}
+
+	// drain anything after the gzip stream so the file hash is complete
+	_, err = io.Copy(fileHash, r)
+	if err != nil {
+		return manifest, err
+	}
+	manifest.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+	manifest.ContentSHA256 = hex.EncodeToString(contentHash.Sum(nil))
+
+	return manifest, nil
+}
+
+// VerifyArchive reads a whole archive and checks it against its manifest
+func VerifyArchive(r io.Reader, expected Manifest) error {
+	actual, err := readArchive(r, func(h EventHeader, line string) error {
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if actual.Events != expected.Events {
+		return fmt.Errorf("archive has %d events, manifest lists %d", actual.Events, expected.Events)
+	}
+	for eventType, count := range expected.EventTypes {
+		if actual.EventTypes[eventType] != count {
+			return fmt.Errorf(
+				"archive has %d %s events, manifest lists %d",
+				actual.EventTypes[eventType],
+				eventType,
+				count,
+			)
+		}
+	}
+	if actual.ContentSHA256 != expected.ContentSHA256 {
+		return fmt.Errorf("archive content checksum does not match manifest")
+	}
+	if expected.SHA256 != "" && actual.SHA256 != expected.SHA256 {
+		return fmt.Errorf("archive file checksum does not match manifest")
+	}
+	return nil
+}
+
+// Import writes every event in an archive to the log that is not in it
+// already. Events are matched on EventID so an archive can be imported
+// any number of times. Nothing is written when an event in the archive
+// would take a sequence the log already uses for a different event, as
+// sequences cannot be renumbered without breaking the hash chain. Events
+// journaled locally but not yet shipped count as part of the log.
+// Imported events reach the database on the next Replay
+func (el EventLog) Import(r io.Reader) (ImportResult, error) {
+	result := ImportResult{}
+
+	keys, err := el.logKeys(0)
+	if err != nil {
+		return result, err
+	}
+	existing := map[string]bool{}
+	sequences := map[int64]string{}
+	for _, key := range keys {
+		existing[key.eventID] = true
+		if key.sequence != 0 {
+			sequences[key.sequence] = key.eventID
+		}
+	}
+	if el.db != nil {
+		unshipped, err := el.unshippedEvents()
+		if err != nil {
+			return result, err
+		}
+		for id, seq := range unshipped {
+			existing[id] = true
+			if seq != 0 {
+				sequences[seq] = id
+			}
+		}
+	}
+
+	type pendingEvent struct {
+		h    EventHeader
+		line string
+	}
+	pending := []pendingEvent{}
+	_, err = readArchive(r, func(h EventHeader, line string) error {
+		if existing[h.EventID] {
+			result.Skipped++
+			return nil
+		}
+		if id, ok := sequences[h.EventSequence]; ok && h.EventSequence != 0 {
+			return fmt.Errorf(
+				"event %s has sequence %d, which the log already uses for event %s",
+				h.EventID,
+				h.EventSequence,
+				id,
+			)
+		}
+		existing[h.EventID] = true
+		if h.EventSequence != 0 {
+			sequences[h.EventSequence] = h.EventID
+		}
+		pending = append(pending, pendingEvent{h: h, line: line})
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	for _, event := range pending {
+		err := el.s3Client.WriteObject(
+			fileKey(el.s3KeyPrefix, event.h),
+			el.s3Bucket,
+			strings.NewReader(event.line+"\n"),
+			true,
+		)
+		if err != nil {
+			return result, fmt.Errorf("writing event %s: %v", event.h.EventID, err)
+		}
+		result.Imported++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,128 @@
+package eventlog
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+// memStore is an in memory ObjectStore. When latency is set every read
+// takes that long, and when finished is set keys are sent to it as their
+// reads complete
+type memStore struct {
+	objects  map[string]string
+	latency  func(key string) time.Duration
+	finished chan string
+}
+
+func (m memStore) ListKeys(bucket, prefix string) ([]*string, error) {
+	keys := []string{}
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	out := []*string{}
+	for i := range keys {
+		out = append(out, &keys[i])
+	}
+	return out, nil
+}
+
+func (m memStore) ReadObject(key, bucket string) (*bytes.Buffer, error) {
+	if m.latency != nil {
+		time.Sleep(m.latency(key))
+	}
+	if m.finished != nil {
+		m.finished <- key
+	}
+	return bytes.NewBufferString(m.objects[key]), nil
+}
+
+func (m memStore) WriteObject(key, bucket string, body io.Reader, isPrivate bool) error {
+	b, err := ioutil.ReadAll(body)
+	m.objects[key] = string(b)
+	return err
+}
+
+func (m memStore) DeleteObject(key, bucket string) error {
+	delete(m.objects, key)
+	return nil
+}
+
+func testEventLog(store ObjectStore) EventLog {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	return NewEventLog("jay", "events", store, nil, logger)
+}
+
+func TestExportImport(t *testing.T) {
+	is := is.New(t)
+
+	source := memStore{objects: map[string]string{
+		"jay/2018/20181128101010.0001_l.json":        `{"eventID": "l", "eventType": "PostCreated", "eventVersion": "20181128101010.0001"}`,
+		"jay/2019/00000000000000000001_a.json":       `{"eventID": "a", "eventType": "MediaUploaded", "eventVersion": "20191128101010.0001", "eventSequence": 1}`,
+		"jay/2019/00000000000000000002_b.json":       `{"eventID": "b", "eventType": "MediaDeleted", "eventVersion": "20191128101010.0002", "eventSequence": 2}`,
+		"snapshots/jay/00000000000000000002.json.gz": "not an event",
+	}}
+
+	archive := new(bytes.Buffer)
+	manifest, err := testEventLog(source).Export(archive)
+	is.NoErr(err)
+	is.Equal(manifest.Events, 3)
+	is.Equal(manifest.EventTypes["MediaDeleted"], 1)
+	is.Equal(manifest.FirstSequence, int64(1))
+	is.Equal(manifest.LastSequence, int64(2))
+
+	is.NoErr(VerifyArchive(bytes.NewReader(archive.Bytes()), manifest))
+
+	tampered := manifest
+	tampered.ContentSHA256 = "abc"
+	is.True(VerifyArchive(bytes.NewReader(archive.Bytes()), tampered) != nil)
+
+	target := memStore{objects: map[string]string{
+		"jay/2019/00000000000000000001_a.json": source.objects["jay/2019/00000000000000000001_a.json"],
+	}}
+	result, err := testEventLog(target).Import(bytes.NewReader(archive.Bytes()))
+	is.NoErr(err)
+	is.Equal(result, ImportResult{Imported: 2, Skipped: 1})
+	is.Equal(
+		strings.TrimSpace(target.objects["jay/2018/20181128101010.0001_l.json"]),
+		source.objects["jay/2018/20181128101010.0001_l.json"],
+	)
+
+	// importing again changes nothing
+	result, err = testEventLog(target).Import(bytes.NewReader(archive.Bytes()))
+	is.NoErr(err)
+	is.Equal(result, ImportResult{Imported: 0, Skipped: 3})
+	is.Equal(len(target.objects), 3)
+}
+
+func TestImportRefusesTakenSequences(t *testing.T) {
+	is := is.New(t)
+
+	source := memStore{objects: map[string]string{
+		"jay/2019/00000000000000000001_a.json": `{"eventID": "a", "eventType": "MediaUploaded", "eventVersion": "20191128101010.0001", "eventSequence": 1}`,
+		"jay/2019/00000000000000000002_b.json": `{"eventID": "b", "eventType": "MediaDeleted", "eventVersion": "20191128101010.0002", "eventSequence": 2}`,
+	}}
+	archive := new(bytes.Buffer)
+	_, err := testEventLog(source).Export(archive)
+	is.NoErr(err)
+
+	// the target has appended its own event at sequence 2
+	target := memStore{objects: map[string]string{
+		"jay/2019/00000000000000000001_a.json": source.objects["jay/2019/00000000000000000001_a.json"],
+		"jay/2019/00000000000000000002_x.json": `{"eventID": "x", "eventType": "MediaDeleted", "eventVersion": "20191128101010.0003", "eventSequence": 2}`,
+	}}
+	_, err = testEventLog(target).Import(bytes.NewReader(archive.Bytes()))
+	is.True(err != nil)
+	is.Equal(len(target.objects), 2)
+}
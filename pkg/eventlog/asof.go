@@ -0,0 +1,119 @@
+package eventlog
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/dialect"
+)
+
+// PreviewSchema is the isolated schema BuildAsOf projects into by default
+const PreviewSchema = "preview"
+
+// ParseUntil reads a replay cut off, either a sequence number, an RFC3339
+// timestamp or a date, which means the start of that day in local time
+func ParseUntil(until string) (int64, time.Time, error) {
+	seq, err := strconv.ParseInt(until, 10, 64)
+	if err == nil {
+		return seq, time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, until)
+	if err == nil {
+		return 0, t, nil
+	}
+	t, err = time.ParseInLocation("2006-01-02", until, time.Local)
+	if err == nil {
+		return 0, t, nil
+	}
+	return 0, time.Time{}, fmt.Errorf("%q is not a sequence, RFC3339 time or date", until)
+}
+
+// readJournalUntil returns recorded events in replay order up to and
+// including untilSeq, and appended before until. A zero value for either
+// leaves it unbounded
+func readJournalUntil(sqlClient *sql.Tx, untilSeq int64, until time.Time) ([]journalEvent, error) {
+	version := ""
+	if !until.IsZero() {
+		version = until.In(time.Local).Format("20060102150405.0000")
+	}
+
+	events := []journalEvent{}
+	rows, err := sqlClient.Query(
+		`SELECT COALESCE(sequence, 0), data FROM events
+			WHERE ($1 = 0 OR COALESCE(sequence, 0) <= $1)
+			AND ($2 = '' OR event_version < $2)
+			ORDER BY COALESCE(sequence, 0), event_version, id`,
+		untilSeq,
+		version,
+	)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := journalEvent{}
+		err := rows.Scan(&e.sequence, &e.data)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// BuildAsOf projects the log as it was at a sequence number or time into
+// its own schema, leaving the live tables alone. The schema is replaced
+// each time it is built and can be read by opening the database with the
+// schema as its search_path. Only Postgres has schemas
+func (el EventLog) BuildAsOf(schema string, untilSeq int64, until time.Time) (int, error) {
+	if d := dialect.Of(el.db); d != dialect.Postgres {
+		return 0, fmt.Errorf("projecting the log as of a point needs Postgres schemas, %s has none", d.Name)
+	}
+
+	tx, err := el.db.Begin()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to start transaction")
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		fmt.Sprintf(`DROP SCHEMA IF EXISTS %q CASCADE`, schema),
+		fmt.Sprintf(`CREATE SCHEMA %q`, schema),
+		fmt.Sprintf(`SET LOCAL search_path TO %q`, schema),
+		ProjectionSchema(),
+		ProjectionIndexes(),
+		fmt.Sprintf(`SET LOCAL search_path TO %q, public`, schema),
+	} {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			el.logger.WithError(err).Error("failed to create schema")
+			return 0, err
+		}
+	}
+
+	events, err := readJournalUntil(tx, untilSeq, until)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to read events")
+		return 0, err
+	}
+	last, failed := el.reduceJournal(tx, events)
+
+	err = tx.Commit()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to commit transaction")
+		return 0, err
+	}
+	el.logger.Infof(
+		"projected %d events up to sequence %d into %s, %d failed to reduce",
+		len(events),
+		last,
+		schema,
+		failed,
+	)
+
+	return len(events), nil
+}
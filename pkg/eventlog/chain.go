@@ -0,0 +1,230 @@
+package eventlog
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// hashEvent returns the sha256 of an event in canonical form: the json
+// object with its hash and signature removed, keys sorted and numbers
+// kept exactly as written
+func hashEvent(eventJSON string) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(eventJSON))
+	dec.UseNumber()
+	doc := map[string]interface{}{}
+	err := dec.Decode(&doc)
+	if err != nil {
+		return "", err
+	}
+	delete(doc, "hash")
+	delete(doc, "signature")
+
+	canonical, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseSigningKey reads a base64 encoded ed25519 seed or private key
+func ParseSigningKey(encoded string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("signing key must be %d or %d bytes", ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// ParsePublicKey reads a base64 encoded ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// WithSigningKey signs the hash of every appended event
+func (el EventLog) WithSigningKey(key ed25519.PrivateKey) EventLog {
+	el.signingKey = key
+	return el
+}
+
+// previousHash returns the hash of the event before seq, or an empty
+// string when it was written before events were chained
+func previousHash(sqlClient *sql.Tx, seq int64) (string, error) {
+	var hash sql.NullString
+	err := sqlClient.QueryRow(
+		`SELECT hash FROM events WHERE sequence = $1`,
+		seq-1,
+	).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash.String, err
+}
+
+// chain links an event to its predecessor, hashes it and signs the hash
+// if a signing key is configured
+func (el EventLog) chain(event Event, prevHash string) (Event, error) {
+	h := event.header()
+	h.PrevHash = prevHash
+	h.Hash = ""
+	h.Signature = ""
+	event = event.withHeader(h)
+
+	buf := new(bytes.Buffer)
+	_, err := buf.ReadFrom(getJSON(event))
+	if err != nil {
+		return event, err
+	}
+	h.Hash, err = hashEvent(buf.String())
+	if err != nil {
+		return event, err
+	}
+	if el.signingKey != nil {
+		h.Signature = base64.StdEncoding.EncodeToString(
+			ed25519.Sign(el.signingKey, []byte(h.Hash)),
+		)
+	}
+	return event.withHeader(h), nil
+}
+
+// VerifyProblem is something wrong found while walking the log
+type VerifyProblem struct {
+	Key      string
+	EventID  string
+	Sequence int64
+	Problem  string
+}
+
+// chainVerifier checks events one at a time in log order
+type chainVerifier struct {
+	publicKey ed25519.PublicKey
+	prevSeq   int64
+	prevHash  string
+	chained   bool
+	checked   int
+}
+
+func (v *chainVerifier) check(key eventKey, eventJSON string) []VerifyProblem {
+	problems := []VerifyProblem{}
+	report := func(seq int64, id, format string, args ...interface{}) {
+		problems = append(problems, VerifyProblem{
+			Key:      key.key,
+			EventID:  id,
+			Sequence: seq,
+			Problem:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	h := EventHeader{}
+	err := json.Unmarshal([]byte(eventJSON), &h)
+	if err != nil {
+		report(key.sequence, key.eventID, "cannot decode event: %v", err)
+		return problems
+	}
+	v.checked++
+
+	if key.key != "" && (key.sequence != h.EventSequence || key.eventID != h.EventID) {
+		report(h.EventSequence, h.EventID, "stored under %s which names a different event", key.key)
+	}
+
+	// legacy events were written before sequences and hashes
+	if h.EventSequence == 0 {
+		if v.prevSeq > 0 {
+			report(0, h.EventID, "unsequenced event after sequence %d", v.prevSeq)
+		}
+		return problems
+	}
+
+	switch {
+	case h.EventSequence == v.prevSeq:
+		report(h.EventSequence, h.EventID, "sequence %d appears more than once", h.EventSequence)
+	case h.EventSequence < v.prevSeq:
+		report(h.EventSequence, h.EventID, "sequence %d is out of order after %d", h.EventSequence, v.prevSeq)
+	case v.prevSeq > 0 && h.EventSequence == v.prevSeq+2:
+		report(h.EventSequence, h.EventID, "event %d is missing", v.prevSeq+1)
+	case v.prevSeq > 0 && h.EventSequence > v.prevSeq+2:
+		report(h.EventSequence, h.EventID, "events %d to %d are missing", v.prevSeq+1, h.EventSequence-1)
+	}
+
+	if h.Hash == "" {
+		if v.chained {
+			report(h.EventSequence, h.EventID, "event has no hash")
+		}
+		v.prevSeq = h.EventSequence
+		v.prevHash = ""
+		return problems
+	}
+
+	hash, err := hashEvent(eventJSON)
+	if err != nil {
+		report(h.EventSequence, h.EventID, "cannot hash event: %v", err)
+	} else if hash != h.Hash {
+		report(h.EventSequence, h.EventID, "event has been modified, hash does not match")
+	}
+
+	if v.chained && h.PrevHash != v.prevHash {
+		report(h.EventSequence, h.EventID, "previous hash does not match the event before it")
+	}
+
+	if v.publicKey != nil {
+		sig, err := base64.StdEncoding.DecodeString(h.Signature)
+		if h.Signature == "" {
+			report(h.EventSequence, h.EventID, "event is not signed")
+		} else if err != nil || !ed25519.Verify(v.publicKey, []byte(h.Hash), sig) {
+			report(h.EventSequence, h.EventID, "signature is not valid")
+		}
+	}
+
+	v.chained = true
+	v.prevSeq = h.EventSequence
+	v.prevHash = h.Hash
+	return problems
+}
+
+// Verify walks the whole log in order and reports gaps, reordered or
+// repeated sequences, modified events and broken links in the hash chain.
+// Signatures are checked when a public key is given
+func (el EventLog) Verify(publicKey ed25519.PublicKey) (int, []VerifyProblem, error) {
+	problems := []VerifyProblem{}
+
+	keys, err := el.logKeys(0)
+	if err != nil {
+		return 0, problems, err
+	}
+
+	v := &chainVerifier{publicKey: publicKey}
+	for fetched := range fetchEvents(el.s3Client, el.s3Bucket, keys, el.fetchConcurrency) {
+		if fetched.err != nil {
+			problems = append(problems, VerifyProblem{
+				Key:      fetched.key.key,
+				EventID:  fetched.key.eventID,
+				Sequence: fetched.key.sequence,
+				Problem:  fmt.Sprintf("cannot read event: %v", fetched.err),
+			})
+			continue
+		}
+		problems = append(problems, v.check(fetched.key, fetched.data)...)
+	}
+
+	return v.checked, problems, nil
+}
@@ -0,0 +1,129 @@
+package eventlog
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// chainedLog builds n chained events as they would be written to s3
+func chainedLog(t *testing.T, el EventLog, n int) ([]eventKey, []string) {
+	keys := []eventKey{}
+	docs := []string{}
+	prevHash := ""
+	for i := 1; i <= n; i++ {
+		event := NewMediaDeleted("https://example.com/1.jpg").withHeader(EventHeader{
+			EventID:       strings.Repeat(string(rune('a'+i)), 3),
+			EventType:     "MediaDeleted",
+			EventVersion:  "20191128101010.0001",
+			EventSequence: int64(i),
+		})
+		event, err := el.chain(event, prevHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(getJSON(event))
+
+		keys = append(keys, parseEventKey(fileKey("jay", event.header())))
+		docs = append(docs, buf.String())
+		prevHash = event.header().Hash
+	}
+	return keys, docs
+}
+
+func verifyDocs(publicKey ed25519.PublicKey, keys []eventKey, docs []string) []VerifyProblem {
+	v := &chainVerifier{publicKey: publicKey}
+	problems := []VerifyProblem{}
+	for i := range keys {
+		problems = append(problems, v.check(keys[i], docs[i])...)
+	}
+	return problems
+}
+
+func TestVerifyChain(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	signingKey := ed25519.NewKeyFromSeed(seed)
+	publicKey := signingKey.Public().(ed25519.PublicKey)
+	el := EventLog{}.WithSigningKey(signingKey)
+
+	var tests = []struct {
+		name     string
+		tamper   func(keys []eventKey, docs []string) ([]eventKey, []string)
+		expected []string
+	}{
+		{
+			name: "untouched log verifies",
+			tamper: func(keys []eventKey, docs []string) ([]eventKey, []string) {
+				return keys, docs
+			},
+			expected: []string{},
+		},
+		{
+			name: "modified event",
+			tamper: func(keys []eventKey, docs []string) ([]eventKey, []string) {
+				docs[1] = strings.Replace(docs[1], "1.jpg", "2.jpg", 1)
+				return keys, docs
+			},
+			expected: []string{"event has been modified, hash does not match"},
+		},
+		{
+			name: "deleted event",
+			tamper: func(keys []eventKey, docs []string) ([]eventKey, []string) {
+				return append(keys[:1], keys[2:]...), append(docs[:1], docs[2:]...)
+			},
+			expected: []string{
+				"event 2 is missing",
+				"previous hash does not match the event before it",
+			},
+		},
+		{
+			name: "reordered events",
+			tamper: func(keys []eventKey, docs []string) ([]eventKey, []string) {
+				keys[1], keys[2] = keys[2], keys[1]
+				docs[1], docs[2] = docs[2], docs[1]
+				return keys, docs
+			},
+			expected: []string{
+				"event 2 is missing",
+				"previous hash does not match the event before it",
+				"sequence 2 is out of order after 3",
+				"previous hash does not match the event before it",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			keys, docs := tt.tamper(chainedLog(t, el, 3))
+
+			result := []string{}
+			for _, p := range verifyDocs(publicKey, keys, docs) {
+				result = append(result, p.Problem)
+			}
+			is.Equal(result, tt.expected)
+		})
+	}
+}
+
+func TestVerifyChainSignature(t *testing.T) {
+	is := is.New(t)
+
+	signingKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{8}, ed25519.SeedSize))
+
+	keys, docs := chainedLog(t, EventLog{}.WithSigningKey(signingKey), 2)
+	problems := verifyDocs(otherKey.Public().(ed25519.PublicKey), keys, docs)
+
+	is.Equal(len(problems), 2)
+	is.Equal(problems[0].Problem, "signature is not valid")
+
+	keys, docs = chainedLog(t, EventLog{}, 2)
+	problems = verifyDocs(signingKey.Public().(ed25519.PublicKey), keys, docs)
+	is.Equal(problems[0].Problem, "event is not signed")
+}
@@ -2,48 +2,69 @@ package eventlog
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	uuid "github.com/satori/go.uuid"
 	"github.com/sirupsen/logrus"
 
 	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
-	"github.com/j4y_funabashi/inari-micropub/pkg/s3"
 )
 
+// ErrDuplicateEvent is returned when an event with the same EventID has
+// already been appended to the log
+var ErrDuplicateEvent = errors.New("event has already been appended")
+
+// ErrVersionConflict is returned when a post has been changed since the
+// version an update was based on
+var ErrVersionConflict = errors.New("post has been modified by another update")
+
 func NewEventLog(
 	s3KeyPrefix string,
 	s3Bucket string,
-	s3Client s3.Client,
+	s3Client ObjectStore,
 	db *sql.DB,
 	logger *logrus.Logger,
 ) EventLog {
 	return EventLog{
-		s3KeyPrefix: s3KeyPrefix,
-		s3Bucket:    s3Bucket,
-		s3Client:    s3Client,
-		db:          db,
-		logger:      logger,
+		s3KeyPrefix:      s3KeyPrefix,
+		s3Bucket:         s3Bucket,
+		s3Client:         s3Client,
+		db:               db,
+		logger:           logger,
+		fetchConcurrency: defaultFetchConcurrency,
 	}
 }
 
+// WithFetchConcurrency sets how many event files Replay downloads at once
+func (el EventLog) WithFetchConcurrency(n int) EventLog {
+	el.fetchConcurrency = n
+	return el
+}
+
 type Event interface {
-	getJSON() io.Reader
-	getFilekey() string
-	save(sqlClient *sql.Tx, s3Client s3.Client, s3KeyPrefix, s3Bucket string) error
-	reduce(sqlClient *sql.Tx) error
+	header() EventHeader
+	withHeader(h EventHeader) Event
+	reduce(sqlClient execer) error
 }
 
 type EventLog struct {
-	s3Bucket    string
-	s3KeyPrefix string
-	s3Client    s3.Client
-	db          *sql.DB
-	logger      *logrus.Logger
+	s3Bucket         string
+	s3KeyPrefix      string
+	s3Client         ObjectStore
+	db               *sql.DB
+	logger           *logrus.Logger
+	fetchConcurrency int
+	signingKey       ed25519.PrivateKey
 }
 
 type EventListReader interface {
@@ -51,6 +72,13 @@ type EventListReader interface {
 	ReadObject(key, bucket string) (*bytes.Buffer, error)
 }
 
+// ObjectStore holds events, snapshots and bundles. s3.Client satisfies it
+type ObjectStore interface {
+	EventListReader
+	WriteObject(key, bucket string, body io.Reader, isPrivate bool) error
+	DeleteObject(key, bucket string) error
+}
+
 // Mutator Applies a change to a PostList
 type Mutator interface {
 	Apply(list mf2.PostList) mf2.PostList
@@ -59,50 +87,49 @@ type Mutator interface {
 // EventList Returns a list of events
 type EventList func() ([]Mutator, error)
 
-type MediaUploadedEvent struct {
-	EventID      string            `json:"eventID"`
-	EventType    string            `json:"eventType"`
-	EventVersion string            `json:"eventVersion"`
-	EventData    mf2.MediaMetadata `json:"eventData"`
-}
-
-func (e MediaUploadedEvent) getJSON() io.Reader {
-
-	eventjson := new(bytes.Buffer)
-	err := json.NewEncoder(eventjson).Encode(e)
-	if err != nil {
-		return new(bytes.Buffer)
+// EventHeader holds the fields shared by every event. EventSequence is
+// assigned when the event is appended, events written before sequences
+// existed have a sequence of 0. Hash covers the whole event including
+// PrevHash, the hash of the event before it, which chains the log
+// together. Signature is an ed25519 signature of Hash. SchemaVersion is
+// the version of the event payload, events written before it existed are
+// version 1
+type EventHeader struct {
+	EventID       string `json:"eventID"`
+	EventType     string `json:"eventType"`
+	EventVersion  string `json:"eventVersion"`
+	SchemaVersion int    `json:"schemaVersion,omitempty"`
+	EventSequence int64  `json:"eventSequence,omitempty"`
+	PrevHash      string `json:"prevHash,omitempty"`
+	Hash          string `json:"hash,omitempty"`
+	Signature     string `json:"signature,omitempty"`
+}
+
+func (h EventHeader) header() EventHeader {
+	return h
+}
+
+func newEventHeader(eventType string) EventHeader {
+	uid := uuid.NewV4()
+	return EventHeader{
+		EventID:       uid.String(),
+		EventType:     eventType,
+		EventVersion:  time.Now().Format("20060102150405.0000"),
+		SchemaVersion: currentSchemaVersion(eventType),
 	}
-
-	return eventjson
 }
 
-func (e MediaUploadedEvent) getFilekey() string {
-	return e.EventVersion + "_" + e.EventID + ".json"
+type MediaUploadedEvent struct {
+	EventHeader
+	EventData mf2.MediaMetadata `json:"eventData"`
 }
 
-func (e MediaUploadedEvent) save(sqlClient *sql.Tx, s3Client s3.Client, s3KeyPrefix, s3Bucket string) error {
-	eventData := e.getJSON()
-	fileKey := path.Join(
-		s3KeyPrefix,
-		time.Now().Format("2006"),
-		e.getFilekey(),
-	)
-
-	err := s3Client.WriteObject(
-		fileKey,
-		s3Bucket,
-		eventData,
-		true,
-	)
-
-	return err
+func (e MediaUploadedEvent) withHeader(h EventHeader) Event {
+	e.EventHeader = h
+	return e
 }
 
-func (e MediaUploadedEvent) reduce(sqlClient *sql.Tx) error {
-
-	// TODO get this from ENV
-	e.EventData.URL = "https://media.funabashi.co.uk/" + e.EventData.FileKey
+func (e MediaUploadedEvent) reduce(sqlClient execer) error {
 
 	buf := new(bytes.Buffer)
 	err := json.NewEncoder(buf).Encode(e.EventData)
@@ -110,72 +137,69 @@ func (e MediaUploadedEvent) reduce(sqlClient *sql.Tx) error {
 		return err
 	}
 
+	// media without gps data is recorded at 0, 0
+	located := e.EventData.Lat != 0 || e.EventData.Lng != 0
+	lat, lng := nullableLatLng(e.EventData.Lat, e.EventData.Lng, located)
+
 	_, err = sqlClient.Exec(
 		`INSERT INTO media
-			(id, year, month, day, data, sort_key)
-			VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`,
+			(id, year, month, day, data, sort_key, lat, lng)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT DO NOTHING`,
 		e.EventData.URL,
 		e.EventData.DateTime.Format("2006"),
 		e.EventData.DateTime.Format("01"),
 		e.EventData.DateTime.Format("02"),
 		buf.String(),
 		e.EventData.DateTime.Format(time.RFC3339)+e.EventData.Uid,
+		lat,
+		lng,
 	)
 	return err
 }
 
+// nullableLatLng returns lat and lng as query arguments, NULL when the
+// item is not located
+func nullableLatLng(lat, lng float64, located bool) (interface{}, interface{}) {
+	if !located {
+		return nil, nil
+	}
+	return lat, lng
+}
+
+// NewMediaUploaded records an uploaded file under the url it is served
+// from
 func NewMediaUploaded(data mf2.MediaMetadata) MediaUploadedEvent {
-	uid := uuid.NewV4()
+	data.URL = MediaBaseURL + data.FileKey
 	return MediaUploadedEvent{
-		EventID:      uid.String(),
-		EventType:    "MediaUploaded",
-		EventVersion: time.Now().Format("20060102150405.0000"),
-		EventData:    data}
+		EventHeader: newEventHeader("MediaUploaded"),
+		EventData:   data,
+	}
 }
 
+// PostUpdatedEvent replaces the whole post document. ExpectedVersion is
+// the post version the update was based on, Append rejects the update
+// when the post has moved on, 0 skips the check. It is never checked again
+// when the event is replayed
 type PostUpdatedEvent struct {
-	EventID      string          `json:"eventID"`
-	EventType    string          `json:"eventType"`
-	EventVersion string          `json:"eventVersion"`
-	EventData    mf2.MicroFormat `json:"eventData"`
+	EventHeader
+	ExpectedVersion int             `json:"expectedVersion,omitempty"`
+	EventData       mf2.MicroFormat `json:"eventData"`
 }
 
-func NewPostUpdated(mf mf2.MicroFormat) PostUpdatedEvent {
-	uid := uuid.NewV4()
+func NewPostUpdated(mf mf2.MicroFormat, expectedVersion int) PostUpdatedEvent {
 	return PostUpdatedEvent{
-		EventID:      uid.String(),
-		EventType:    "PostUpdated",
-		EventVersion: time.Now().Format("20060102150405.0000"),
-		EventData:    mf}
-}
-func (e PostUpdatedEvent) getFilekey() string {
-	return e.EventVersion + "_" + e.EventID + ".json"
-}
-func (e PostUpdatedEvent) getJSON() io.Reader {
-	eventjson := new(bytes.Buffer)
-	err := json.NewEncoder(eventjson).Encode(e)
-	if err != nil {
-		return new(bytes.Buffer)
+		EventHeader:     newEventHeader("PostUpdated"),
+		ExpectedVersion: expectedVersion,
+		EventData:       mf,
 	}
-	return eventjson
 }
-func (e PostUpdatedEvent) save(sqlClient *sql.Tx, s3Client s3.Client, s3KeyPrefix, s3Bucket string) error {
-	eventData := e.getJSON()
-	fileKey := path.Join(
-		s3KeyPrefix,
-		time.Now().Format("2006"),
-		e.getFilekey(),
-	)
-	err := s3Client.WriteObject(
-		fileKey,
-		s3Bucket,
-		eventData,
-		true,
-	)
-	return err
+
+func (e PostUpdatedEvent) withHeader(h EventHeader) Event {
+	e.EventHeader = h
+	return e
 }
 
-func (e PostUpdatedEvent) reduce(sqlClient *sql.Tx) error {
+func (e PostUpdatedEvent) reduce(sqlClient execer) error {
 
 	buf := new(bytes.Buffer)
 	err := json.NewEncoder(buf).Encode(e.EventData)
@@ -183,46 +207,42 @@ func (e PostUpdatedEvent) reduce(sqlClient *sql.Tx) error {
 		return err
 	}
 
+	// an update checked against a version always moves the post to the
+	// next one, so replaying it restores the version it was appended at
+	lat, lng := nullableLatLng(e.EventData.LatLng())
 	_, err = sqlClient.Exec(
-		`UPDATE posts SET data = $1 WHERE id = $2`,
+		`UPDATE posts SET data = $1, search_text = $4, post_type = $5, lat = $6, lng = $7,
+			version = CASE WHEN $3 = 0 THEN version + 1 ELSE $3 + 1 END
+			WHERE id = $2`,
 		buf.String(),
 		e.EventData.GetFirstString("url"),
+		e.ExpectedVersion,
+		e.EventData.SearchText(),
+		e.EventData.PostType(),
+		lat,
+		lng,
 	)
 	return err
 }
 
 type MediaDeletedEvent struct {
-	EventID      string `json:"eventID"`
-	EventType    string `json:"eventType"`
-	EventVersion string `json:"eventVersion"`
-	EventData    string `json:"eventData"`
+	EventHeader
+	EventData string `json:"eventData"`
 }
 
 func NewMediaDeleted(mediaURL string) MediaDeletedEvent {
-	uid := uuid.NewV4()
 	return MediaDeletedEvent{
-		EventID:      uid.String(),
-		EventType:    "MediaDeleted",
-		EventVersion: time.Now().Format("20060102150405.0000"),
-		EventData:    mediaURL}
-}
-
-func (e MediaDeletedEvent) getFilekey() string {
-	return e.EventVersion + "_" + e.EventID + ".json"
-}
-
-func (e MediaDeletedEvent) getJSON() io.Reader {
-
-	eventjson := new(bytes.Buffer)
-	err := json.NewEncoder(eventjson).Encode(e)
-	if err != nil {
-		return new(bytes.Buffer)
+		EventHeader: newEventHeader("MediaDeleted"),
+		EventData:   mediaURL,
 	}
+}
 
-	return eventjson
+func (e MediaDeletedEvent) withHeader(h EventHeader) Event {
+	e.EventHeader = h
+	return e
 }
 
-func (e MediaDeletedEvent) reduce(sqlClient *sql.Tx) error {
+func (e MediaDeletedEvent) reduce(sqlClient execer) error {
 	_, err := sqlClient.Exec(
 		`DELETE FROM media WHERE id = $1`,
 		e.EventData,
@@ -230,61 +250,33 @@ func (e MediaDeletedEvent) reduce(sqlClient *sql.Tx) error {
 	return err
 }
 
-func (e MediaDeletedEvent) save(sqlClient *sql.Tx, s3Client s3.Client, s3KeyPrefix, s3Bucket string) error {
-	eventData := e.getJSON()
-	fileKey := path.Join(
-		s3KeyPrefix,
-		time.Now().Format("2006"),
-		e.getFilekey(),
-	)
-
-	err := s3Client.WriteObject(
-		fileKey,
-		s3Bucket,
-		eventData,
-		true,
-	)
-
-	return err
+// PostSortKey orders posts by when they were published. Times are in
+// UTC so posts published with different offsets sort correctly, the uid
+// breaks ties
+func PostSortKey(published time.Time, uid string) string {
+	return published.UTC().Format(time.RFC3339) + uid
 }
 
 type PostCreatedEvent struct {
-	EventID      string          `json:"eventID"`
-	EventType    string          `json:"eventType"`
-	EventVersion string          `json:"eventVersion"`
-	EventData    mf2.MicroFormat `json:"eventData"`
+	EventHeader
+	EventData mf2.MicroFormat `json:"eventData"`
 }
 
 func NewPostCreated(mf mf2.MicroFormat) PostCreatedEvent {
-	uid := uuid.NewV4()
 	return PostCreatedEvent{
-		EventID:      uid.String(),
-		EventType:    "PostCreated",
-		EventVersion: time.Now().Format("20060102150405.0000"),
-		EventData:    mf}
-}
-
-func (e PostCreatedEvent) save(sqlClient *sql.Tx, s3Client s3.Client, s3KeyPrefix, s3Bucket string) error {
-	eventData := e.getJSON()
-	fileKey := path.Join(
-		s3KeyPrefix,
-		time.Now().Format("2006"),
-		e.getFilekey(),
-	)
-
-	err := s3Client.WriteObject(
-		fileKey,
-		s3Bucket,
-		eventData,
-		true,
-	)
+		EventHeader: newEventHeader("PostCreated"),
+		EventData:   mf,
+	}
+}
 
-	return err
+func (e PostCreatedEvent) withHeader(h EventHeader) Event {
+	e.EventHeader = h
+	return e
 }
 
-func (e PostCreatedEvent) reduce(sqlClient *sql.Tx) error {
+func (e PostCreatedEvent) reduce(sqlClient execer) error {
 
-	if e.EventData.GetFirstString("photo") == "" {
+	if !e.EventData.IsPost() {
 		return nil
 	}
 
@@ -299,13 +291,18 @@ func (e PostCreatedEvent) reduce(sqlClient *sql.Tx) error {
 		return err
 	}
 
+	lat, lng := nullableLatLng(e.EventData.LatLng())
 	_, err = sqlClient.Exec(
-		`INSERT INTO posts (id, year, month, data, sort_key) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`,
+		`INSERT INTO posts (id, year, month, data, sort_key, version, search_text, post_type, lat, lng) VALUES ($1, $2, $3, $4, $5, 1, $6, $7, $8, $9) ON CONFLICT DO NOTHING`,
 		e.EventData.GetFirstString("url"),
-		published.Format("2006"),
-		published.Format("01"),
+		published.UTC().Format("2006"),
+		published.UTC().Format("01"),
 		buf.String(),
-		published.Format(time.RFC3339)+e.EventData.GetFirstString("uid"),
+		PostSortKey(published, e.EventData.GetFirstString("uid")),
+		e.EventData.SearchText(),
+		e.EventData.PostType(),
+		lat,
+		lng,
 	)
 	if err != nil {
 		return err
@@ -324,58 +321,136 @@ func (e PostCreatedEvent) reduce(sqlClient *sql.Tx) error {
 	return err
 }
 
-func (e PostCreatedEvent) getJSON() io.Reader {
+func (e PostCreatedEvent) Apply(list mf2.PostList) mf2.PostList {
+	list.Add(e.EventData)
+	return list
+}
+
+type nullEvent struct {
+	EventHeader
+}
 
+func (e nullEvent) withHeader(h EventHeader) Event {
+	e.EventHeader = h
+	return e
+}
+func (e nullEvent) reduce(sqlClient execer) error {
+	return nil
+}
+
+// getJSON encodes an event as it is stored in the log
+func getJSON(event Event) io.Reader {
 	eventjson := new(bytes.Buffer)
-	err := json.NewEncoder(eventjson).Encode(e)
+	err := json.NewEncoder(eventjson).Encode(event)
 	if err != nil {
 		return new(bytes.Buffer)
 	}
-
 	return eventjson
 }
 
-func (e PostCreatedEvent) getFilekey() string {
-	return e.EventVersion + "_" + e.EventID + ".json"
+// eventKey is an event s3 key along with the ordering information parsed
+// from its file name. Events read from a bundle have no key of their own
+// and carry their json in data instead
+type eventKey struct {
+	key      string
+	sequence int64
+	version  string
+	eventID  string
+	data     string
+}
+
+// fileKey returns the s3 key for an event, partitioned by the year it was
+// created and named by its zero padded sequence so keys sort in log order.
+// Legacy events without a sequence keep their EventVersion name
+func fileKey(s3KeyPrefix string, h EventHeader) string {
+	year := h.EventVersion
+	if len(year) > 4 {
+		year = year[:4]
+	}
+	name := fmt.Sprintf("%020d_%s.json", h.EventSequence, h.EventID)
+	if h.EventSequence == 0 {
+		name = fmt.Sprintf("%s_%s.json", h.EventVersion, h.EventID)
+	}
+	return path.Join(s3KeyPrefix, year, name)
+}
+
+// parseEventKey reads the ordering information from an s3 key. Keys
+// written before sequences existed are named by EventVersion, which
+// always contains a "."
+func parseEventKey(key string) eventKey {
+	out := eventKey{key: key}
+	name := strings.TrimSuffix(path.Base(key), ".json")
+	parts := strings.SplitN(name, "_", 2)
+	if len(parts) == 2 {
+		out.eventID = parts[1]
+	}
+	if strings.Contains(parts[0], ".") {
+		out.version = parts[0]
+		return out
+	}
+	seq, err := strconv.ParseInt(parts[0], 10, 64)
+	if err == nil {
+		out.sequence = seq
+	}
+	return out
 }
 
-func (e PostCreatedEvent) Apply(list mf2.PostList) mf2.PostList {
-	list.Add(e.EventData)
-	return list
+// sortEventKeys orders keys for replay. Unsequenced legacy events come
+// first in EventVersion order, followed by every sequenced event
+func sortEventKeys(keys []*string) []eventKey {
+	out := []eventKey{}
+	for _, key := range keys {
+		if key == nil {
+			continue
+		}
+		out = append(out, parseEventKey(*key))
+	}
+	sortKeys(out)
+	return out
 }
 
-type nullEvent struct{}
-
-func (e nullEvent) getJSON() io.Reader {
-	return new(bytes.Buffer)
-}
-func (e nullEvent) getFilekey() string {
-	return ""
-}
-func (e nullEvent) save(sqlClient *sql.Tx, s3Client s3.Client, s3KeyPrefix, s3Bucket string) error {
-	return nil
+func sortKeys(keys []eventKey) {
+	sort.SliceStable(keys, func(a, b int) bool {
+		if keys[a].sequence != keys[b].sequence {
+			return keys[a].sequence < keys[b].sequence
+		}
+		if keys[a].version != keys[b].version {
+			return keys[a].version < keys[b].version
+		}
+		return keys[a].eventID < keys[b].eventID
+	})
 }
-func (e nullEvent) reduce(sqlClient *sql.Tx) error {
-	return nil
+
+// DecodeEvent reads an event as stored in the log, upcasting it to the
+// current schema version of its type
+func DecodeEvent(eventJSON string) (Event, error) {
+	return decodeEvent(eventJSON)
 }
 
 // decodeEvent will take an event json string and return the appropriate
 // mutate function based on the eventType
 func decodeEvent(eventJSON string) (Event, error) {
 
-	e := struct {
-		EventType string `json:"eventType"`
-	}{}
+	e := EventHeader{}
 	err := json.Unmarshal([]byte(eventJSON), &e)
 	if err != nil {
 		return nullEvent{}, err
 	}
 
+	eventJSON, err = upcast(eventJSON, e)
+	if err != nil {
+		return nullEvent{EventHeader: e}, err
+	}
+
 	switch e.EventType {
 	case "PostCreated":
 		ev := PostCreatedEvent{}
 		json.Unmarshal([]byte(eventJSON), &ev)
 		return ev, nil
+	case "PostUpdated":
+		ev := PostUpdatedEvent{}
+		json.Unmarshal([]byte(eventJSON), &ev)
+		return ev, nil
 	case "MediaUploaded":
 		ev := MediaUploadedEvent{}
 		json.Unmarshal([]byte(eventJSON), &ev)
@@ -386,65 +461,181 @@ func decodeEvent(eventJSON string) (Event, error) {
 		return ev, nil
 	}
 
-	return nullEvent{}, nil
+	return nullEvent{EventHeader: e}, nil
 }
 
-// Replay builds up a PostList by fetching all events and applying them
-func (el EventLog) Replay() error {
-	allKeys, err := el.s3Client.ListKeys(el.s3Bucket, el.s3KeyPrefix)
+// nextSequence claims the next sequence number. The counter row stays
+// locked until the transaction ends, so appends commit in sequence order
+func nextSequence(sqlClient *sql.Tx) (int64, error) {
+	var seq int64
+	_, err := sqlClient.Exec(`UPDATE event_sequence SET last = last + 1 WHERE id = 1`)
 	if err != nil {
-		el.logger.WithError(err).Error("failed to list event keys")
-		return err
+		return seq, err
 	}
+	err = sqlClient.QueryRow(`SELECT last FROM event_sequence WHERE id = 1`).Scan(&seq)
+	return seq, err
+}
 
-	el.logger.Infof("found %d events, starting transaction", len(allKeys))
-	tx, err := el.db.Begin()
+// checkExpectedVersion returns ErrVersionConflict when event is an update
+// based on a version of a post other than the current one. The post row
+// is locked until the transaction ends so the version cannot move on
+// before the update is reduced
+func checkExpectedVersion(sqlClient *sql.Tx, event Event) error {
+	e, ok := event.(PostUpdatedEvent)
+	if !ok || e.ExpectedVersion == 0 {
+		return nil
+	}
+
+	var version int
+	err := sqlClient.QueryRow(
+		`SELECT version FROM posts WHERE id = $1 FOR UPDATE`,
+		e.EventData.GetFirstString("url"),
+	).Scan(&version)
+	if err == sql.ErrNoRows {
+		return ErrVersionConflict
+	}
 	if err != nil {
-		el.logger.WithError(err).Error("failed to start transaction")
 		return err
 	}
+	if version != e.ExpectedVersion {
+		return ErrVersionConflict
+	}
+	return nil
+}
 
-	for _, key := range allKeys {
-		buf, err := el.s3Client.ReadObject(*key, el.s3Bucket)
-		if err != nil {
-			el.logger.
-				WithField("key", key).
-				WithError(err).
-				Error("failed to read event file")
-			continue
-		}
+// eventPostURL is the url of the post an event creates or updates, NULL
+// for events of any other type
+func eventPostURL(event Event) sql.NullString {
+	switch e := event.(type) {
+	case PostCreatedEvent:
+		return sql.NullString{String: e.EventData.GetFirstString("url"), Valid: true}
+	case PostUpdatedEvent:
+		return sql.NullString{String: e.EventData.GetFirstString("url"), Valid: true}
+	}
+	return sql.NullString{}
+}
+
+// recordEvent stores an event in the events table, returning false if an
+// event with the same EventID has already been recorded. Events that are
+// not yet shipped are uploaded to s3 by Ship
+func recordEvent(sqlClient *sql.Tx, event Event, shipped bool) (bool, error) {
+	h := event.header()
+
+	buf := new(bytes.Buffer)
+	_, err := buf.ReadFrom(getJSON(event))
+	if err != nil {
+		return false, err
+	}
+
+	res, err := sqlClient.Exec(
+		`INSERT INTO events
+			(id, sequence, event_type, event_version, data, shipped, hash, post_url)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT DO NOTHING`,
+		h.EventID,
+		sql.NullInt64{Int64: h.EventSequence, Valid: h.EventSequence > 0},
+		h.EventType,
+		h.EventVersion,
+		buf.String(),
+		shipped,
+		sql.NullString{String: h.Hash, Valid: h.Hash != ""},
+		eventPostURL(event),
+	)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// knownEventIDs returns the ids of every event already recorded locally
+func (el EventLog) knownEventIDs() (map[string]bool, error) {
+	known := map[string]bool{}
+	rows, err := el.db.Query(`SELECT id FROM events`)
+	if err != nil {
+		return known, err
+	}
+	defer rows.Close()
 
-		event, err := decodeEvent(buf.String())
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
 		if err != nil {
-			el.logger.
-				WithField("key", key).
-				WithError(err).
-				Error("failed to decode event file")
-			continue
+			return known, err
 		}
+		known[id] = true
+	}
+	return known, nil
+}
 
-		err = event.reduce(tx)
+// unshippedEvents returns the sequence of every event journaled locally
+// that has not reached s3 yet, keyed by event id
+func (el EventLog) unshippedEvents() (map[string]int64, error) {
+	unshipped := map[string]int64{}
+	rows, err := el.db.Query(`SELECT id, sequence FROM events WHERE NOT shipped`)
+	if err != nil {
+		return unshipped, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var seq sql.NullInt64
+		err := rows.Scan(&id, &seq)
 		if err != nil {
-			el.logger.
-				WithField("key", key).
-				WithError(err).
-				Error("failed to reduce event to db")
-			continue
+			return unshipped, err
 		}
+		unshipped[id] = seq.Int64
+	}
+	return unshipped, rows.Err()
+}
+
+// advanceSequence moves the sequence counter past seq so new appends
+// never reuse a sequence that already exists in the log
+func (el EventLog) advanceSequence(seq int64) error {
+	_, err := el.db.Exec(
+		`UPDATE event_sequence SET last = $1 WHERE id = 1 AND last < $1`,
+		seq,
+	)
+	return err
+}
 
+// recordedThrough returns the latest sequence when every event up to it
+// has been recorded, otherwise 0
+func (el EventLog) recordedThrough() (int64, error) {
+	var count, last int64
+	err := el.db.QueryRow(
+		`SELECT COUNT(DISTINCT sequence), COALESCE(MAX(sequence), 0) FROM events WHERE sequence > 0`,
+	).Scan(&count, &last)
+	if err != nil || count != last {
+		return 0, err
 	}
+	return last, nil
+}
 
-	err = tx.Commit()
+// logKeys lists the events in the log, both single event files and events
+// compacted into bundles, in replay order. Bundles holding only events up
+// to and including the through sequence are not read
+func (el EventLog) logKeys(through int64) ([]eventKey, error) {
+	allKeys, err := el.s3Client.ListKeys(el.s3Bucket, el.s3KeyPrefix)
 	if err != nil {
-		el.logger.WithError(err).Error("failed to commit transaction")
-		return err
+		return []eventKey{}, err
 	}
-	el.logger.Infof("completed replaying %d events", len(allKeys))
-
-	return nil
+	archived, err := el.archivedKeys(through)
+	if err != nil {
+		return []eventKey{}, err
+	}
+	keys := append(sortEventKeys(allKeys), archived...)
+	sortKeys(keys)
+	return keys, nil
 }
 
-// Append will save an event to remote and local storage
+// Append assigns the next sequence number to an event and commits it to
+// the local journal in the same transaction as its reduce and outbox
+// entry, so an event is either fully applied or not stored at all. Ship
+// uploads it to s3 afterwards
 func (el EventLog) Append(event Event) error {
 
 	tx, err := el.db.Begin()
@@ -453,18 +644,64 @@ func (el EventLog) Append(event Event) error {
 		return err
 	}
 
-	err = event.save(tx, el.s3Client, el.s3KeyPrefix, el.s3Bucket)
+	seq, err := nextSequence(tx)
+	if err != nil {
+		tx.Rollback()
+		el.logger.WithError(err).Error("failed to assign event sequence")
+		return err
+	}
+	h := event.header()
+	h.EventSequence = seq
+	event = event.withHeader(h)
+
+	prevHash, err := previousHash(tx, seq)
+	if err != nil {
+		tx.Rollback()
+		el.logger.WithError(err).Error("failed to read previous event hash")
+		return err
+	}
+	event, err = el.chain(event, prevHash)
+	if err != nil {
+		tx.Rollback()
+		el.logger.WithError(err).Error("failed to hash event")
+		return err
+	}
+
+	err = checkExpectedVersion(tx, event)
+	if err != nil {
+		tx.Rollback()
+		el.logger.WithError(err).Error("failed to check post version")
+		return err
+	}
+
+	isNew, err := recordEvent(tx, event, false)
 	if err != nil {
-		el.logger.WithError(err).Error("failed to save event")
+		tx.Rollback()
+		el.logger.WithError(err).Error("failed to record event")
 		return err
 	}
+	if !isNew {
+		tx.Rollback()
+		el.logger.
+			WithField("eventID", event.header().EventID).
+			Error("rejected duplicate event")
+		return ErrDuplicateEvent
+	}
 
 	err = event.reduce(tx)
 	if err != nil {
+		tx.Rollback()
 		el.logger.WithError(err).Error("failed to reduce event")
 		return err
 	}
 
+	err = writeOutbox(tx, event)
+	if err != nil {
+		tx.Rollback()
+		el.logger.WithError(err).Error("failed to queue event for subscribers")
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		el.logger.WithError(err).Error("failed to commit transaction")
@@ -473,3 +710,98 @@ func (el EventLog) Append(event Event) error {
 
 	return err
 }
+
+// Ship uploads journaled events to s3 in sequence order and marks them
+// shipped, returning how many were uploaded. Rows are locked while they
+// are uploaded so several shippers never upload the same event at once.
+// Uploads are idempotent, an event shipped twice overwrites its own key
+func (el EventLog) Ship() (int, error) {
+	shipped := 0
+	for {
+		count, err := el.shipBatch(100)
+		shipped += count
+		if err != nil || count == 0 {
+			return shipped, err
+		}
+	}
+}
+
+func (el EventLog) shipBatch(limit int) (int, error) {
+	tx, err := el.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT id, data FROM events
+			WHERE NOT shipped
+			ORDER BY sequence
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED`,
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	pending := map[string]string{}
+	ids := []string{}
+	for rows.Next() {
+		var id, data string
+		err := rows.Scan(&id, &data)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending[id] = data
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	shipped := 0
+	var shipErr error
+	for _, id := range ids {
+		event, err := decodeEvent(pending[id])
+		if err != nil {
+			shipErr = err
+			break
+		}
+		err = el.s3Client.WriteObject(
+			fileKey(el.s3KeyPrefix, event.header()),
+			el.s3Bucket,
+			strings.NewReader(pending[id]),
+			true,
+		)
+		if err != nil {
+			shipErr = err
+			break
+		}
+		// a failed statement aborts the whole transaction in postgres, the
+		// batch is uploaded again by the next run
+		_, err = tx.Exec(`UPDATE events SET shipped = true WHERE id = $1`, id)
+		if err != nil {
+			return 0, err
+		}
+		shipped++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return shipped, shipErr
+}
+
+// RunShipper ships journaled events every interval, it never returns
+func (el EventLog) RunShipper(interval time.Duration) {
+	for {
+		_, err := el.Ship()
+		if err != nil {
+			el.logger.WithError(err).Error("failed to ship events")
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,364 @@
+package eventlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestFileKey(t *testing.T) {
+	var tests = []struct {
+		name     string
+		header   EventHeader
+		expected string
+	}{
+		{
+			name: "sequenced event",
+			header: EventHeader{
+				EventID:       "abc-123",
+				EventVersion:  "20191128101010.1234",
+				EventSequence: 42,
+			},
+			expected: "jay/2019/00000000000000000042_abc-123.json",
+		},
+		{
+			name: "legacy event",
+			header: EventHeader{
+				EventID:      "abc-123",
+				EventVersion: "20191128101010.1234",
+			},
+			expected: "jay/2019/20191128101010.1234_abc-123.json",
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result := fileKey("jay", tt.header)
+			is.Equal(result, tt.expected)
+		})
+	}
+}
+
+func TestSortEventKeys(t *testing.T) {
+	var tests = []struct {
+		name     string
+		keys     []string
+		expected []string
+	}{
+		{
+			name: "legacy events replay before sequenced events",
+			keys: []string{
+				"jay/2019/00000000000000000002_e2.json",
+				"jay/2019/20191128101010.0002_l2.json",
+				"jay/2018/00000000000000000001_e1.json",
+				"jay/2018/20181231235959.9999_l1.json",
+			},
+			expected: []string{
+				"jay/2018/20181231235959.9999_l1.json",
+				"jay/2019/20191128101010.0002_l2.json",
+				"jay/2018/00000000000000000001_e1.json",
+				"jay/2019/00000000000000000002_e2.json",
+			},
+		},
+		{
+			name: "sequence wins over year partition",
+			keys: []string{
+				"jay/2020/00000000000000000009_a.json",
+				"jay/2019/00000000000000000010_b.json",
+			},
+			expected: []string{
+				"jay/2020/00000000000000000009_a.json",
+				"jay/2019/00000000000000000010_b.json",
+			},
+		},
+		{
+			name: "same legacy version orders by id",
+			keys: []string{
+				"jay/2019/20191128101010.0002_b.json",
+				"jay/2019/20191128101010.0002_a.json",
+			},
+			expected: []string{
+				"jay/2019/20191128101010.0002_a.json",
+				"jay/2019/20191128101010.0002_b.json",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			keys := []*string{}
+			for i := range tt.keys {
+				keys = append(keys, &tt.keys[i])
+			}
+
+			result := []string{}
+			for _, k := range sortEventKeys(keys) {
+				result = append(result, k.key)
+			}
+
+			is.Equal(result, tt.expected)
+		})
+	}
+}
+
+func TestDecodeEventKeepsSequence(t *testing.T) {
+	is := is.New(t)
+
+	event, err := decodeEvent(`{"eventID": "abc", "eventType": "MediaDeleted", "eventVersion": "20191128101010.1234", "eventSequence": 7, "eventData": "https://example.com/1.jpg"}`)
+
+	is.NoErr(err)
+	is.Equal(event.header().EventSequence, int64(7))
+	is.Equal(event.(MediaDeletedEvent).EventData, "https://example.com/1.jpg")
+}
+
+func TestMergeBundle(t *testing.T) {
+	is := is.New(t)
+
+	events := []string{
+		`{"eventID": "b", "eventType": "MediaDeleted", "eventVersion": "20191128101010.0001", "eventSequence": 2}`,
+		`{"eventID": "a", "eventType": "MediaDeleted", "eventVersion": "20191128101010.0001", "eventSequence": 1}`,
+		`{"eventID": "l", "eventType": "PostCreated", "eventVersion": "20181128101010.0001"}`,
+		`{"eventID": "b", "eventType": "MediaDeleted", "eventVersion": "20191128101010.0001", "eventSequence": 2}`,
+	}
+
+	buf, err := encodeBundle(events)
+	is.NoErr(err)
+	decoded, err := decodeBundle(buf)
+	is.NoErr(err)
+	is.Equal(decoded, events)
+
+	merged, err := mergeBundle(decoded)
+	is.NoErr(err)
+	is.Equal(merged, []string{events[2], events[1], events[0]})
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	year := "2019"
+	snap := Snapshot{
+		FormatVersion: snapshotFormatVersion,
+		Sequence:      42,
+		Tables: []snapshotTable{
+			{
+				Name:    "posts",
+				Columns: []string{"id", "year", "version"},
+				Rows:    [][]*string{{&year, &year, nil}},
+			},
+		},
+	}
+
+	buf, err := encodeSnapshot(snap)
+	is.NoErr(err)
+	result, err := decodeSnapshot(buf)
+	is.NoErr(err)
+
+	is.Equal(result.Sequence, int64(42))
+	is.Equal(result.Tables[0].Columns, snap.Tables[0].Columns)
+	is.Equal(*result.Tables[0].Rows[0][1], "2019")
+	is.Equal(result.Tables[0].Rows[0][2], (*string)(nil))
+}
+
+func TestLatestSnapshotKey(t *testing.T) {
+	is := is.New(t)
+
+	k1 := snapshotKey("jay", 9)
+	k2 := snapshotKey("jay", 10)
+
+	is.Equal(k2, "snapshots/jay/00000000000000000010.json.gz")
+	is.Equal(latestKey([]*string{&k2, &k1}), k2)
+	is.Equal(latestKey([]*string{}), "")
+}
+
+func TestBackoff(t *testing.T) {
+	var tests = []struct {
+		name     string
+		attempts int
+		expected time.Duration
+	}{
+		{name: "first retry waits the minimum", attempts: 1, expected: 10 * time.Second},
+		{name: "wait doubles", attempts: 3, expected: 40 * time.Second},
+		{name: "wait is capped", attempts: 20, expected: time.Hour},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			is.Equal(backoff(tt.attempts, 10*time.Second, time.Hour), tt.expected)
+		})
+	}
+}
+
+func TestVerifyCounts(t *testing.T) {
+	var tests = []struct {
+		name        string
+		live        map[string]int
+		rebuilt     map[string]int
+		allowShrink bool
+		expectErr   bool
+	}{
+		{
+			name:    "rebuild with the same rows is accepted",
+			live:    map[string]int{"posts": 2, "media": 3, "media_published": 1},
+			rebuilt: map[string]int{"posts": 2, "media": 3, "media_published": 1},
+		},
+		{
+			name:    "rebuild with more rows is accepted",
+			live:    map[string]int{"posts": 2, "media": 3, "media_published": 1},
+			rebuilt: map[string]int{"posts": 4, "media": 3, "media_published": 1},
+		},
+		{
+			name:      "rebuild that lost rows is rejected",
+			live:      map[string]int{"posts": 2, "media": 3, "media_published": 1},
+			rebuilt:   map[string]int{"posts": 2, "media": 0, "media_published": 1},
+			expectErr: true,
+		},
+		{
+			name:        "lost rows are accepted when shrinking is allowed",
+			live:        map[string]int{"posts": 2, "media": 3, "media_published": 1},
+			rebuilt:     map[string]int{"posts": 2, "media": 0, "media_published": 1},
+			allowShrink: true,
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyCounts(tt.live, tt.rebuilt, tt.allowShrink)
+			is.Equal(err != nil, tt.expectErr)
+		})
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	var tests = []struct {
+		name        string
+		until       string
+		expectedSeq int64
+		expected    time.Time
+		expectErr   bool
+	}{
+		{
+			name:        "sequence",
+			until:       "42",
+			expectedSeq: 42,
+		},
+		{
+			name:     "timestamp",
+			until:    "2019-03-01T10:00:00Z",
+			expected: time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "date",
+			until:    "2019-03-01",
+			expected: time.Date(2019, 3, 1, 0, 0, 0, 0, time.Local),
+		},
+		{
+			name:      "anything else",
+			until:     "last march",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			seq, until, err := ParseUntil(tt.until)
+			is.Equal(err != nil, tt.expectErr)
+			is.Equal(seq, tt.expectedSeq)
+			is.True(until.Equal(tt.expected))
+		})
+	}
+}
+
+func TestReplayOptionsMatch(t *testing.T) {
+	h := EventHeader{
+		EventID:       "abc",
+		EventType:     "MediaUploaded",
+		EventVersion:  "20190315101010.0001",
+		EventSequence: 3,
+	}
+	bundled := eventKey{sequence: 3, eventID: "abc", data: "{}"}
+
+	var tests = []struct {
+		name     string
+		opts     ReplayOptions
+		key      eventKey
+		expected bool
+	}{
+		{
+			name:     "no filters",
+			opts:     ReplayOptions{},
+			key:      parseEventKey("jay/2019/00000000000000000003_abc.json"),
+			expected: true,
+		},
+		{
+			name:     "matching event type",
+			opts:     ReplayOptions{EventTypes: []string{"PostCreated", "MediaUploaded"}},
+			key:      bundled,
+			expected: true,
+		},
+		{
+			name:     "other event type",
+			opts:     ReplayOptions{EventTypes: []string{"PostCreated"}},
+			key:      bundled,
+			expected: false,
+		},
+		{
+			name: "inside date range",
+			opts: ReplayOptions{
+				From: time.Date(2019, 3, 1, 0, 0, 0, 0, time.Local),
+				To:   time.Date(2019, 4, 1, 0, 0, 0, 0, time.Local),
+			},
+			key:      bundled,
+			expected: true,
+		},
+		{
+			name:     "before date range",
+			opts:     ReplayOptions{From: time.Date(2019, 4, 1, 0, 0, 0, 0, time.Local)},
+			key:      bundled,
+			expected: false,
+		},
+		{
+			name:     "range end is exclusive",
+			opts:     ReplayOptions{To: time.Date(2019, 3, 15, 10, 10, 10, 100000, time.Local)},
+			key:      bundled,
+			expected: false,
+		},
+		{
+			name:     "key prefix",
+			opts:     ReplayOptions{KeyPrefix: "2019"},
+			key:      parseEventKey("jay/2019/00000000000000000003_abc.json"),
+			expected: true,
+		},
+		{
+			name:     "other key prefix",
+			opts:     ReplayOptions{KeyPrefix: "2018"},
+			key:      parseEventKey("jay/2019/00000000000000000003_abc.json"),
+			expected: false,
+		},
+		{
+			name:     "key prefix of a bundled event",
+			opts:     ReplayOptions{KeyPrefix: "2019"},
+			key:      bundled,
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.opts.matches(h) && tt.opts.matchesKey("jay", tt.key, h)
+			is.Equal(result, tt.expected)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package eventlog
+
+import (
+	"time"
+)
+
+// defaultFetchConcurrency is how many event files are downloaded at once
+// during replay unless WithFetchConcurrency says otherwise
+const defaultFetchConcurrency = 8
+
+// fetchedEvent is the json of an event key, or the error reading it
+type fetchedEvent struct {
+	key  eventKey
+	data string
+	err  error
+}
+
+// fetchEvents downloads event files with up to concurrency workers and
+// delivers them on the returned channel in the same order as keys. Keys
+// read from a bundle already carry their json and are not downloaded.
+// Workers never run more than a few windows ahead of the reader so memory
+// stays bounded however long the log is
+func fetchEvents(store EventListReader, bucket string, keys []eventKey, concurrency int) <-chan fetchedEvent {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	window := concurrency * 4
+
+	out := make(chan fetchedEvent)
+	slots := make([]chan fetchedEvent, len(keys))
+	for i := range slots {
+		slots[i] = make(chan fetchedEvent, 1)
+	}
+	tokens := make(chan struct{}, window)
+	jobs := make(chan int)
+
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			for i := range jobs {
+				key := keys[i]
+				result := fetchedEvent{key: key, data: key.data}
+				if result.data == "" {
+					buf, err := store.ReadObject(key.key, bucket)
+					if err == nil {
+						result.data = buf.String()
+					}
+					result.err = err
+				}
+				slots[i] <- result
+			}
+		}()
+	}
+
+	go func() {
+		for i := range keys {
+			tokens <- struct{}{}
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		for i := range keys {
+			out <- <-slots[i]
+			<-tokens
+		}
+		close(out)
+	}()
+
+	return out
+}
+
+// progress logs replay throughput at most once per interval
+type progress struct {
+	total    int
+	done     int
+	started  time.Time
+	reported time.Time
+	interval time.Duration
+}
+
+func newProgress(total int) *progress {
+	now := time.Now()
+	return &progress{
+		total:    total,
+		started:  now,
+		reported: now,
+		interval: 5 * time.Second,
+	}
+}
+
+// rate returns events per second and the estimated time left
+func (p *progress) rate(now time.Time) (float64, time.Duration) {
+	elapsed := now.Sub(p.started).Seconds()
+	if elapsed <= 0 || p.done == 0 {
+		return 0, 0
+	}
+	perSecond := float64(p.done) / elapsed
+	remaining := float64(p.total - p.done)
+	eta := time.Duration(remaining / perSecond * float64(time.Second))
+	return perSecond, eta.Round(time.Second)
+}
+
+// tick counts a processed event and reports whether it is time to log
+func (p *progress) tick(now time.Time) bool {
+	p.done++
+	if now.Sub(p.reported) < p.interval && p.done < p.total {
+		return false
+	}
+	p.reported = now
+	return true
+}
@@ -0,0 +1,97 @@
+package eventlog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func testKeys(n int) []eventKey {
+	keys := []eventKey{}
+	for i := 0; i < n; i++ {
+		keys = append(keys, eventKey{key: fmt.Sprintf("jay/2019/%020d_%d.json", i, i), sequence: int64(i)})
+	}
+	return keys
+}
+
+// objectsOf stores each key with itself as its contents
+func objectsOf(keys []eventKey) map[string]string {
+	objects := map[string]string{}
+	for _, key := range keys {
+		objects[key.key] = key.key
+	}
+	return objects
+}
+
+func TestFetchEventsKeepsOrder(t *testing.T) {
+	is := is.New(t)
+
+	// later keys are quicker to read, so they finish first
+	keys := testKeys(50)
+	keys[10].data = "from a bundle"
+	delays := map[string]time.Duration{}
+	for i, key := range keys {
+		delays[key.key] = time.Duration(len(keys)-i) * time.Millisecond
+	}
+	store := memStore{
+		objects: objectsOf(keys),
+		latency: func(key string) time.Duration {
+			return delays[key]
+		},
+		finished: make(chan string, len(keys)),
+	}
+
+	i := 0
+	for fetched := range fetchEvents(store, "bucket", keys, 8) {
+		is.NoErr(fetched.err)
+		is.Equal(fetched.key, keys[i])
+		if i == 10 {
+			is.Equal(fetched.data, "from a bundle")
+		} else {
+			is.Equal(fetched.data, keys[i].key)
+		}
+		i++
+	}
+	is.Equal(i, len(keys))
+
+	// the reads really did finish out of order
+	close(store.finished)
+	finished := []string{}
+	for key := range store.finished {
+		finished = append(finished, key)
+	}
+	is.Equal(len(finished), len(keys)-1)
+	is.True(finished[0] != keys[0].key)
+}
+
+func TestProgressRate(t *testing.T) {
+	is := is.New(t)
+
+	p := newProgress(100)
+	start := p.started
+	for i := 0; i < 20; i++ {
+		p.tick(start.Add(time.Second))
+	}
+
+	perSecond, eta := p.rate(start.Add(2 * time.Second))
+	is.Equal(perSecond, 10.0)
+	is.Equal(eta, 8*time.Second)
+}
+
+func BenchmarkFetchEvents(b *testing.B) {
+	keys := testKeys(100)
+	store := memStore{objects: objectsOf(keys), latency: func(key string) time.Duration {
+		return 2 * time.Millisecond
+	}}
+
+	for _, concurrency := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				for range fetchEvents(store, "bucket", keys, concurrency) {
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package eventlog
+
+import (
+	"bytes"
+	"database/sql"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handler reacts to an appended event. Returning an error leaves the
+// subscriber cursor on the event so it is delivered again after a backoff
+type Handler func(h EventHeader, event Event) error
+
+type subscriber struct {
+	name    string
+	handler Handler
+}
+
+// Dispatcher delivers events from the outbox to subscribers. Each
+// subscriber has its own cursor which only moves once its handler has
+// succeeded, so every event is delivered at least once
+type Dispatcher struct {
+	db          *sql.DB
+	logger      *logrus.Logger
+	subscribers []subscriber
+	batchSize   int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	lease       time.Duration
+}
+
+func NewDispatcher(db *sql.DB, logger *logrus.Logger) *Dispatcher {
+	return &Dispatcher{
+		db:         db,
+		logger:     logger,
+		batchSize:  100,
+		minBackoff: 10 * time.Second,
+		maxBackoff: time.Hour,
+		lease:      10 * time.Minute,
+	}
+}
+
+// Subscribe registers a handler, name identifies its cursor so it must
+// stay the same between restarts
+func (d *Dispatcher) Subscribe(name string, handler Handler) {
+	d.subscribers = append(d.subscribers, subscriber{name: name, handler: handler})
+}
+
+// Run delivers outstanding events every interval, it never returns
+func (d *Dispatcher) Run(interval time.Duration) {
+	for {
+		d.Dispatch()
+		time.Sleep(interval)
+	}
+}
+
+// Dispatch delivers outstanding events to every subscriber that is not
+// waiting out a backoff, then trims the events all of them have had
+func (d *Dispatcher) Dispatch() {
+	for _, sub := range d.subscribers {
+		err := d.deliver(sub)
+		if err != nil {
+			d.logger.
+				WithField("subscriber", sub.name).
+				WithError(err).
+				Error("failed to deliver events")
+		}
+	}
+	err := d.trim()
+	if err != nil {
+		d.logger.WithError(err).Error("failed to trim outbox")
+	}
+}
+
+// backoff doubles the wait after each failed attempt up to max
+func backoff(attempts int, min, max time.Duration) time.Duration {
+	wait := min
+	for i := 1; i < attempts; i++ {
+		wait = wait * 2
+		if wait >= max {
+			return max
+		}
+	}
+	return wait
+}
+
+// writeOutbox queues an event for subscribers in the transaction that
+// appends it, the event sequence is its position in the outbox
+func writeOutbox(sqlClient *sql.Tx, event Event) error {
+	h := event.header()
+
+	buf := new(bytes.Buffer)
+	_, err := buf.ReadFrom(getJSON(event))
+	if err != nil {
+		return err
+	}
+
+	_, err = sqlClient.Exec(
+		`INSERT INTO outbox (position, event_id, event_type, data)
+			VALUES ($1, $2, $3, $4)`,
+		h.EventSequence,
+		h.EventID,
+		h.EventType,
+		buf.String(),
+	)
+	return err
+}
+
+// deliver hands a batch of events to a subscriber. The cursor is claimed
+// for the lease in one short transaction so two processes never deliver
+// to the same subscriber at once, then the handler runs with no
+// transaction open and the cursor is moved on in a second one. Appends
+// only wait for the two short transactions, never for the handler
+func (d *Dispatcher) deliver(sub subscriber) error {
+	_, err := d.db.Exec(
+		`INSERT INTO subscriber_cursors (subscriber) VALUES ($1) ON CONFLICT DO NOTHING`,
+		sub.name,
+	)
+	if err != nil {
+		return err
+	}
+
+	claimed, position, attempts, batch, err := d.claim(sub)
+	if err != nil || !claimed {
+		return err
+	}
+
+	start := position
+	var failure error
+	for _, q := range batch {
+		event, err := decodeEvent(q.data)
+		if err == nil {
+			err = sub.handler(event.header(), event)
+		}
+		if err != nil {
+			failure = err
+			break
+		}
+		position = q.position
+		attempts = 0
+	}
+
+	if failure == nil {
+		return d.advance(sub, start, position, 0, nil, "")
+	}
+
+	attempts++
+	wait := backoff(attempts, d.minBackoff, d.maxBackoff)
+	retryAt := time.Now().Add(wait).UTC()
+	err = d.advance(sub, start, position, attempts, &retryAt, failure.Error())
+	if err != nil {
+		return err
+	}
+	d.logger.
+		WithField("subscriber", sub.name).
+		WithField("position", position).
+		WithField("attempts", attempts).
+		WithError(failure).
+		Errorf("handler failed, retrying in %s", wait)
+	return nil
+}
+
+// queued is an outbox event waiting to be delivered
+type queued struct {
+	position int64
+	data     string
+}
+
+// claim reads the cursor of a subscriber and the batch after it, and
+// holds the cursor until the lease runs out so other processes leave it
+// alone while the batch is delivered. Nothing is claimed while the
+// subscriber waits out a backoff or another process holds it
+func (d *Dispatcher) claim(sub subscriber) (bool, int64, int, []queued, error) {
+	batch := []queued{}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, 0, 0, batch, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var position int64
+	var attempts int
+	var waiting bool
+	err = tx.QueryRow(
+		`SELECT position, attempts, COALESCE(retry_at > $2, false)
+			FROM subscriber_cursors
+			WHERE subscriber = $1
+			FOR UPDATE SKIP LOCKED`,
+		sub.name,
+		now,
+	).Scan(&position, &attempts, &waiting)
+	if err == sql.ErrNoRows || waiting {
+		return false, 0, 0, batch, nil
+	}
+	if err != nil {
+		return false, 0, 0, batch, err
+	}
+
+	rows, err := tx.Query(
+		`SELECT position, data FROM outbox
+			WHERE position > $1
+			ORDER BY position
+			LIMIT $2`,
+		position,
+		d.batchSize,
+	)
+	if err != nil {
+		return false, 0, 0, batch, err
+	}
+	for rows.Next() {
+		q := queued{}
+		err := rows.Scan(&q.position, &q.data)
+		if err != nil {
+			rows.Close()
+			return false, 0, 0, batch, err
+		}
+		batch = append(batch, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, 0, 0, batch, err
+	}
+	if len(batch) == 0 {
+		return false, 0, 0, batch, nil
+	}
+
+	_, err = tx.Exec(
+		`UPDATE subscriber_cursors SET retry_at = $2 WHERE subscriber = $1`,
+		sub.name,
+		now.Add(d.lease),
+	)
+	if err != nil {
+		return false, 0, 0, batch, err
+	}
+	return true, position, attempts, batch, tx.Commit()
+}
+
+// advance moves a claimed cursor from start to position and releases
+// it, or holds it until retryAt after a failure. A cursor that has moved
+// since it was claimed, because the lease ran out and another process
+// delivered the batch, is left as it is
+func (d *Dispatcher) advance(sub subscriber, start, position int64, attempts int, retryAt *time.Time, lastError string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lastErr sql.NullString
+	if lastError != "" {
+		lastErr = sql.NullString{String: lastError, Valid: true}
+	}
+	_, err = tx.Exec(
+		`UPDATE subscriber_cursors
+			SET position = $3, attempts = $4, retry_at = $5, last_error = $6
+			WHERE subscriber = $1 AND position = $2`,
+		sub.name,
+		start,
+		position,
+		attempts,
+		retryAt,
+		lastErr,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// trim deletes the outbox events every subscriber has been handed. A
+// subscriber added later only receives events appended after this
+func (d *Dispatcher) trim() error {
+	_, err := d.db.Exec(
+		`DELETE FROM outbox
+			WHERE position <= (SELECT MIN(position) FROM subscriber_cursors)`,
+	)
+	return err
+}
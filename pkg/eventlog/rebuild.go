@@ -0,0 +1,340 @@
+package eventlog
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/dialect"
+)
+
+// ProjectionSchema creates the tables events are reduced into. Names are
+// unqualified so the tables are created in the first schema on the
+// search_path. It must create the same tables the migrations in pkg/db
+// leave behind
+func ProjectionSchema() string {
+	return `
+CREATE TABLE IF NOT EXISTS "posts" (
+	"id" TEXT PRIMARY KEY,
+	"year" INTEGER NOT NULL,
+	"sort_key" TEXT NOT NULL,
+	"month" INTEGER NOT NULL,
+	"data" TEXT NOT NULL,
+	"version" INTEGER NOT NULL DEFAULT 1,
+	"search_text" TEXT NOT NULL DEFAULT '',
+	"post_type" TEXT NOT NULL DEFAULT '',
+	"lat" DOUBLE PRECISION,
+	"lng" DOUBLE PRECISION
+);
+CREATE INDEX IF NOT EXISTS "idx_posts_year" ON "posts"("year");
+CREATE INDEX IF NOT EXISTS "idx_posts_month" ON "posts"("month");
+CREATE INDEX IF NOT EXISTS "idx_posts_post_type" ON "posts"("post_type", "sort_key");
+CREATE INDEX IF NOT EXISTS "idx_posts_lat_lng" ON "posts"("lat", "lng");
+ALTER TABLE "posts" ADD COLUMN IF NOT EXISTS "version" INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS "media" (
+	"id" TEXT PRIMARY KEY,
+	"year" INTEGER NOT NULL,
+	"month" INTEGER NOT NULL,
+	"day" INTEGER NOT NULL,
+	"sort_key" TEXT NOT NULL,
+	"data" TEXT NOT NULL,
+	"lat" DOUBLE PRECISION,
+	"lng" DOUBLE PRECISION
+);
+CREATE INDEX IF NOT EXISTS "idx_media_year" ON "media"("year");
+CREATE INDEX IF NOT EXISTS "idx_media_month" ON "media"("month");
+CREATE INDEX IF NOT EXISTS "idx_media_lat_lng" ON "media"("lat", "lng");
+
+CREATE TABLE IF NOT EXISTS "media_published" (
+	"id" TEXT PRIMARY KEY
+);
+`
+}
+
+// ProjectionIndexes creates the indexes only Postgres supports, it is run
+// after ProjectionSchema
+func ProjectionIndexes() string {
+	return `
+CREATE INDEX IF NOT EXISTS "idx_posts_search" ON "posts" USING gin (to_tsvector('english', "search_text"));
+`
+}
+
+// projectionTables are rebuilt and swapped together
+var projectionTables = []string{"posts", "media", "media_published"}
+
+// journalEvent is an event as recorded in the local events table
+type journalEvent struct {
+	sequence int64
+	data     string
+}
+
+// readJournal returns recorded events in replay order, starting after the
+// given sequence. Legacy events have no sequence and are only returned
+// when starting from -1. The events table is never rebuilt, so it is
+// found in public whatever schema is first on the search_path
+func readJournal(sqlClient *sql.Tx, after int64) ([]journalEvent, error) {
+	events := []journalEvent{}
+	rows, err := sqlClient.Query(
+		`SELECT COALESCE(sequence, 0), data FROM events
+			WHERE COALESCE(sequence, 0) > $1
+			ORDER BY COALESCE(sequence, 0), event_version, id`,
+		after,
+	)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := journalEvent{}
+		err := rows.Scan(&e.sequence, &e.data)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// countRows returns the number of rows in each projection table of a
+// schema
+func countRows(sqlClient *sql.Tx, schema string) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, table := range projectionTables {
+		var count int
+		err := sqlClient.QueryRow(
+			fmt.Sprintf(`SELECT COUNT(*) FROM %q.%q`, schema, table),
+		).Scan(&count)
+		if err != nil {
+			return counts, err
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
+
+// verifyCounts refuses a rebuild that lost rows, unless shrinking has
+// been allowed because a reducer change is expected to drop them
+func verifyCounts(live, rebuilt map[string]int, allowShrink bool) error {
+	for _, table := range projectionTables {
+		if rebuilt[table] < live[table] && !allowShrink {
+			return fmt.Errorf(
+				"rebuilt %s has %d rows, live table has %d",
+				table,
+				rebuilt[table],
+				live[table],
+			)
+		}
+	}
+	return nil
+}
+
+// reduceJournal applies events in order, returning the last sequence seen
+// and how many failed. Each reduce runs in a savepoint so a failure does
+// not abort the rest of the rebuild
+func (el EventLog) reduceJournal(tx *sql.Tx, events []journalEvent) (int64, int) {
+	var last int64
+	failed := 0
+	for _, e := range events {
+		event, err := decodeEvent(e.data)
+		if err == nil {
+			err = reduceInSavepoint(tx, event)
+		}
+		if err != nil {
+			failed++
+			el.logger.
+				WithField("sequence", e.sequence).
+				WithError(err).
+				Error("failed to reduce event")
+		}
+		if e.sequence > last {
+			last = e.sequence
+		}
+	}
+	return last, failed
+}
+
+func reduceInSavepoint(tx *sql.Tx, event Event) error {
+	_, err := tx.Exec(`SAVEPOINT reduce`)
+	if err != nil {
+		return err
+	}
+	err = event.reduce(tx)
+	if err != nil {
+		tx.Exec(`ROLLBACK TO SAVEPOINT reduce`)
+		return err
+	}
+	_, err = tx.Exec(`RELEASE SAVEPOINT reduce`)
+	return err
+}
+
+// Rebuild reduces every recorded event into fresh projection tables in a
+// shadow schema, then swaps them in place of the live tables. The live
+// tables stay readable until the swap, which only holds its locks for the
+// few renames at the end. New appends wait while the rebuild catches up
+// with events recorded since it started. SQLite has no schemas, so there
+// the tables are rebuilt in place
+func (el EventLog) Rebuild(allowShrink bool) error {
+	if dialect.Of(el.db) == dialect.SQLite {
+		return el.rebuildInPlace(allowShrink)
+	}
+
+	tx, err := el.db.Begin()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to start transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`DROP SCHEMA IF EXISTS rebuild CASCADE`,
+		`DROP SCHEMA IF EXISTS retired CASCADE`,
+		`CREATE SCHEMA rebuild`,
+		`SET LOCAL search_path TO rebuild`,
+		ProjectionSchema(),
+		ProjectionIndexes(),
+		`SET LOCAL search_path TO rebuild, public`,
+	} {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			el.logger.WithError(err).Error("failed to create shadow tables")
+			return err
+		}
+	}
+
+	events, err := readJournal(tx, -1)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to read events")
+		return err
+	}
+	el.logger.Infof("rebuilding projections from %d events", len(events))
+	last, failed := el.reduceJournal(tx, events)
+
+	// block appends then catch up with anything recorded meanwhile
+	_, err = tx.Exec(`SELECT last FROM public.event_sequence WHERE id = 1 FOR UPDATE`)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to lock event sequence")
+		return err
+	}
+	events, err = readJournal(tx, last)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to read events")
+		return err
+	}
+	if len(events) > 0 {
+		el.logger.Infof("catching up with %d new events", len(events))
+		_, caughtUpFailed := el.reduceJournal(tx, events)
+		failed += caughtUpFailed
+	}
+
+	live, err := countRows(tx, "public")
+	if err != nil {
+		el.logger.WithError(err).Error("failed to count live rows")
+		return err
+	}
+	rebuilt, err := countRows(tx, "rebuild")
+	if err != nil {
+		el.logger.WithError(err).Error("failed to count rebuilt rows")
+		return err
+	}
+	for _, table := range projectionTables {
+		el.logger.Infof("%s: %d live rows, %d rebuilt rows", table, live[table], rebuilt[table])
+	}
+	err = verifyCounts(live, rebuilt, allowShrink)
+	if err != nil {
+		el.logger.WithError(err).Error("rebuild failed verification")
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE SCHEMA retired`)
+	if err != nil {
+		return err
+	}
+	for _, table := range projectionTables {
+		_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE public.%q SET SCHEMA retired`, table))
+		if err != nil {
+			el.logger.WithField("table", table).WithError(err).Error("failed to retire table")
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE rebuild.%q SET SCHEMA public`, table))
+		if err != nil {
+			el.logger.WithField("table", table).WithError(err).Error("failed to swap table")
+			return err
+		}
+	}
+	for _, stmt := range []string{
+		`DROP SCHEMA retired CASCADE`,
+		`DROP SCHEMA rebuild CASCADE`,
+	} {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to commit rebuild")
+		return err
+	}
+	el.logger.Infof("rebuilt projections, %d events failed to reduce", failed)
+
+	return nil
+}
+
+// rebuildInPlace empties the projection tables and reduces every recorded
+// event into them again in a single transaction. SQLite transactions hold
+// the write lock from the start, so appends wait until it commits and
+// readers see the old tables until then
+func (el EventLog) rebuildInPlace(allowShrink bool) error {
+	tx, err := el.db.Begin()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to start transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	live, err := countRows(tx, "main")
+	if err != nil {
+		el.logger.WithError(err).Error("failed to count live rows")
+		return err
+	}
+	for _, table := range projectionTables {
+		_, err = tx.Exec(fmt.Sprintf(`DELETE FROM %q`, table))
+		if err != nil {
+			el.logger.WithField("table", table).WithError(err).Error("failed to empty table")
+			return err
+		}
+	}
+
+	events, err := readJournal(tx, -1)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to read events")
+		return err
+	}
+	el.logger.Infof("rebuilding projections from %d events", len(events))
+	_, failed := el.reduceJournal(tx, events)
+
+	rebuilt, err := countRows(tx, "main")
+	if err != nil {
+		el.logger.WithError(err).Error("failed to count rebuilt rows")
+		return err
+	}
+	for _, table := range projectionTables {
+		el.logger.Infof("%s: %d live rows, %d rebuilt rows", table, live[table], rebuilt[table])
+	}
+	err = verifyCounts(live, rebuilt, allowShrink)
+	if err != nil {
+		el.logger.WithError(err).Error("rebuild failed verification")
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to commit rebuild")
+		return err
+	}
+	el.logger.Infof("rebuilt projections, %d events failed to reduce", failed)
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package eventlog
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// execer is the part of *sql.Tx that reducers use
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// printingExecer writes every statement to out before running it, which
+// lets a dry run show what each reducer changes
+type printingExecer struct {
+	tx  *sql.Tx
+	out io.Writer
+}
+
+func (p printingExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	fmt.Fprintf(p.out, "%s\n", strings.Join(strings.Fields(query), " "))
+	for i, arg := range args {
+		fmt.Fprintf(p.out, "  $%d = %#v\n", i+1, arg)
+	}
+	return p.tx.Exec(query, args...)
+}
+
+// ReplayOptions restrict which events Replay reduces. The zero value
+// replays every event that has not been recorded locally
+type ReplayOptions struct {
+	// EventTypes only replays events of these types
+	EventTypes []string
+	// From and To bound the EventVersion, From inclusive and To exclusive
+	From time.Time
+	To   time.Time
+	// KeyPrefix only replays keys under it, relative to the log prefix,
+	// e.g. "2019" for a single year
+	KeyPrefix string
+	// Reapply reduces events again even if they have been recorded
+	Reapply bool
+	// DryRun prints each event it would apply, reapply or skip and the
+	// statements each reducer runs to Out, then rolls everything back
+	DryRun bool
+	Out    io.Writer
+}
+
+func (o ReplayOptions) filtered() bool {
+	return len(o.EventTypes) > 0 || !o.From.IsZero() || !o.To.IsZero() || o.KeyPrefix != ""
+}
+
+// matchesKey checks the key prefix. Bundled events have no key of their
+// own so the key they would have been stored under is used
+func (o ReplayOptions) matchesKey(s3KeyPrefix string, key eventKey, h EventHeader) bool {
+	if o.KeyPrefix == "" {
+		return true
+	}
+	k := key.key
+	if k == "" {
+		k = fileKey(s3KeyPrefix, h)
+	}
+	return strings.HasPrefix(k, path.Join(s3KeyPrefix, o.KeyPrefix))
+}
+
+func (o ReplayOptions) matches(h EventHeader) bool {
+	if len(o.EventTypes) > 0 {
+		found := false
+		for _, t := range o.EventTypes {
+			if t == h.EventType {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !o.From.IsZero() && h.EventVersion < o.From.In(time.Local).Format("20060102150405.0000") {
+		return false
+	}
+	if !o.To.IsZero() && h.EventVersion >= o.To.In(time.Local).Format("20060102150405.0000") {
+		return false
+	}
+	return true
+}
+
+// Replay reduces every event in the log that has not yet been recorded
+// locally, in sequence order. An empty database is first loaded from the
+// latest snapshot so only the events after it need to be read
+func (el EventLog) Replay() error {
+	return el.ReplayWith(ReplayOptions{})
+}
+
+// ReplayWith replays the events matching opts
+func (el EventLog) ReplayWith(opts ReplayOptions) error {
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	full := !opts.filtered() && !opts.DryRun
+
+	if full {
+		restored, err := el.restoreLatestSnapshot()
+		if err != nil {
+			el.logger.WithError(err).Error("failed to restore snapshot")
+			return err
+		}
+		if restored {
+			el.logger.Info("replaying events after snapshot")
+		}
+	}
+
+	// bundles of events that are all recorded already are not worth
+	// downloading unless they are to be reapplied or printed
+	var through int64
+	if !opts.Reapply && !opts.DryRun {
+		var err error
+		through, err = el.recordedThrough()
+		if err != nil {
+			el.logger.WithError(err).Error("failed to read recorded events")
+			return err
+		}
+	}
+
+	keys, err := el.logKeys(through)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to list events")
+		return err
+	}
+
+	if full && len(keys) > 0 {
+		err = el.advanceSequence(keys[len(keys)-1].sequence)
+		if err != nil {
+			el.logger.WithError(err).Error("failed to advance event sequence")
+			return err
+		}
+	}
+
+	known, err := el.knownEventIDs()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to fetch known events")
+		return err
+	}
+
+	pending := []eventKey{}
+	for _, key := range keys {
+		if key.key != "" && !opts.matchesKey(el.s3KeyPrefix, key, EventHeader{}) {
+			continue
+		}
+		// a dry run also reads the recorded events so it can say they
+		// would be skipped
+		if !known[key.eventID] || opts.Reapply || opts.DryRun {
+			pending = append(pending, key)
+		}
+	}
+
+	el.logger.Infof("found %d events, %d to replay, starting transaction", len(keys), len(pending))
+	tx, err := el.db.Begin()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to start transaction")
+		return err
+	}
+
+	var reducer execer = tx
+	if opts.DryRun {
+		reducer = printingExecer{tx: tx, out: opts.Out}
+	}
+
+	replayed := 0
+	prog := newProgress(len(pending))
+	for fetched := range fetchEvents(el.s3Client, el.s3Bucket, pending, el.fetchConcurrency) {
+		now := time.Now()
+		if prog.tick(now) {
+			perSecond, eta := prog.rate(now)
+			el.logger.Infof(
+				"replayed %d/%d events, %.1f events/sec, %s remaining",
+				prog.done,
+				prog.total,
+				perSecond,
+				eta,
+			)
+		}
+
+		key := fetched.key
+		if fetched.err != nil {
+			el.logger.
+				WithField("key", key.key).
+				WithError(fetched.err).
+				Error("failed to read event file")
+			continue
+		}
+
+		event, err := decodeEvent(fetched.data)
+		if err != nil {
+			el.logger.
+				WithField("key", key.key).
+				WithError(err).
+				Error("failed to decode event file")
+			continue
+		}
+
+		h := event.header()
+		if !opts.matches(h) || !opts.matchesKey(el.s3KeyPrefix, key, h) {
+			continue
+		}
+
+		isNew, err := recordEvent(tx, event, true)
+		if err != nil {
+			el.logger.
+				WithField("key", key.key).
+				WithError(err).
+				Error("failed to record event")
+			continue
+		}
+		if !isNew && !opts.Reapply {
+			if opts.DryRun {
+				fmt.Fprintf(opts.Out, "-- skip %d %s %s %s, already recorded\n", h.EventSequence, h.EventVersion, h.EventType, h.EventID)
+				continue
+			}
+			el.logger.
+				WithField("key", key.key).
+				Info("skipping duplicate event")
+			continue
+		}
+
+		if opts.DryRun {
+			action := "apply"
+			if !isNew {
+				action = "reapply"
+			}
+			fmt.Fprintf(opts.Out, "-- %s %d %s %s %s\n", action, h.EventSequence, h.EventVersion, h.EventType, h.EventID)
+		}
+		err = event.reduce(reducer)
+		if err != nil {
+			el.logger.
+				WithField("key", key.key).
+				WithError(err).
+				Error("failed to reduce event to db")
+			continue
+		}
+		replayed++
+	}
+
+	if opts.DryRun {
+		err = tx.Rollback()
+		if err != nil {
+			el.logger.WithError(err).Error("failed to roll back dry run")
+			return err
+		}
+		el.logger.Infof("dry run reduced %d events, nothing was committed", replayed)
+		return nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		el.logger.WithError(err).Error("failed to commit transaction")
+		return err
+	}
+	el.logger.Infof("completed replaying %d events", replayed)
+
+	return nil
+}
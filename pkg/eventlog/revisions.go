@@ -0,0 +1,86 @@
+package eventlog
+
+import (
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+// PostRevision is the state of a post after one of its events was applied.
+// Version counts from 1 and matches the post version the event produced
+type PostRevision struct {
+	Version      int
+	Sequence     int64
+	EventID      string
+	EventType    string
+	EventVersion string
+	Post         mf2.MicroFormat
+}
+
+// Time parses the time the revision was appended from its EventVersion
+func (rev PostRevision) Time() time.Time {
+	t, err := time.ParseInLocation("20060102150405.0000", rev.EventVersion, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// PostRevisions returns every revision of a post in the order the events
+// were appended. Events recorded without a post_url, which SQLite could not
+// backfill, are read and matched on the url in their data
+func (el EventLog) PostRevisions(postURL string) ([]PostRevision, error) {
+	revisions := []PostRevision{}
+
+	rows, err := el.db.Query(
+		`SELECT data FROM events
+			WHERE event_type IN ('PostCreated', 'PostUpdated')
+			AND (post_url = $1 OR post_url IS NULL)
+			ORDER BY COALESCE(sequence, 0), event_version, id`,
+		postURL,
+	)
+	if err != nil {
+		return revisions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventJSON string
+		err := rows.Scan(&eventJSON)
+		if err != nil {
+			return revisions, err
+		}
+
+		event, err := decodeEvent(eventJSON)
+		if err != nil {
+			return revisions, err
+		}
+
+		rev := PostRevision{}
+		switch ev := event.(type) {
+		case PostCreatedEvent:
+			rev.Post = ev.EventData
+		case PostUpdatedEvent:
+			rev.Post = ev.EventData
+		default:
+			continue
+		}
+		if rev.Post.GetFirstString("url") != postURL {
+			continue
+		}
+		// the projection ignores repeated creates, so does the history
+		if event.header().EventType == "PostCreated" && len(revisions) > 0 {
+			continue
+		}
+
+		h := event.header()
+		rev.Version = len(revisions) + 1
+		rev.Sequence = h.EventSequence
+		rev.EventID = h.EventID
+		rev.EventType = h.EventType
+		rev.EventVersion = h.EventVersion
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
@@ -0,0 +1,586 @@
+package eventlog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotFormatVersion is bumped whenever the layout of a Snapshot changes
+const snapshotFormatVersion = 1
+
+// snapshotTables are dumped in this order and restored in the same order.
+// events is included so a restored database still knows which events it
+// has seen and keeps its revision history
+var snapshotTables = []string{"events", "posts", "media", "media_published"}
+
+// Snapshot is a dump of the projection tables taken after every event up
+// to and including Sequence had been reduced
+type Snapshot struct {
+	FormatVersion int             `json:"formatVersion"`
+	Sequence      int64           `json:"sequence"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	Tables        []snapshotTable `json:"tables"`
+}
+
+// snapshotTable holds every row of a table, values are kept as text so the
+// dump does not need to know about each column type
+type snapshotTable struct {
+	Name    string      `json:"name"`
+	Columns []string    `json:"columns"`
+	Rows    [][]*string `json:"rows"`
+}
+
+// snapshotPrefix is kept outside of the event key prefix so snapshots are
+// never listed as events
+func snapshotPrefix(s3KeyPrefix string) string {
+	return path.Join("snapshots", s3KeyPrefix) + "/"
+}
+
+func snapshotKey(s3KeyPrefix string, seq int64) string {
+	return snapshotPrefix(s3KeyPrefix) + fmt.Sprintf("%020d.json.gz", seq)
+}
+
+// archivePrefix is where compacted events are bundled, one object per year
+func archivePrefix(s3KeyPrefix string) string {
+	return path.Join("archive", s3KeyPrefix) + "/"
+}
+
+// archiveKey names a bundle after its year and the first and last
+// sequence in it, so a replay can tell which bundles it already holds
+// without reading them
+func archiveKey(s3KeyPrefix, year string, first, last int64) string {
+	return archivePrefix(s3KeyPrefix) + fmt.Sprintf("%s_%020d_%020d.jsonl.gz", year, first, last)
+}
+
+// parseArchiveKey reads the year and sequence range of a bundle. Bundles
+// saved before keys carried a range have a range of 0, 0
+func parseArchiveKey(key string) (year string, first, last int64) {
+	parts := strings.Split(strings.TrimSuffix(path.Base(key), ".jsonl.gz"), "_")
+	if len(parts) != 3 {
+		return parts[0], 0, 0
+	}
+	first, _ = strconv.ParseInt(parts[1], 10, 64)
+	last, _ = strconv.ParseInt(parts[2], 10, 64)
+	return parts[0], first, last
+}
+
+// bundleRange returns the first and last sequence of the events in a
+// bundle, the first is 0 when any of them has no sequence
+func bundleRange(keys []eventKey) (first, last int64) {
+	for i, key := range keys {
+		if i == 0 || key.sequence < first {
+			first = key.sequence
+		}
+		if key.sequence > last {
+			last = key.sequence
+		}
+	}
+	return first, last
+}
+
+// latestKey returns the last key in lexical order, snapshot keys are zero
+// padded so this is the snapshot with the highest sequence
+func latestKey(keys []*string) string {
+	latest := ""
+	for _, key := range keys {
+		if key != nil && *key > latest {
+			latest = *key
+		}
+	}
+	return latest
+}
+
+func dumpTable(tx *sql.Tx, name string) (snapshotTable, error) {
+	table := snapshotTable{Name: name, Rows: [][]*string{}}
+
+	rows, err := tx.Query(fmt.Sprintf(`SELECT * FROM %q`, name))
+	if err != nil {
+		return table, err
+	}
+	defer rows.Close()
+
+	table.Columns, err = rows.Columns()
+	if err != nil {
+		return table, err
+	}
+
+	for rows.Next() {
+		values := make([]sql.NullString, len(table.Columns))
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		err := rows.Scan(dest...)
+		if err != nil {
+			return table, err
+		}
+
+		row := make([]*string, len(values))
+		for i, v := range values {
+			if v.Valid {
+				s := v.String
+				row[i] = &s
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table, rows.Err()
+}
+
+func restoreTable(tx *sql.Tx, table snapshotTable) error {
+	columns := []string{}
+	params := []string{}
+	for i, col := range table.Columns {
+		columns = append(columns, fmt.Sprintf("%q", col))
+		params = append(params, fmt.Sprintf("$%d", i+1))
+	}
+	query := fmt.Sprintf(
+		`INSERT INTO %q (%s) VALUES (%s) ON CONFLICT DO NOTHING`,
+		table.Name,
+		strings.Join(columns, ", "),
+		strings.Join(params, ", "),
+	)
+
+	for _, row := range table.Rows {
+		args := make([]interface{}, len(row))
+		for i, v := range row {
+			if v != nil {
+				args[i] = *v
+			}
+		}
+		_, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeSnapshot(snap Snapshot) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	err := json.NewEncoder(zw).Encode(snap)
+	if err != nil {
+		return buf, err
+	}
+	return buf, zw.Close()
+}
+
+func decodeSnapshot(r io.Reader) (Snapshot, error) {
+	snap := Snapshot{}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return snap, err
+	}
+	defer zr.Close()
+
+	err = json.NewDecoder(zr).Decode(&snap)
+	if err != nil {
+		return snap, err
+	}
+	if snap.FormatVersion != snapshotFormatVersion {
+		return snap, fmt.Errorf("unsupported snapshot format %d", snap.FormatVersion)
+	}
+	return snap, nil
+}
+
+// encodeBundle writes one event json document per line
+func encodeBundle(events []string) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	for _, event := range events {
+		_, err := io.WriteString(zw, strings.TrimSpace(event)+"\n")
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, zw.Close()
+}
+
+func decodeBundle(r io.Reader) ([]string, error) {
+	events := []string{}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return events, err
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		events = append(events, line)
+	}
+	return events, scanner.Err()
+}
+
+// bundleKeys turns the events in a bundle into replayable keys, the event
+// json is carried along so it does not need to be fetched again
+func bundleKeys(events []string) ([]eventKey, error) {
+	keys := []eventKey{}
+	for _, eventJSON := range events {
+		h := EventHeader{}
+		err := json.Unmarshal([]byte(eventJSON), &h)
+		if err != nil {
+			return keys, err
+		}
+		key := eventKey{
+			sequence: h.EventSequence,
+			eventID:  h.EventID,
+			data:     eventJSON,
+		}
+		if h.EventSequence == 0 {
+			key.version = h.EventVersion
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Snapshot dumps the projection tables to s3. The dump is read in a
+// repeatable read transaction so it matches the last sequence it contains.
+// Nothing is written if no events have been appended since the latest
+// snapshot
+func (el EventLog) Snapshot() error {
+	tx, err := el.db.BeginTx(
+		context.Background(),
+		&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true},
+	)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to start transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	snap := Snapshot{
+		FormatVersion: snapshotFormatVersion,
+		CreatedAt:     time.Now(),
+	}
+	err = tx.QueryRow(`SELECT COALESCE(MAX(sequence), 0) FROM events`).Scan(&snap.Sequence)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to read latest sequence")
+		return err
+	}
+
+	existing, err := el.s3Client.ListKeys(el.s3Bucket, snapshotPrefix(el.s3KeyPrefix))
+	if err != nil {
+		el.logger.WithError(err).Error("failed to list snapshots")
+		return err
+	}
+	key := snapshotKey(el.s3KeyPrefix, snap.Sequence)
+	if latestKey(existing) >= key {
+		el.logger.Infof("snapshot at sequence %d is up to date", snap.Sequence)
+		return nil
+	}
+
+	for _, name := range snapshotTables {
+		table, err := dumpTable(tx, name)
+		if err != nil {
+			el.logger.WithField("table", name).WithError(err).Error("failed to dump table")
+			return err
+		}
+		snap.Tables = append(snap.Tables, table)
+	}
+
+	buf, err := encodeSnapshot(snap)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to encode snapshot")
+		return err
+	}
+
+	err = el.s3Client.WriteObject(key, el.s3Bucket, buf, true)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to save snapshot")
+		return err
+	}
+	el.logger.Infof("saved snapshot at sequence %d", snap.Sequence)
+
+	return nil
+}
+
+// RunSnapshots takes a snapshot every interval, it never returns. A
+// failed snapshot is logged and tried again at the next tick
+func (el EventLog) RunSnapshots(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := el.Snapshot()
+		if err != nil {
+			el.logger.WithError(err).Error("scheduled snapshot failed")
+		}
+	}
+}
+
+// restoreLatestSnapshot loads the most recent snapshot into an empty
+// database. It returns false when any of the snapshot tables already holds
+// rows or there is no snapshot to restore
+func (el EventLog) restoreLatestSnapshot() (bool, error) {
+	for _, name := range snapshotTables {
+		var count int
+		err := el.db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %q`, name)).Scan(&count)
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return false, nil
+		}
+	}
+
+	keys, err := el.s3Client.ListKeys(el.s3Bucket, snapshotPrefix(el.s3KeyPrefix))
+	if err != nil {
+		return false, err
+	}
+	key := latestKey(keys)
+	if key == "" {
+		return false, nil
+	}
+
+	buf, err := el.s3Client.ReadObject(key, el.s3Bucket)
+	if err != nil {
+		return false, err
+	}
+	snap, err := decodeSnapshot(buf)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := el.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	for _, table := range snap.Tables {
+		err = restoreTable(tx, table)
+		if err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("restoring %s: %v", table.Name, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	el.logger.Infof("restored snapshot at sequence %d", snap.Sequence)
+	return true, el.advanceSequence(snap.Sequence)
+}
+
+// archivedKeys reads the compacted event bundles, skipping those that
+// only hold events up to and including the through sequence
+func (el EventLog) archivedKeys(through int64) ([]eventKey, error) {
+	out := []eventKey{}
+	bundles, err := el.s3Client.ListKeys(el.s3Bucket, archivePrefix(el.s3KeyPrefix))
+	if err != nil {
+		return out, err
+	}
+	for _, bundle := range bundles {
+		if bundle == nil {
+			continue
+		}
+		if _, first, last := parseArchiveKey(*bundle); first > 0 && last <= through {
+			continue
+		}
+		buf, err := el.s3Client.ReadObject(*bundle, el.s3Bucket)
+		if err != nil {
+			return out, err
+		}
+		events, err := decodeBundle(buf)
+		if err != nil {
+			return out, fmt.Errorf("reading bundle %s: %v", *bundle, err)
+		}
+		keys, err := bundleKeys(events)
+		if err != nil {
+			return out, fmt.Errorf("reading bundle %s: %v", *bundle, err)
+		}
+		out = append(out, keys...)
+	}
+	return out, nil
+}
+
+// confirmBundle reads a saved bundle back and checks it holds the events
+// that were written to it, so no event is deleted on the strength of a
+// write that did not land
+func (el EventLog) confirmBundle(bundle string, events []string) error {
+	buf, err := el.s3Client.ReadObject(bundle, el.s3Bucket)
+	if err != nil {
+		return err
+	}
+	saved, err := decodeBundle(buf)
+	if err != nil {
+		return err
+	}
+	if len(saved) != len(events) {
+		return fmt.Errorf("bundle %s holds %d events, %d were written", bundle, len(saved), len(events))
+	}
+	for i := range saved {
+		if strings.TrimSpace(saved[i]) != strings.TrimSpace(events[i]) {
+			return fmt.Errorf("event %d of bundle %s is not the one written", i, bundle)
+		}
+	}
+	return nil
+}
+
+// Compact moves every event covered by the latest snapshot out of its own
+// s3 object and into a bundle for the year it was written. Bundles are
+// saved and read back before any event object or older bundle is
+// deleted, so an interrupted compaction can simply be run again
+func (el EventLog) Compact() error {
+	snapshots, err := el.s3Client.ListKeys(el.s3Bucket, snapshotPrefix(el.s3KeyPrefix))
+	if err != nil {
+		el.logger.WithError(err).Error("failed to list snapshots")
+		return err
+	}
+	latest := latestKey(snapshots)
+	if latest == "" {
+		return fmt.Errorf("no snapshot found, nothing can be compacted")
+	}
+	buf, err := el.s3Client.ReadObject(latest, el.s3Bucket)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to read snapshot")
+		return err
+	}
+	snap, err := decodeSnapshot(buf)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to decode snapshot")
+		return err
+	}
+
+	allKeys, err := el.s3Client.ListKeys(el.s3Bucket, el.s3KeyPrefix)
+	if err != nil {
+		el.logger.WithError(err).Error("failed to list event keys")
+		return err
+	}
+	byYear := map[string][]eventKey{}
+	for _, key := range sortEventKeys(allKeys) {
+		if key.sequence > snap.Sequence {
+			continue
+		}
+		year := path.Base(path.Dir(key.key))
+		byYear[year] = append(byYear[year], key)
+	}
+
+	existing, err := el.s3Client.ListKeys(el.s3Bucket, archivePrefix(el.s3KeyPrefix))
+	if err != nil {
+		el.logger.WithError(err).Error("failed to list bundles")
+		return err
+	}
+	bundlesByYear := map[string][]string{}
+	for _, key := range existing {
+		if key != nil {
+			year, _, _ := parseArchiveKey(*key)
+			bundlesByYear[year] = append(bundlesByYear[year], *key)
+		}
+	}
+
+	for year, keys := range byYear {
+		events := []string{}
+		for _, bundle := range bundlesByYear[year] {
+			buf, err := el.s3Client.ReadObject(bundle, el.s3Bucket)
+			if err != nil {
+				el.logger.WithField("bundle", bundle).WithError(err).Error("failed to read bundle")
+				return err
+			}
+			bundled, err := decodeBundle(buf)
+			if err != nil {
+				el.logger.WithField("bundle", bundle).WithError(err).Error("failed to decode bundle")
+				return err
+			}
+			events = append(events, bundled...)
+		}
+
+		for _, key := range keys {
+			buf, err := el.s3Client.ReadObject(key.key, el.s3Bucket)
+			if err != nil {
+				el.logger.WithField("key", key.key).WithError(err).Error("failed to read event")
+				return err
+			}
+			events = append(events, buf.String())
+		}
+
+		merged, err := mergeBundle(events)
+		if err != nil {
+			el.logger.WithField("year", year).WithError(err).Error("failed to merge events")
+			return err
+		}
+		mergedKeys, err := bundleKeys(merged)
+		if err != nil {
+			el.logger.WithField("year", year).WithError(err).Error("failed to merge events")
+			return err
+		}
+		first, last := bundleRange(mergedKeys)
+		bundle := archiveKey(el.s3KeyPrefix, year, first, last)
+		out, err := encodeBundle(merged)
+		if err != nil {
+			el.logger.WithField("bundle", bundle).WithError(err).Error("failed to encode bundle")
+			return err
+		}
+		err = el.s3Client.WriteObject(bundle, el.s3Bucket, out, true)
+		if err != nil {
+			el.logger.WithField("bundle", bundle).WithError(err).Error("failed to save bundle")
+			return err
+		}
+		err = el.confirmBundle(bundle, merged)
+		if err != nil {
+			el.logger.WithField("bundle", bundle).WithError(err).Error("saved bundle did not read back, nothing was deleted")
+			return err
+		}
+
+		for _, key := range keys {
+			err = el.s3Client.DeleteObject(key.key, el.s3Bucket)
+			if err != nil {
+				el.logger.WithField("key", key.key).WithError(err).Error("failed to delete event")
+				return err
+			}
+		}
+		// the events of older bundles for the year are all in the new one
+		for _, old := range bundlesByYear[year] {
+			if old == bundle {
+				continue
+			}
+			err = el.s3Client.DeleteObject(old, el.s3Bucket)
+			if err != nil {
+				el.logger.WithField("bundle", old).WithError(err).Error("failed to delete bundle")
+				return err
+			}
+		}
+		el.logger.Infof("compacted %d events into %s", len(keys), bundle)
+	}
+
+	return nil
+}
+
+// mergeBundle drops repeated events and puts the rest in replay order
+func mergeBundle(events []string) ([]string, error) {
+	keys, err := bundleKeys(events)
+	if err != nil {
+		return []string{}, err
+	}
+
+	seen := map[string]bool{}
+	unique := []eventKey{}
+	for _, key := range keys {
+		if seen[key.eventID] {
+			continue
+		}
+		seen[key.eventID] = true
+		unique = append(unique, key)
+	}
+	sortKeys(unique)
+
+	out := []string{}
+	for _, key := range unique {
+		out = append(out, key.data)
+	}
+	return out, nil
+}
@@ -0,0 +1,108 @@
+package eventlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MediaBaseURL is prepended to the file key of uploaded media to make its
+// url. It is read from MEDIA_BASE_URL
+var MediaBaseURL = mediaBaseURL()
+
+func mediaBaseURL() string {
+	u := os.Getenv("MEDIA_BASE_URL")
+	if u == "" {
+		return "https://media.funabashi.co.uk/"
+	}
+	return strings.TrimRight(u, "/") + "/"
+}
+
+// currentSchemaVersions is the schema version new events of each type are
+// written with, types that are not listed are at version 1
+var currentSchemaVersions = map[string]int{
+	"MediaUploaded": 2,
+}
+
+func currentSchemaVersion(eventType string) int {
+	v, ok := currentSchemaVersions[eventType]
+	if !ok {
+		return 1
+	}
+	return v
+}
+
+// upcaster rewrites an event document from one schema version to the next
+type upcaster func(doc map[string]interface{}) error
+
+// upcasters are keyed by event type and the schema version they upgrade
+// from. Every version below the current one needs an upcaster
+var upcasters = map[string]map[int]upcaster{
+	"MediaUploaded": {
+		1: mediaUploadedV1ToV2,
+	},
+}
+
+// mediaUploadedV1ToV2 replaces the url. Version 1 events stored the url of
+// the micropub media endpoint the file was uploaded through, version 2
+// stores the url the file is served from
+func mediaUploadedV1ToV2(doc map[string]interface{}) error {
+	data, ok := doc["eventData"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("eventData is not an object")
+	}
+	fileKey, ok := data["file_key"].(string)
+	if !ok {
+		return fmt.Errorf("eventData has no file_key")
+	}
+	data["url"] = MediaBaseURL + fileKey
+	return nil
+}
+
+// upcast brings an event document up to the current schema version of
+// its type. Documents without a schemaVersion are version 1
+func upcast(eventJSON string, h EventHeader) (string, error) {
+	version := h.SchemaVersion
+	if version == 0 {
+		version = 1
+	}
+	current := currentSchemaVersion(h.EventType)
+	if version == current {
+		return eventJSON, nil
+	}
+	if version > current {
+		return eventJSON, fmt.Errorf(
+			"%s schema version %d is newer than %d",
+			h.EventType,
+			version,
+			current,
+		)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(eventJSON))
+	dec.UseNumber()
+	doc := map[string]interface{}{}
+	err := dec.Decode(&doc)
+	if err != nil {
+		return eventJSON, err
+	}
+
+	for ; version < current; version++ {
+		up, ok := upcasters[h.EventType][version]
+		if !ok {
+			return eventJSON, fmt.Errorf("no upcaster for %s schema version %d", h.EventType, version)
+		}
+		err := up(doc)
+		if err != nil {
+			return eventJSON, fmt.Errorf("upcasting %s from schema version %d: %v", h.EventType, version, err)
+		}
+	}
+	doc["schemaVersion"] = current
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return eventJSON, err
+	}
+	return string(out), nil
+}
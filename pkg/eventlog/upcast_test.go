@@ -0,0 +1,98 @@
+package eventlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// TestDecodeFixtures decodes events as they were written by each
+// version of the app. Add a fixture here whenever an event changes shape
+func TestDecodeFixtures(t *testing.T) {
+	var tests = []struct {
+		fixture     string
+		expected    string
+		expectedURL string
+		expectErr   bool
+	}{
+		{
+			fixture:     "media_uploaded_legacy.json",
+			expected:    "eventlog.MediaUploadedEvent",
+			expectedURL: MediaBaseURL + "2018/0b6c4b59.jpg",
+		},
+		{
+			fixture:     "media_uploaded_v1.json",
+			expected:    "eventlog.MediaUploadedEvent",
+			expectedURL: MediaBaseURL + "2019/6f0e8a7e.jpg",
+		},
+		{
+			fixture:     "media_uploaded_v2.json",
+			expected:    "eventlog.MediaUploadedEvent",
+			expectedURL: "https://media.funabashi.co.uk/2020/c3a1f2d4.jpg",
+		},
+		{
+			fixture:   "media_uploaded_future.json",
+			expected:  "eventlog.nullEvent",
+			expectErr: true,
+		},
+		{
+			fixture:  "media_deleted_legacy.json",
+			expected: "eventlog.MediaDeletedEvent",
+		},
+		{
+			fixture:  "post_created_legacy.json",
+			expected: "eventlog.PostCreatedEvent",
+		},
+		{
+			fixture:  "post_updated_v1.json",
+			expected: "eventlog.PostUpdatedEvent",
+		},
+		{
+			fixture:  "unknown_type.json",
+			expected: "eventlog.nullEvent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			is := is.New(t)
+
+			data, err := ioutil.ReadFile(filepath.Join("test_data", tt.fixture))
+			is.NoErr(err)
+
+			event, err := decodeEvent(string(data))
+
+			is.Equal(err != nil, tt.expectErr)
+			is.Equal(fmt.Sprintf("%T", event), tt.expected)
+			if tt.expectErr {
+				return
+			}
+			h := event.header()
+			is.True(h.EventID != "")
+			if media, ok := event.(MediaUploadedEvent); ok {
+				is.Equal(h.SchemaVersion, currentSchemaVersion("MediaUploaded"))
+				is.Equal(media.EventData.URL, tt.expectedURL)
+			}
+		})
+	}
+}
+
+func TestUpcastKeepsNumbers(t *testing.T) {
+	is := is.New(t)
+
+	event, err := decodeEvent(`{"eventID": "a", "eventType": "MediaUploaded", "eventVersion": "20190302181500.0021", "eventSequence": 9007199254740993, "eventData": {"file_key": "2019/a.jpg", "lat": 51.123456789012}}`)
+
+	is.NoErr(err)
+	is.Equal(event.header().EventSequence, int64(9007199254740993))
+	is.Equal(event.(MediaUploadedEvent).EventData.Lat, 51.123456789012)
+}
+
+func TestNewEventsUseCurrentSchemaVersion(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(newEventHeader("MediaUploaded").SchemaVersion, 2)
+	is.Equal(newEventHeader("PostCreated").SchemaVersion, 1)
+}
@@ -0,0 +1,148 @@
+package fsck
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+)
+
+// Kinds of finding
+const (
+	MediaMissingFile  = "media-missing-file"
+	FileWithoutMedia  = "file-without-media"
+	PhotoWithoutMedia = "photo-without-media"
+	MediaNotInLog     = "media-not-in-log"
+	LogMediaMissing   = "log-media-missing"
+	PostNotInLog      = "post-not-in-log"
+	LogPostMissing    = "log-post-missing"
+)
+
+// Inputs is everything fsck compares, gathered by Load
+type Inputs struct {
+	// Media maps media row urls to their file keys
+	Media map[string]string
+	// PostPhotos maps post row urls to the photos they reference
+	PostPhotos map[string][]string
+	// Files holds every key in the media bucket
+	Files map[string]bool
+	// LogMedia holds the media urls that are live after replaying the log
+	LogMedia map[string]bool
+	// LogPosts holds the post urls the log projects into posts
+	LogPosts map[string]bool
+}
+
+// Finding is one inconsistency. Repair is the event that would fix it, or
+// nil when it can only be fixed by hand or by rebuilding the projections
+type Finding struct {
+	Kind    string
+	Subject string
+	Detail  string
+	Repair  eventlog.Event
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s %s: %s", f.Kind, f.Subject, f.Detail)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Check cross checks the event log, the projections and the media bucket
+// in both directions
+func Check(in Inputs) []Finding {
+	findings := []Finding{}
+
+	mediaURLs := map[string]bool{}
+	fileKeys := map[string]bool{}
+	for url, fileKey := range in.Media {
+		mediaURLs[url] = true
+		fileKeys[fileKey] = true
+	}
+
+	for _, url := range sortedKeys(mediaURLs) {
+		fileKey := in.Media[url]
+		hasFile := in.Files[fileKey]
+		if !hasFile {
+			findings = append(findings, Finding{
+				Kind:    MediaMissingFile,
+				Subject: url,
+				Detail:  fmt.Sprintf("file %s is not in the media bucket", fileKey),
+				Repair:  eventlog.NewMediaDeleted(url),
+			})
+		}
+		if !in.LogMedia[url] {
+			f := Finding{
+				Kind:    MediaNotInLog,
+				Subject: url,
+				Detail:  "media row was not produced by the event log",
+			}
+			// one delete is enough when the file is missing as well
+			if hasFile {
+				f.Repair = eventlog.NewMediaDeleted(url)
+			}
+			findings = append(findings, f)
+		}
+	}
+
+	for _, fileKey := range sortedKeys(in.Files) {
+		if !fileKeys[fileKey] {
+			findings = append(findings, Finding{
+				Kind:    FileWithoutMedia,
+				Subject: fileKey,
+				Detail:  "file has no media row",
+			})
+		}
+	}
+
+	for _, url := range sortedKeys(in.LogMedia) {
+		if !mediaURLs[url] {
+			findings = append(findings, Finding{
+				Kind:    LogMediaMissing,
+				Subject: url,
+				Detail:  "event log has this media but there is no media row, rebuild the projections",
+			})
+		}
+	}
+
+	postURLs := map[string]bool{}
+	for url := range in.PostPhotos {
+		postURLs[url] = true
+	}
+	for _, url := range sortedKeys(postURLs) {
+		for _, photo := range in.PostPhotos[url] {
+			if !mediaURLs[photo] {
+				findings = append(findings, Finding{
+					Kind:    PhotoWithoutMedia,
+					Subject: url,
+					Detail:  fmt.Sprintf("photo %s has no media row", photo),
+				})
+			}
+		}
+		if !in.LogPosts[url] {
+			findings = append(findings, Finding{
+				Kind:    PostNotInLog,
+				Subject: url,
+				Detail:  "post row was not produced by the event log",
+			})
+		}
+	}
+
+	for _, url := range sortedKeys(in.LogPosts) {
+		if !postURLs[url] {
+			findings = append(findings, Finding{
+				Kind:    LogPostMissing,
+				Subject: url,
+				Detail:  "event log has this post but there is no post row, rebuild the projections",
+			})
+		}
+	}
+
+	return findings
+}
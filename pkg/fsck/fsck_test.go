@@ -0,0 +1,105 @@
+package fsck_test
+
+import (
+	"testing"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/fsck"
+	"github.com/matryer/is"
+)
+
+func TestCheck(t *testing.T) {
+	var tests = []struct {
+		name     string
+		in       fsck.Inputs
+		expected []string
+		repairs  []string
+	}{
+		{
+			name: "consistent",
+			in: fsck.Inputs{
+				Media:      map[string]string{"https://m/2019/a.jpg": "2019/a.jpg"},
+				PostPhotos: map[string][]string{"https://p/1": {"https://m/2019/a.jpg"}},
+				Files:      map[string]bool{"2019/a.jpg": true},
+				LogMedia:   map[string]bool{"https://m/2019/a.jpg": true},
+				LogPosts:   map[string]bool{"https://p/1": true},
+			},
+			expected: []string{},
+			repairs:  []string{},
+		},
+		{
+			name: "media row whose file is missing",
+			in: fsck.Inputs{
+				Media:    map[string]string{"https://m/2019/a.jpg": "2019/a.jpg"},
+				LogMedia: map[string]bool{"https://m/2019/a.jpg": true},
+			},
+			expected: []string{fsck.MediaMissingFile + " https://m/2019/a.jpg"},
+			repairs:  []string{"MediaDeleted"},
+		},
+		{
+			name: "file without media row",
+			in: fsck.Inputs{
+				Files: map[string]bool{"2019/a.jpg": true},
+			},
+			expected: []string{fsck.FileWithoutMedia + " 2019/a.jpg"},
+			repairs:  []string{},
+		},
+		{
+			name: "post photo without media row",
+			in: fsck.Inputs{
+				PostPhotos: map[string][]string{"https://p/1": {"https://m/2019/a.jpg"}},
+				LogPosts:   map[string]bool{"https://p/1": true},
+			},
+			expected: []string{fsck.PhotoWithoutMedia + " https://p/1"},
+			repairs:  []string{},
+		},
+		{
+			name: "projections and log disagree",
+			in: fsck.Inputs{
+				Media:      map[string]string{"https://m/2019/a.jpg": "2019/a.jpg"},
+				PostPhotos: map[string][]string{"https://p/1": {}},
+				Files:      map[string]bool{"2019/a.jpg": true, "2019/b.jpg": true},
+				LogMedia:   map[string]bool{"https://m/2019/b.jpg": true},
+				LogPosts:   map[string]bool{"https://p/2": true},
+			},
+			expected: []string{
+				fsck.MediaNotInLog + " https://m/2019/a.jpg",
+				fsck.FileWithoutMedia + " 2019/b.jpg",
+				fsck.LogMediaMissing + " https://m/2019/b.jpg",
+				fsck.PostNotInLog + " https://p/1",
+				fsck.LogPostMissing + " https://p/2",
+			},
+			repairs: []string{"MediaDeleted"},
+		},
+		{
+			name: "media row in neither the log nor the bucket is deleted once",
+			in: fsck.Inputs{
+				Media: map[string]string{"https://m/2019/a.jpg": "2019/a.jpg"},
+			},
+			expected: []string{
+				fsck.MediaMissingFile + " https://m/2019/a.jpg",
+				fsck.MediaNotInLog + " https://m/2019/a.jpg",
+			},
+			repairs: []string{"MediaDeleted"},
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			findings := fsck.Check(tt.in)
+
+			result := []string{}
+			repairs := []string{}
+			for _, f := range findings {
+				result = append(result, f.Kind+" "+f.Subject)
+				if f.Repair != nil {
+					repairs = append(repairs, f.Repair.(eventlog.MediaDeletedEvent).EventType)
+				}
+			}
+			is.Equal(result, tt.expected)
+			is.Equal(repairs, tt.repairs)
+		})
+	}
+}
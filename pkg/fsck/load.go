@@ -0,0 +1,124 @@
+package fsck
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+// Load gathers the inputs for Check from the database, including the
+// local events journal, and the media bucket
+func Load(db *sql.DB, files eventlog.EventListReader, mediaBucket string) (Inputs, error) {
+	in := Inputs{
+		Media:      map[string]string{},
+		PostPhotos: map[string][]string{},
+		Files:      map[string]bool{},
+		LogMedia:   map[string]bool{},
+		LogPosts:   map[string]bool{},
+	}
+
+	keys, err := files.ListKeys(mediaBucket, "")
+	if err != nil {
+		return in, err
+	}
+	for _, key := range keys {
+		if key != nil {
+			in.Files[*key] = true
+		}
+	}
+
+	err = loadMedia(db, in)
+	if err != nil {
+		return in, err
+	}
+	err = loadPosts(db, in)
+	if err != nil {
+		return in, err
+	}
+	err = loadLog(db, in)
+	return in, err
+}
+
+func loadMedia(db *sql.DB, in Inputs) error {
+	rows, err := db.Query(`SELECT id, data FROM media`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, data string
+		err := rows.Scan(&id, &data)
+		if err != nil {
+			return err
+		}
+		media := mf2.MediaMetadata{}
+		err = json.Unmarshal([]byte(data), &media)
+		if err != nil {
+			return err
+		}
+		in.Media[id] = media.FileKey
+	}
+	return rows.Err()
+}
+
+func loadPosts(db *sql.DB, in Inputs) error {
+	rows, err := db.Query(`SELECT id, data FROM posts`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, data string
+		err := rows.Scan(&id, &data)
+		if err != nil {
+			return err
+		}
+		mf, err := mf2.MfFromJson(data)
+		if err != nil {
+			return err
+		}
+		in.PostPhotos[id] = mf.GetStringSlice("photo")
+	}
+	return rows.Err()
+}
+
+// loadLog folds the journal into the media and posts it should project
+func loadLog(db *sql.DB, in Inputs) error {
+	rows, err := db.Query(
+		`SELECT data FROM events
+			WHERE event_type IN ('MediaUploaded', 'MediaDeleted', 'PostCreated')
+			ORDER BY COALESCE(sequence, 0), event_version, id`,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		err := rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+
+		event, err := eventlog.DecodeEvent(data)
+		if err != nil {
+			return err
+		}
+		switch ev := event.(type) {
+		case eventlog.MediaUploadedEvent:
+			in.LogMedia[ev.EventData.URL] = true
+		case eventlog.MediaDeletedEvent:
+			delete(in.LogMedia, ev.EventData)
+		case eventlog.PostCreatedEvent:
+			if ev.EventData.IsPost() {
+				in.LogPosts[ev.EventData.GetFirstString("url")] = true
+			}
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,114 @@
+// Package geo finds posts and media by where they were made
+package geo
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidBox is returned for a bounding box that is not four numbers
+// within the range of latitudes and longitudes
+var ErrInvalidBox = errors.New("invalid bounding box")
+
+// earthRadius is the mean radius of the earth in kilometres
+const earthRadius = 6371.0
+
+// World is the box around everywhere
+var World = Box{South: -90, West: -180, North: 90, East: 180}
+
+// Box is a bounding box in degrees. West is greater than East for a box
+// that crosses the antimeridian
+type Box struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+// ParseBox parses a box written west,south,east,north, the order of
+// GeoJSON and the Leaflet toBBoxString
+func ParseBox(s string) (Box, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Box{}, ErrInvalidBox
+	}
+	n := [4]float64{}
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(f) {
+			return Box{}, ErrInvalidBox
+		}
+		n[i] = f
+	}
+
+	b := Box{West: n[0], South: n[1], East: n[2], North: n[3]}
+	if b.South < -90 || b.North > 90 || b.South > b.North ||
+		b.West < -180 || b.West > 180 || b.East < -180 || b.East > 180 {
+		return Box{}, ErrInvalidBox
+	}
+	return b, nil
+}
+
+// CrossesAntimeridian reports whether b runs east over longitude 180
+func (b Box) CrossesAntimeridian() bool {
+	return b.West > b.East
+}
+
+// Contains reports whether the point lat, lng is inside b
+func (b Box) Contains(lat, lng float64) bool {
+	if lat < b.South || lat > b.North {
+		return false
+	}
+	if b.CrossesAntimeridian() {
+		return lng >= b.West || lng <= b.East
+	}
+	return lng >= b.West && lng <= b.East
+}
+
+// BoxAround is the smallest box holding every point within radius
+// kilometres of lat, lng
+func BoxAround(lat, lng, radius float64) Box {
+	dLat := radius / earthRadius * 180 / math.Pi
+	b := Box{
+		South: math.Max(lat-dLat, -90),
+		North: math.Min(lat+dLat, 90),
+		West:  -180,
+		East:  180,
+	}
+	// a circle over a pole holds every longitude
+	if b.South == -90 || b.North == 90 {
+		return b
+	}
+
+	dLng := dLat / math.Cos(lat*math.Pi/180)
+	if dLng >= 180 {
+		return b
+	}
+	b.West = wrapLng(lng - dLng)
+	b.East = wrapLng(lng + dLng)
+	return b
+}
+
+func wrapLng(lng float64) float64 {
+	if lng < -180 {
+		return lng + 360
+	}
+	if lng > 180 {
+		return lng - 360
+	}
+	return lng
+}
+
+// Distance is the great circle distance in kilometres between two points
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	rLat1 := lat1 * math.Pi / 180
+	rLat2 := lat2 * math.Pi / 180
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rLat1)*math.Cos(rLat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,95 @@
+package geo_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+	"github.com/matryer/is"
+)
+
+func TestParseBox(t *testing.T) {
+	var tests = []struct {
+		name        string
+		in          string
+		expected    geo.Box
+		expectedErr error
+	}{
+		{
+			name:     "leeds",
+			in:       "-1.6,53.7,-1.4,53.9",
+			expected: geo.Box{West: -1.6, South: 53.7, East: -1.4, North: 53.9},
+		},
+		{
+			name:     "across the antimeridian",
+			in:       "170, -20, -170, -10",
+			expected: geo.Box{West: 170, South: -20, East: -170, North: -10},
+		},
+		{
+			name:        "three numbers",
+			in:          "1,2,3",
+			expectedErr: geo.ErrInvalidBox,
+		},
+		{
+			name:        "south of north",
+			in:          "0,10,1,5",
+			expectedErr: geo.ErrInvalidBox,
+		},
+		{
+			name:        "out of range",
+			in:          "0,0,181,1",
+			expectedErr: geo.ErrInvalidBox,
+		},
+		{
+			name:        "not a number",
+			in:          "a,0,1,1",
+			expectedErr: geo.ErrInvalidBox,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			result, err := geo.ParseBox(tt.in)
+			is.Equal(err, tt.expectedErr)
+			is.Equal(result, tt.expected)
+		})
+	}
+}
+
+func TestBoxContains(t *testing.T) {
+	is := is.New(t)
+	leeds := geo.Box{West: -1.6, South: 53.7, East: -1.4, North: 53.9}
+	is.True(leeds.Contains(53.8, -1.55))
+	is.True(!leeds.Contains(51.5, -0.12))
+
+	fiji := geo.Box{West: 170, South: -20, East: -170, North: -10}
+	is.True(fiji.Contains(-17, 179))
+	is.True(fiji.Contains(-17, -179))
+	is.True(!fiji.Contains(-17, 0))
+}
+
+func TestBoxAround(t *testing.T) {
+	is := is.New(t)
+
+	b := geo.BoxAround(53.8, -1.55, 10)
+	is.True(b.Contains(53.8, -1.55))
+	is.True(b.Contains(53.85, -1.6))
+	is.True(!b.Contains(53.8, -1.2))
+
+	across := geo.BoxAround(0, 179.99, 10)
+	is.True(across.CrossesAntimeridian())
+	is.True(across.Contains(0, -179.99))
+
+	pole := geo.BoxAround(89.99, 0, 10)
+	is.Equal(pole.West, -180.0)
+	is.Equal(pole.East, 180.0)
+}
+
+func TestDistance(t *testing.T) {
+	is := is.New(t)
+	// leeds to london
+	d := geo.Distance(53.8008, -1.5491, 51.5074, -0.1278)
+	is.True(math.Abs(d-272) < 2)
+	is.Equal(geo.Distance(53.8, -1.55, 53.8, -1.55), 0.0)
+}
@@ -0,0 +1,42 @@
+package geo
+
+// FeatureCollection is a GeoJSON feature collection. Truncated is set
+// when more features matched than the collection holds
+type FeatureCollection struct {
+	Type      string    `json:"type"`
+	Features  []Feature `json:"features"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// Feature is a GeoJSON feature located at a point
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   Point                  `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Point is a GeoJSON point, its coordinates are longitude then latitude
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// NewFeatureCollection is an empty feature collection
+func NewFeatureCollection() FeatureCollection {
+	return FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []Feature{},
+	}
+}
+
+// Add adds a feature at lat, lng with properties
+func (fc *FeatureCollection) Add(lat, lng float64, properties map[string]interface{}) {
+	fc.Features = append(fc.Features, Feature{
+		Type: "Feature",
+		Geometry: Point{
+			Type:        "Point",
+			Coordinates: [2]float64{lng, lat},
+		},
+		Properties: properties,
+	})
+}
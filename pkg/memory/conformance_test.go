@@ -0,0 +1,239 @@
+package memory_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+	"github.com/j4y_funabashi/inari-micropub/pkg/memory"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+// selecta is the app port plus the media lists micropub reads
+type selecta interface {
+	app.Selecta
+	SelectMediaMonth(year, month string) (mf2.MediaList, error)
+	SelectMediaList(limit int, cursor string) (mf2.MediaList, error)
+}
+
+// forEachSelecta appends the same events to memory and to a fresh SQLite
+// file, then runs the test against the Selecta of each, so the memory
+// ports are held to the behaviour of the real ones
+func forEachSelecta(t *testing.T, events []eventlog.Event, test func(t *testing.T, selecta selecta)) {
+	t.Run("memory", func(t *testing.T) {
+		store := memory.NewStore()
+		el := memory.NewEventLog(store)
+		for _, event := range events {
+			if err := el.Append(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		test(t, memory.NewSelecta(store))
+	})
+
+	t.Run("db", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "inari")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		sqlDB, err := db.Open("sqlite://" + filepath.Join(dir, "inari.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sqlDB.Close()
+
+		logger := logrus.New()
+		logger.Out = ioutil.Discard
+		el := eventlog.NewEventLog("events", "bucket", nil, sqlDB, logger)
+		for _, event := range events {
+			if err := el.Append(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		test(t, db.NewSelecta(sqlDB))
+	})
+}
+
+func TestSelectaPostLists(t *testing.T) {
+	events := []eventlog.Event{}
+	for i, uid := range []string{"a", "b", "c", "d", "e"} {
+		published := time.Date(2019, 6, i+1, 8, 0, 0, 0, time.UTC).Format(time.RFC3339)
+		properties := map[string][]interface{}{"content": {"hello " + uid}}
+		if i%2 == 0 {
+			properties["photo"] = []interface{}{"https://media.example.com/" + uid + ".jpg"}
+		}
+		events = append(events, eventlog.NewPostCreated(newPost(uid, published, properties)))
+	}
+	// a like is neither a photo nor a note
+	events = append(events, eventlog.NewPostCreated(newPost("f", "2019-06-06T08:00:00Z", map[string][]interface{}{
+		"like-of": {"https://example.com/"},
+	})))
+
+	forEachSelecta(t, events, func(t *testing.T, selecta selecta) {
+		is := is.New(t)
+
+		first, err := selecta.SelectPostList(2, "")
+		is.NoErr(err)
+		is.Equal(uids(first), []string{"f", "e"})
+		is.Equal(first.Paging.Before, "")
+
+		second, err := selecta.SelectPostList(2, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(uids(second), []string{"d", "c"})
+
+		back, err := selecta.SelectPostList(2, second.Paging.Before)
+		is.NoErr(err)
+		is.Equal(uids(back), []string{"f", "e"})
+		is.Equal(back.Paging.Before, "")
+
+		_, err = selecta.SelectPostList(2, "nonsense")
+		is.Equal(err, app.ErrInvalidCursor)
+
+		photos, err := selecta.SelectPostListByType("photo", 2, "")
+		is.NoErr(err)
+		is.Equal(uids(photos), []string{"e", "c"})
+		photos, err = selecta.SelectPostListByType("photo", 2, photos.Paging.After)
+		is.NoErr(err)
+		is.Equal(uids(photos), []string{"a"})
+		is.Equal(photos.Paging.After, "")
+
+		notes, err := selecta.SelectPostListByType("note", 10, "")
+		is.NoErr(err)
+		is.Equal(uids(notes), []string{"d", "b"})
+
+		found, err := selecta.SearchPosts("hello", 10, "")
+		is.NoErr(err)
+		is.Equal(uids(found), []string{"e", "d", "c", "b", "a"})
+	})
+}
+
+func TestSelectaArchive(t *testing.T) {
+	events := []eventlog.Event{
+		eventlog.NewPostCreated(newPost("a", "2018-12-31T09:00:00Z", nil)),
+		// new year's day in Paris, still 2018 in UTC
+		eventlog.NewPostCreated(newPost("b", "2019-01-01T00:30:00+01:00", nil)),
+		eventlog.NewPostCreated(newPost("c", "2019-01-01T09:00:00Z", nil)),
+		eventlog.NewPostCreated(newPost("d", "2019-06-01T09:00:00Z", nil)),
+	}
+
+	forEachSelecta(t, events, func(t *testing.T, selecta selecta) {
+		is := is.New(t)
+
+		years, err := selecta.SelectYearList()
+		is.NoErr(err)
+		is.Equal(years, []app.ArchiveLinkYear{{Year: "2019", Count: 2}, {Year: "2018", Count: 2}})
+
+		months, err := selecta.SelectMonthList("2019")
+		is.NoErr(err)
+		is.Equal(months, []app.ArchiveLinkMonth{{Month: "06", Count: 1}, {Month: "01", Count: 1}})
+
+		december, err := selecta.SelectArchivePostList("2018", "12", 10, "")
+		is.NoErr(err)
+		is.Equal(uids(december), []string{"b", "a"})
+	})
+}
+
+func TestSelectaPostVersions(t *testing.T) {
+	post := newPost("a", "2019-06-01T08:00:00Z", map[string][]interface{}{"content": {"first"}})
+	created := eventlog.NewPostCreated(post)
+	updated := newPost("a", "2019-06-01T08:00:00Z", map[string][]interface{}{"content": {"second"}})
+	events := []eventlog.Event{created, eventlog.NewPostUpdated(updated, 1)}
+
+	forEachSelecta(t, events, func(t *testing.T, selecta selecta) {
+		is := is.New(t)
+
+		version, err := selecta.SelectPostVersion("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(version, 2)
+		mf, err := selecta.SelectPostByURL("https://example.com/p/a")
+		is.NoErr(err)
+		is.Equal(mf.GetFirstString("content"), "second")
+
+		version, err = selecta.SelectPostVersion("https://example.com/p/missing")
+		is.NoErr(err)
+		is.Equal(version, 0)
+		mf, err = selecta.SelectPostByURL("https://example.com/p/missing")
+		is.NoErr(err)
+		is.Equal(len(mf.Properties), 0)
+	})
+}
+
+func TestSelectaMedia(t *testing.T) {
+	taken := time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)
+	leeds := eventlog.NewMediaUploaded(newMedia("leeds", taken, 53.8, -1.55))
+	otley := eventlog.NewMediaUploaded(newMedia("otley", taken.Add(time.Hour), 53.905, -1.69))
+	london := eventlog.NewMediaUploaded(newMedia("london", taken.AddDate(0, 1, 0), 51.5, -0.12))
+	events := []eventlog.Event{
+		leeds,
+		otley,
+		london,
+		eventlog.NewPostCreated(newPost("a", "2019-06-02T08:00:00Z", map[string][]interface{}{
+			"photo":    {leeds.EventData.URL},
+			"location": {"geo:53.8,-1.55"},
+		})),
+		eventlog.NewMediaDeleted(london.EventData.URL),
+	}
+
+	forEachSelecta(t, events, func(t *testing.T, selecta selecta) {
+		is := is.New(t)
+
+		is.Equal(selecta.SelectMediaYearList(), []app.Year{{Year: "2019", Count: 2, PublishedCount: 1}})
+		days, err := selecta.SelectMediaDayList("2019", "06")
+		is.NoErr(err)
+		is.Equal(days, []app.Day{{Day: "1", Count: 2, PublishedCount: 1}})
+		months, err := selecta.SelectMediaMonthList("2019")
+		is.NoErr(err)
+		is.Equal(months, []app.Month{{Month: "6", Count: 2, PublishedCount: 1}})
+
+		media, err := selecta.SelectMediaDay("2019", "06", "01")
+		is.NoErr(err)
+		is.Equal(len(media), 2)
+		is.Equal(media[0].URL, otley.EventData.URL)
+		is.True(!media[0].IsPublished)
+		is.True(media[1].IsPublished)
+		unpadded, err := selecta.SelectMediaDay("2019", "6", "1")
+		is.NoErr(err)
+		is.Equal(len(unpadded), 2)
+		june, err := selecta.SelectMediaMonth("2019", "06")
+		is.NoErr(err)
+		is.Equal(len(june.Items), 2)
+		is.Equal(june.Items[0].Uid, "otley")
+		is.True(june.Items[1].IsPublished)
+
+		first, err := selecta.SelectMediaList(1, "")
+		is.NoErr(err)
+		is.Equal(len(first.Items), 1)
+		is.Equal(first.Items[0].Uid, "otley")
+		rest, err := selecta.SelectMediaList(1, first.Paging.After)
+		is.NoErr(err)
+		is.Equal(len(rest.Items), 1)
+		is.Equal(rest.Items[0].Uid, "leeds")
+		is.Equal(rest.Paging.After, "")
+
+		yorkshire := geo.Box{West: -2, South: 53.5, East: -1, North: 54}
+		inBox, err := selecta.SelectMediaInBox(yorkshire, 10)
+		is.NoErr(err)
+		is.Equal(len(inBox.Items), 2)
+		near, err := selecta.SelectMediaNear(53.8, -1.55, 5, 10)
+		is.NoErr(err)
+		is.Equal(len(near.Items), 1)
+		is.True(near.Items[0].IsPublished)
+
+		posts, err := selecta.SelectPostsInBox(yorkshire, 10)
+		is.NoErr(err)
+		is.Equal(uids(posts), []string{"a"})
+		posts, err = selecta.SelectPostsNear(51.5, -0.12, 5, 10)
+		is.NoErr(err)
+		is.Equal(len(posts.Items), 0)
+	})
+}
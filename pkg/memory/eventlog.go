@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+// ErrNoDateTime is returned for uploaded media without a date_time, which
+// the media projection is keyed by
+var ErrNoDateTime = errors.New("media has no date_time")
+
+// EventLog appends events to a Store and reduces them into its
+// projection, rejecting the same duplicates and version conflicts the
+// real event log does
+type EventLog struct {
+	store *Store
+}
+
+func NewEventLog(store *Store) EventLog {
+	return EventLog{
+		store: store,
+	}
+}
+
+// Append assigns event the next sequence, applies it to the projection
+// and records it. Nothing is recorded when the event is rejected
+func (el EventLog) Append(event eventlog.Event) error {
+	el.store.mu.Lock()
+	defer el.store.mu.Unlock()
+
+	seq := int64(len(el.store.events) + 1)
+	var id string
+	switch e := event.(type) {
+	case eventlog.PostCreatedEvent:
+		id = e.EventID
+		e.EventSequence = seq
+		event = e
+	case eventlog.PostUpdatedEvent:
+		id = e.EventID
+		e.EventSequence = seq
+		event = e
+	case eventlog.MediaUploadedEvent:
+		id = e.EventID
+		e.EventSequence = seq
+		event = e
+	case eventlog.MediaDeletedEvent:
+		id = e.EventID
+		e.EventSequence = seq
+		event = e
+	default:
+		return fmt.Errorf("cannot append event of type %T", event)
+	}
+	if el.store.eventIDs[id] {
+		return eventlog.ErrDuplicateEvent
+	}
+	if e, ok := event.(eventlog.PostUpdatedEvent); ok && e.ExpectedVersion != 0 {
+		p, ok := el.store.posts[e.EventData.GetFirstString("url")]
+		if !ok || p.version != e.ExpectedVersion {
+			return eventlog.ErrVersionConflict
+		}
+	}
+
+	err := el.reduce(event)
+	if err != nil {
+		return err
+	}
+	el.store.eventIDs[id] = true
+	el.store.events = append(el.store.events, event)
+	return nil
+}
+
+// reduce applies an event to the projection as the real reducers do
+func (el EventLog) reduce(event eventlog.Event) error {
+	s := el.store
+	switch e := event.(type) {
+	case eventlog.PostCreatedEvent:
+		if !e.EventData.IsPost() {
+			return nil
+		}
+		published, err := time.Parse(time.RFC3339, e.EventData.ToView().Published)
+		if err != nil {
+			return err
+		}
+
+		url := e.EventData.GetFirstString("url")
+		if _, ok := s.posts[url]; ok {
+			return nil
+		}
+		p := &post{
+			year:    published.UTC().Format("2006"),
+			month:   published.UTC().Format("01"),
+			sortKey: eventlog.PostSortKey(published, e.EventData.GetFirstString("uid")),
+			version: 1,
+		}
+		p.set(e.EventData)
+		s.posts[url] = p
+
+		for _, photoURL := range e.EventData.GetStringSlice("photo") {
+			s.published[photoURL] = true
+		}
+
+	case eventlog.PostUpdatedEvent:
+		p, ok := s.posts[e.EventData.GetFirstString("url")]
+		if !ok {
+			return nil
+		}
+		p.set(e.EventData)
+		p.version++
+		if e.ExpectedVersion != 0 {
+			p.version = e.ExpectedVersion + 1
+		}
+
+	case eventlog.MediaUploadedEvent:
+		if e.EventData.DateTime == nil {
+			return ErrNoDateTime
+		}
+		if _, ok := s.media[e.EventData.URL]; ok {
+			return nil
+		}
+		taken := *e.EventData.DateTime
+		s.media[e.EventData.URL] = &media{
+			data:    e.EventData,
+			year:    taken.Format("2006"),
+			month:   taken.Format("01"),
+			day:     taken.Format("02"),
+			sortKey: taken.Format(time.RFC3339) + e.EventData.Uid,
+			// media without gps data is recorded at 0, 0
+			located: e.EventData.Lat != 0 || e.EventData.Lng != 0,
+		}
+
+	case eventlog.MediaDeletedEvent:
+		delete(s.media, e.EventData)
+	}
+	return nil
+}
+
+// set replaces the document of a post and the columns derived from it
+func (p *post) set(mf mf2.MicroFormat) {
+	p.mf = mf
+	p.searchText = mf.SearchText()
+	p.postType = mf.PostType()
+	p.lat, p.lng, p.located = mf.LatLng()
+}
+
+// PostRevisions returns every revision of a post in the order the events
+// were appended
+func (el EventLog) PostRevisions(postURL string) ([]eventlog.PostRevision, error) {
+	el.store.mu.RLock()
+	defer el.store.mu.RUnlock()
+
+	revisions := []eventlog.PostRevision{}
+	for _, event := range el.store.events {
+		rev := eventlog.PostRevision{}
+		var h eventlog.EventHeader
+		switch e := event.(type) {
+		case eventlog.PostCreatedEvent:
+			rev.Post = e.EventData
+			h = e.EventHeader
+		case eventlog.PostUpdatedEvent:
+			rev.Post = e.EventData
+			h = e.EventHeader
+		default:
+			continue
+		}
+		if rev.Post.GetFirstString("url") != postURL {
+			continue
+		}
+		// the projection ignores repeated creates, so does the history
+		if h.EventType == "PostCreated" && len(revisions) > 0 {
+			continue
+		}
+
+		rev.Version = len(revisions) + 1
+		rev.Sequence = h.EventSequence
+		rev.EventID = h.EventID
+		rev.EventType = h.EventType
+		rev.EventVersion = h.EventVersion
+		revisions = append(revisions, rev)
+	}
+	return revisions, nil
+}
@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"strings"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+)
+
+// venueRadius is how far, in kilometres, venues are looked for around a
+// point
+const venueRadius = 1.0
+
+// Geocoder looks locations up in a fixed gazetteer instead of a geocoding
+// api. Places are found by address and venues by where they are
+type Geocoder struct {
+	places []app.Location
+	venues []app.Location
+}
+
+func NewGeocoder(places, venues []app.Location) Geocoder {
+	return Geocoder{
+		places: places,
+		venues: venues,
+	}
+}
+
+// Lookup returns the places whose name, locality, region or country
+// contains address
+func (g Geocoder) Lookup(address string) []app.Location {
+	return matching(g.places, address)
+}
+
+// LookupLatLng returns the place nearest lat, lng
+func (g Geocoder) LookupLatLng(lat, lng float64) []app.Location {
+	locations := []app.Location{}
+	nearest := -1.0
+	for _, place := range g.places {
+		d := geo.Distance(lat, lng, place.Lat, place.Lng)
+		if nearest < 0 || d < nearest {
+			locations = []app.Location{place}
+			nearest = d
+		}
+	}
+	return locations
+}
+
+// LookupVenues returns the venues near lat, lng
+func (g Geocoder) LookupVenues(lat, lng float64) []app.Location {
+	locations := []app.Location{}
+	for _, venue := range g.venues {
+		if geo.Distance(lat, lng, venue.Lat, venue.Lng) <= venueRadius {
+			locations = append(locations, venue)
+		}
+	}
+	return locations
+}
+
+// SearchVenues returns the venues near lat, lng that match query
+func (g Geocoder) SearchVenues(query string, lat, lng float64) []app.Location {
+	return matching(g.LookupVenues(lat, lng), query)
+}
+
+// matching returns the locations whose name or address contains query,
+// ignoring case
+func matching(locations []app.Location, query string) []app.Location {
+	out := []app.Location{}
+	query = strings.ToLower(strings.TrimSpace(query))
+	for _, l := range locations {
+		text := strings.ToLower(strings.Join([]string{l.Name, l.Locality, l.Region, l.Country}, " "))
+		if strings.Contains(text, query) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+// Package memory holds the projection, event log and sessions in memory
+// so the app can run without a database, s3 or a geocoding api. Each
+// port behaves like the real one it stands in for, which makes it fit
+// for fast tests of everything above the ports
+package memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+// Store is the in memory database a Selecta reads from and an EventLog
+// writes to
+type Store struct {
+	mu        sync.RWMutex
+	posts     map[string]*post
+	media     map[string]*media
+	published map[string]bool
+	events    []eventlog.Event
+	eventIDs  map[string]bool
+}
+
+// NewStore is an empty store
+func NewStore() *Store {
+	return &Store{
+		posts:     map[string]*post{},
+		media:     map[string]*media{},
+		published: map[string]bool{},
+		eventIDs:  map[string]bool{},
+	}
+}
+
+// post is a row of the posts projection
+type post struct {
+	mf         mf2.MicroFormat
+	year       string
+	month      string
+	sortKey    string
+	version    int
+	searchText string
+	postType   string
+	lat        float64
+	lng        float64
+	located    bool
+}
+
+// media is a row of the media projection
+type media struct {
+	data    mf2.MediaMetadata
+	year    string
+	month   string
+	day     string
+	sortKey string
+	located bool
+}
+
+// sortedPosts returns the posts keep holds for, newest first
+func (s *Store) sortedPosts(keep func(p *post) bool) []*post {
+	out := []*post{}
+	for _, p := range s.posts {
+		if keep(p) {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].sortKey > out[j].sortKey
+	})
+	return out
+}
+
+// sortedMedia returns the media keep holds for, newest first
+func (s *Store) sortedMedia(keep func(m *media) bool) []*media {
+	out := []*media{}
+	for _, m := range s.media {
+		if keep(m) {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].sortKey > out[j].sortKey
+	})
+	return out
+}
@@ -0,0 +1,200 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+	"github.com/j4y_funabashi/inari-micropub/pkg/memory"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+	"github.com/j4y_funabashi/inari-micropub/pkg/micropub"
+	"github.com/matryer/is"
+)
+
+var _ app.Selecta = memory.Selecta{}
+var _ app.EventLog = memory.EventLog{}
+var _ app.SessionStore = memory.SessionStore{}
+var _ app.Geocoder = memory.Geocoder{}
+var _ eventlog.ObjectStore = memory.ObjectStore{}
+var _ micropub.Selecta = memory.Selecta{}
+var _ micropub.EventLog = memory.EventLog{}
+
+func newPost(uid, published string, properties map[string][]interface{}) mf2.MicroFormat {
+	props := map[string][]interface{}{
+		"uid":       {uid},
+		"url":       {"https://example.com/p/" + uid},
+		"published": {published},
+	}
+	for k, v := range properties {
+		props[k] = v
+	}
+	return mf2.MicroFormat{Type: []string{"h-entry"}, Properties: props}
+}
+
+func newMedia(uid string, taken time.Time, lat, lng float64) mf2.MediaMetadata {
+	return mf2.MediaMetadata{
+		Uid:      uid,
+		FileKey:  "2019/" + uid + ".jpg",
+		MimeType: "image/jpeg",
+		DateTime: &taken,
+		Lat:      lat,
+		Lng:      lng,
+	}
+}
+
+func uids(pl mf2.PostList) []string {
+	out := []string{}
+	for _, item := range pl.Items {
+		out = append(out, item.GetFirstString("uid"))
+	}
+	return out
+}
+
+func TestPagingBothWays(t *testing.T) {
+	is := is.New(t)
+	store := memory.NewStore()
+	el := memory.NewEventLog(store)
+	for i, uid := range []string{"a", "b", "c", "d", "e"} {
+		published := time.Date(2019, 6, i+1, 8, 0, 0, 0, time.UTC).Format(time.RFC3339)
+		is.NoErr(el.Append(eventlog.NewPostCreated(newPost(uid, published, nil))))
+	}
+	selecta := memory.NewSelecta(store)
+
+	first, err := selecta.SelectPostList(2, "")
+	is.NoErr(err)
+	is.Equal(uids(first), []string{"e", "d"})
+	is.Equal(first.Paging.Before, "")
+
+	second, err := selecta.SelectPostList(2, first.Paging.After)
+	is.NoErr(err)
+	is.Equal(uids(second), []string{"c", "b"})
+
+	last, err := selecta.SelectPostList(2, second.Paging.After)
+	is.NoErr(err)
+	is.Equal(uids(last), []string{"a"})
+	is.Equal(last.Paging.After, "")
+
+	back, err := selecta.SelectPostList(2, last.Paging.Before)
+	is.NoErr(err)
+	is.Equal(uids(back), []string{"c", "b"})
+
+	top, err := selecta.SelectPostList(2, back.Paging.Before)
+	is.NoErr(err)
+	is.Equal(uids(top), []string{"e", "d"})
+	is.Equal(top.Paging.Before, "")
+
+	_, err = selecta.SelectPostList(2, "nonsense")
+	is.Equal(err, app.ErrInvalidCursor)
+}
+
+func TestPublishedFlags(t *testing.T) {
+	is := is.New(t)
+	store := memory.NewStore()
+	el := memory.NewEventLog(store)
+	taken := time.Date(2019, 6, 1, 8, 0, 0, 0, time.UTC)
+	leeds := eventlog.NewMediaUploaded(newMedia("leeds", taken, 53.8, -1.55))
+	otley := eventlog.NewMediaUploaded(newMedia("otley", taken.Add(time.Hour), 53.905, -1.69))
+	is.NoErr(el.Append(leeds))
+	is.NoErr(el.Append(otley))
+	is.NoErr(el.Append(eventlog.NewPostCreated(newPost("a", "2019-06-02T08:00:00Z", map[string][]interface{}{
+		"photo": {leeds.EventData.URL},
+	}))))
+	selecta := memory.NewSelecta(store)
+
+	years := selecta.SelectMediaYearList()
+	is.Equal(years, []app.Year{{Year: "2019", Count: 2, PublishedCount: 1}})
+	days, err := selecta.SelectMediaDayList("2019", "06")
+	is.NoErr(err)
+	is.Equal(days, []app.Day{{Day: "1", Count: 2, PublishedCount: 1}})
+
+	media, err := selecta.SelectMediaDay("2019", "06", "01")
+	is.NoErr(err)
+	is.Equal(len(media), 2)
+	is.Equal(media[0].URL, otley.EventData.URL)
+	is.True(!media[0].IsPublished)
+	is.True(media[1].IsPublished)
+
+	near, err := selecta.SelectMediaNear(53.8, -1.55, 5, 10)
+	is.NoErr(err)
+	is.Equal(len(near.Items), 1)
+	is.True(near.Items[0].IsPublished)
+
+	yorkshire, err := selecta.SelectMediaInBox(geo.Box{West: -2, South: 53.5, East: -1, North: 54}, 10)
+	is.NoErr(err)
+	is.Equal(len(yorkshire.Items), 2)
+
+	is.NoErr(el.Append(eventlog.NewMediaDeleted(otley.EventData.URL)))
+	list, err := selecta.SelectMediaList(10, "")
+	is.NoErr(err)
+	is.Equal(len(list.Items), 1)
+}
+
+func TestAppendRejects(t *testing.T) {
+	is := is.New(t)
+	store := memory.NewStore()
+	el := memory.NewEventLog(store)
+	selecta := memory.NewSelecta(store)
+
+	post := newPost("a", "2019-06-01T08:00:00Z", map[string][]interface{}{"content": {"first"}})
+	created := eventlog.NewPostCreated(post)
+	is.NoErr(el.Append(created))
+	is.Equal(el.Append(created), eventlog.ErrDuplicateEvent)
+
+	post.Properties["content"] = []interface{}{"second"}
+	is.NoErr(el.Append(eventlog.NewPostUpdated(post, 1)))
+	is.Equal(el.Append(eventlog.NewPostUpdated(post, 1)), eventlog.ErrVersionConflict)
+
+	version, err := selecta.SelectPostVersion("https://example.com/p/a")
+	is.NoErr(err)
+	is.Equal(version, 2)
+
+	found, err := selecta.SearchPosts("SECOND", 10, "")
+	is.NoErr(err)
+	is.Equal(uids(found), []string{"a"})
+
+	revisions, err := el.PostRevisions("https://example.com/p/a")
+	is.NoErr(err)
+	is.Equal(len(revisions), 2)
+	is.Equal(revisions[1].Version, 2)
+	is.Equal(revisions[1].Post.GetFirstString("content"), "second")
+}
+
+func TestSessionStore(t *testing.T) {
+	is := is.New(t)
+	ss := memory.NewSessionStore()
+
+	sess, err := ss.Create()
+	is.NoErr(err)
+	sess.Content = "hello"
+	is.NoErr(ss.Save(sess))
+	is.NoErr(ss.Save(app.SessionData{Token: "never-created"}))
+
+	fetched, err := ss.Fetch(sess.Token)
+	is.NoErr(err)
+	is.Equal(fetched.Content, "hello")
+	missing, err := ss.Fetch("never-created")
+	is.NoErr(err)
+	is.Equal(missing.Token, "")
+}
+
+func TestGeocoder(t *testing.T) {
+	is := is.New(t)
+	g := memory.NewGeocoder(
+		[]app.Location{
+			{Name: "Leeds", Lat: 53.8, Lng: -1.55, Country: "United Kingdom"},
+			{Name: "London", Lat: 51.5, Lng: -0.12, Country: "United Kingdom"},
+		},
+		[]app.Location{
+			{Name: "Kirkgate Market", Lat: 53.797, Lng: -1.54, Locality: "Leeds"},
+		},
+	)
+
+	is.Equal(len(g.Lookup("united")), 2)
+	is.Equal(g.LookupLatLng(53.9, -1.6)[0].Name, "Leeds")
+	is.Equal(len(g.LookupVenues(53.8, -1.55)), 1)
+	is.Equal(len(g.LookupVenues(51.5, -0.12)), 0)
+	is.Equal(len(g.SearchVenues("market", 53.8, -1.55)), 1)
+	is.Equal(len(g.SearchVenues("station", 53.8, -1.55)), 0)
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNoSuchKey is returned when reading an object that was never written
+var ErrNoSuchKey = errors.New("no such key")
+
+// ObjectStore keeps objects in memory in place of s3. Buckets are
+// ignored, every key shares one namespace
+type ObjectStore struct {
+	mu      *sync.RWMutex
+	objects map[string]string
+}
+
+func NewObjectStore() ObjectStore {
+	return ObjectStore{
+		mu:      &sync.RWMutex{},
+		objects: map[string]string{},
+	}
+}
+
+// ListKeys returns the keys starting with prefix in order
+func (o ObjectStore) ListKeys(bucket, prefix string) ([]*string, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	keys := []string{}
+	for key := range o.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	out := []*string{}
+	for i := range keys {
+		out = append(out, &keys[i])
+	}
+	return out, nil
+}
+
+func (o ObjectStore) ReadObject(key, bucket string) (*bytes.Buffer, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	body, ok := o.objects[key]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	return bytes.NewBufferString(body), nil
+}
+
+func (o ObjectStore) WriteObject(key, bucket string, body io.Reader, isPrivate bool) error {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.objects[key] = string(b)
+	return nil
+}
+
+func (o ObjectStore) DeleteObject(key, bucket string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.objects, key)
+	return nil
+}
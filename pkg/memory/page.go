@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"github.com/j4y_funabashi/inari-micropub/pkg/cursor"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+// page returns the indexes of the keys, which are sort keys newest first,
+// on the page a cursor selects and the cursors either side of it, the
+// same pages the database selects
+func page(keys []string, limit int, c string) ([]int, mf2.ListPaging, error) {
+	paging := mf2.ListPaging{}
+	cur, err := cursor.Parse(c)
+	if err != nil {
+		return nil, paging, err
+	}
+
+	// pages before a cursor are read oldest first so the items nearest
+	// the cursor are the ones kept
+	candidates := []int{}
+	for i := range keys {
+		switch {
+		case cur.IsZero():
+			candidates = append(candidates, i)
+		case cur.Before && keys[len(keys)-1-i] > cur.Key:
+			candidates = append(candidates, len(keys)-1-i)
+		case !cur.Before && keys[i] < cur.Key:
+			candidates = append(candidates, i)
+		}
+	}
+
+	n := len(candidates)
+	more := n > limit
+	if more {
+		n = limit
+	}
+	if n <= 0 {
+		return []int{}, paging, nil
+	}
+	candidates = candidates[:n]
+
+	first, last := keys[candidates[0]], keys[candidates[n-1]]
+	older, newer := more, !cur.IsZero()
+	if cur.Before {
+		first, last = last, first
+		older, newer = newer, more
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+	}
+	if older {
+		paging.After = cursor.After(last).String()
+	}
+	if newer {
+		paging.Before = cursor.Before(first).String()
+	}
+	return candidates, paging, nil
+}
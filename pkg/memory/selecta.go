@@ -0,0 +1,409 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
+)
+
+// Selecta reads the projection in a Store
+type Selecta struct {
+	store *Store
+}
+
+func NewSelecta(store *Store) Selecta {
+	return Selecta{
+		store: store,
+	}
+}
+
+func (s Selecta) SelectMediaYearList() []app.Year {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	list := []app.Year{}
+	for _, c := range s.countMedia(func(m *media) string { return m.year }, func(m *media) bool { return true }) {
+		list = append(list, app.Year{Year: c.key, Count: c.count, PublishedCount: c.published})
+	}
+	return list
+}
+
+func (s Selecta) SelectMediaMonthList(year string) ([]app.Month, error) {
+	list := []app.Month{}
+	if year == "" {
+		return list, errors.New("cant select month list with empty year")
+	}
+
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	for _, c := range s.countMedia(func(m *media) string { return m.month }, func(m *media) bool { return m.year == year }) {
+		list = append(list, app.Month{Month: unpadDate(c.key), Count: c.count, PublishedCount: c.published})
+	}
+	return list, nil
+}
+
+func (s Selecta) SelectMediaDayList(year, month string) ([]app.Day, error) {
+	list := []app.Day{}
+	if year == "" {
+		return list, errors.New("cant select day list with empty year")
+	}
+	if month == "" {
+		return list, errors.New("cant select day list with empty month")
+	}
+
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	for _, c := range s.countMedia(func(m *media) string { return m.day }, func(m *media) bool { return m.year == year && m.month == padDate(month) }) {
+		list = append(list, app.Day{Day: unpadDate(c.key), Count: c.count, PublishedCount: c.published})
+	}
+	return list, nil
+}
+
+// padDate zero pads a month or day as media is keyed, so 6 and 06 find
+// the same media as they do in the integer columns of the media table
+func padDate(n string) string {
+	i, err := strconv.Atoi(n)
+	if err != nil {
+		return n
+	}
+	return fmt.Sprintf("%02d", i)
+}
+
+// unpadDate returns a month or day as the media table does, without
+// padding
+func unpadDate(n string) string {
+	i, err := strconv.Atoi(n)
+	if err != nil {
+		return n
+	}
+	return strconv.Itoa(i)
+}
+
+// groupCount is how many items are grouped under key, and for media
+// how many of them are published
+type groupCount struct {
+	key       string
+	count     int
+	published int
+}
+
+// countMedia groups the media keep holds for by group, latest group
+// first
+func (s Selecta) countMedia(group func(m *media) string, keep func(m *media) bool) []groupCount {
+	counts := map[string]*groupCount{}
+	for _, m := range s.store.media {
+		if !keep(m) {
+			continue
+		}
+		key := group(m)
+		c, ok := counts[key]
+		if !ok {
+			c = &groupCount{key: key}
+			counts[key] = c
+		}
+		c.count++
+		if s.store.published[m.data.URL] {
+			c.published++
+		}
+	}
+
+	list := []groupCount{}
+	for _, c := range counts {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key > list[j].key
+	})
+	return list
+}
+
+func (s Selecta) SelectMediaDay(year, month, day string) ([]app.Media, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	mediaList := []app.Media{}
+	for _, m := range s.store.sortedMedia(func(m *media) bool {
+		return m.year == year && m.month == padDate(month) && m.day == padDate(day)
+	}) {
+		item := toAppMedia(m.data)
+		item.IsPublished = s.store.published[m.data.URL]
+		mediaList = append(mediaList, item)
+	}
+	return mediaList, nil
+}
+
+func (s Selecta) SelectMediaByURL(url string) (app.Media, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	m, ok := s.store.media[url]
+	if !ok {
+		return app.Media{}, nil
+	}
+	return toAppMedia(m.data), nil
+}
+
+func toAppMedia(data mf2.MediaMetadata) app.Media {
+	return app.Media{
+		URL:      data.URL,
+		MimeType: data.MimeType,
+		DateTime: data.DateTime,
+		Lat:      data.Lat,
+		Lng:      data.Lng,
+	}
+}
+
+// SelectMediaMonth returns every media item taken in a month, newest
+// first
+func (s Selecta) SelectMediaMonth(year, month string) (mf2.MediaList, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	mediaList := mf2.MediaList{
+		Paging: &mf2.ListPaging{},
+	}
+	for _, m := range s.store.sortedMedia(func(m *media) bool {
+		return m.year == year && m.month == padDate(month)
+	}) {
+		mediaList.Add(s.mediaMetadata(m))
+	}
+	return mediaList, nil
+}
+
+// SelectMediaList returns a page of every media item, newest first
+func (s Selecta) SelectMediaList(limit int, cursor string) (mf2.MediaList, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	mediaList := mf2.MediaList{
+		Paging: &mf2.ListPaging{},
+	}
+	all := s.store.sortedMedia(func(m *media) bool { return true })
+	keys := []string{}
+	for _, m := range all {
+		keys = append(keys, m.sortKey)
+	}
+	indexes, paging, err := page(keys, limit, cursor)
+	if err != nil {
+		return mediaList, err
+	}
+	for _, i := range indexes {
+		mediaList.Add(s.mediaMetadata(all[i]))
+	}
+	mediaList.Paging = &paging
+	return mediaList, nil
+}
+
+// mediaMetadata is the data of m flagged with whether it is published
+func (s Selecta) mediaMetadata(m *media) mf2.MediaMetadata {
+	data := m.data
+	data.IsPublished = s.store.published[data.URL]
+	return data
+}
+
+func (s Selecta) SelectPostList(limit int, cursor string) (mf2.PostList, error) {
+	return s.selectPostList(func(p *post) bool { return true }, limit, cursor)
+}
+
+// SelectPostListByType is SelectPostList for the posts of postType only,
+// every post when postType is empty
+func (s Selecta) SelectPostListByType(postType string, limit int, cursor string) (mf2.PostList, error) {
+	return s.selectPostList(func(p *post) bool {
+		return postType == "" || p.postType == postType
+	}, limit, cursor)
+}
+
+// SelectArchivePostList is SelectPostList for the posts published in year,
+// and in month of that year when month is not empty
+func (s Selecta) SelectArchivePostList(year, month string, limit int, cursor string) (mf2.PostList, error) {
+	return s.selectPostList(func(p *post) bool {
+		return (year == "" || p.year == year) && (month == "" || p.month == month)
+	}, limit, cursor)
+}
+
+// SearchPosts returns the posts whose search text contains every word of
+// query, ignoring case, as the database does under SQLite
+func (s Selecta) SearchPosts(query string, limit int, cursor string) (mf2.PostList, error) {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return mf2.PostList{Paging: &mf2.ListPaging{}}, nil
+	}
+	return s.selectPostList(func(p *post) bool {
+		text := strings.ToLower(p.searchText)
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				return false
+			}
+		}
+		return true
+	}, limit, cursor)
+}
+
+func (s Selecta) selectPostList(keep func(p *post) bool, limit int, cursor string) (mf2.PostList, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	postList := mf2.PostList{
+		Paging: &mf2.ListPaging{},
+	}
+	posts := s.store.sortedPosts(keep)
+	keys := []string{}
+	for _, p := range posts {
+		keys = append(keys, p.sortKey)
+	}
+	indexes, paging, err := page(keys, limit, cursor)
+	if err != nil {
+		return postList, err
+	}
+	for _, i := range indexes {
+		postList.Add(posts[i].mf)
+	}
+	postList.Paging = &paging
+	return postList, nil
+}
+
+func (s Selecta) SelectYearList() ([]app.ArchiveLinkYear, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	list := []app.ArchiveLinkYear{}
+	for _, c := range s.countPosts(func(p *post) string { return p.year }, func(p *post) bool { return true }) {
+		list = append(list, app.ArchiveLinkYear{Year: c.key, Count: c.count})
+	}
+	return list, nil
+}
+
+func (s Selecta) SelectMonthList(year string) ([]app.ArchiveLinkMonth, error) {
+	list := []app.ArchiveLinkMonth{}
+	if year == "" {
+		return list, nil
+	}
+
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	for _, c := range s.countPosts(func(p *post) string { return p.month }, func(p *post) bool { return p.year == year }) {
+		list = append(list, app.ArchiveLinkMonth{Month: c.key, Count: c.count})
+	}
+	return list, nil
+}
+
+// countPosts groups the posts keep holds for by group, latest group
+// first
+func (s Selecta) countPosts(group func(p *post) string, keep func(p *post) bool) []groupCount {
+	counts := map[string]int{}
+	for _, p := range s.store.posts {
+		if keep(p) {
+			counts[group(p)]++
+		}
+	}
+
+	list := []groupCount{}
+	for key, count := range counts {
+		list = append(list, groupCount{key: key, count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key > list[j].key
+	})
+	return list
+}
+
+func (s Selecta) SelectPostByURL(url string) (mf2.MicroFormat, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	p, ok := s.store.posts[url]
+	if !ok {
+		return mf2.MicroFormat{}, nil
+	}
+	return p.mf, nil
+}
+
+// SelectPostVersion returns the current version of a post, 0 if the post
+// does not exist
+func (s Selecta) SelectPostVersion(url string) (int, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	p, ok := s.store.posts[url]
+	if !ok {
+		return 0, nil
+	}
+	return p.version, nil
+}
+
+// SelectPostsInBox returns up to limit of the posts made inside box,
+// newest first
+func (s Selecta) SelectPostsInBox(box geo.Box, limit int) (mf2.PostList, error) {
+	return s.selectPostsAt(func(lat, lng float64) bool {
+		return box.Contains(lat, lng)
+	}, limit)
+}
+
+// SelectPostsNear returns up to limit of the posts made within radius
+// kilometres of lat, lng, newest first
+func (s Selecta) SelectPostsNear(lat, lng, radius float64, limit int) (mf2.PostList, error) {
+	return s.selectPostsAt(func(pLat, pLng float64) bool {
+		return geo.Distance(lat, lng, pLat, pLng) <= radius
+	}, limit)
+}
+
+func (s Selecta) selectPostsAt(at func(lat, lng float64) bool, limit int) (mf2.PostList, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	postList := mf2.PostList{
+		Paging: &mf2.ListPaging{},
+	}
+	for _, p := range s.store.sortedPosts(func(p *post) bool {
+		return p.located && at(p.lat, p.lng)
+	}) {
+		if len(postList.Items) == limit {
+			break
+		}
+		postList.Add(p.mf)
+	}
+	return postList, nil
+}
+
+// SelectMediaInBox returns up to limit of the media taken inside box,
+// newest first
+func (s Selecta) SelectMediaInBox(box geo.Box, limit int) (mf2.MediaList, error) {
+	return s.selectMediaAt(func(lat, lng float64) bool {
+		return box.Contains(lat, lng)
+	}, limit)
+}
+
+// SelectMediaNear returns up to limit of the media taken within radius
+// kilometres of lat, lng, newest first
+func (s Selecta) SelectMediaNear(lat, lng, radius float64, limit int) (mf2.MediaList, error) {
+	return s.selectMediaAt(func(mLat, mLng float64) bool {
+		return geo.Distance(lat, lng, mLat, mLng) <= radius
+	}, limit)
+}
+
+func (s Selecta) selectMediaAt(at func(lat, lng float64) bool, limit int) (mf2.MediaList, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	mediaList := mf2.MediaList{
+		Paging: &mf2.ListPaging{},
+	}
+	for _, m := range s.store.sortedMedia(func(m *media) bool {
+		return m.located && at(m.data.Lat, m.data.Lng)
+	}) {
+		if len(mediaList.Items) == limit {
+			break
+		}
+		mediaList.Add(s.mediaMetadata(m))
+	}
+	return mediaList, nil
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	uuid "github.com/satori/go.uuid"
+)
+
+// SessionStore keeps sessions in memory. Like the database store, saving
+// a session that was never created does nothing and fetching one returns
+// an empty session
+type SessionStore struct {
+	mu       *sync.RWMutex
+	sessions map[string]app.SessionData
+}
+
+func NewSessionStore() SessionStore {
+	return SessionStore{
+		mu:       &sync.RWMutex{},
+		sessions: map[string]app.SessionData{},
+	}
+}
+
+func (ss SessionStore) Create() (app.SessionData, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sessData := app.SessionData{
+		Token: uuid.NewV4().String(),
+	}
+	ss.sessions[sessData.Token] = sessData
+	return sessData, nil
+}
+
+func (ss SessionStore) Fetch(sessionID string) (app.SessionData, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	return ss.sessions[sessionID], nil
+}
+
+func (ss SessionStore) Save(sessData app.SessionData) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.sessions[sessData.Token]; ok {
+		ss.sessions[sessData.Token] = sessData
+	}
+	return nil
+}
@@ -7,7 +7,9 @@ import (
 	"io"
 	"log"
 	"net/url"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,8 +33,11 @@ type MediaList struct {
 	Paging *ListPaging     `json:"paging,omitempty"`
 }
 
+// ListPaging holds the cursors of the pages either side of a list, After
+// for older items and Before for newer ones
 type ListPaging struct {
-	After string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
 }
 
 func (list *MediaList) Add(item MediaMetadata) {
@@ -124,9 +129,53 @@ func (mf *MicroFormat) ApplyUpdate(updates map[string][]interface{}) {
 	}
 }
 
+// PropertyChange describes how a single property differs between two
+// versions of a post
+type PropertyChange struct {
+	Property string        `json:"property"`
+	Before   []interface{} `json:"before,omitempty"`
+	After    []interface{} `json:"after,omitempty"`
+}
+
+// DiffProperties lists the properties that were added, removed or changed
+// between two versions of a post, ordered by property name
+func DiffProperties(before, after MicroFormat) []PropertyChange {
+	keys := map[string]bool{}
+	for k := range before.Properties {
+		keys[k] = true
+	}
+	for k := range after.Properties {
+		keys[k] = true
+	}
+
+	names := []string{}
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	changes := []PropertyChange{}
+	for _, k := range names {
+		b := before.Properties[k]
+		a := after.Properties[k]
+		if len(b) == 0 && len(a) == 0 {
+			continue
+		}
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		changes = append(changes, PropertyChange{
+			Property: k,
+			Before:   b,
+			After:    a,
+		})
+	}
+	return changes
+}
+
 func (mf MicroFormat) Feeds() []string {
 	ym := parseYearMonth(mf.getFirstString("published"))
-	return []string{"all", ym}
+	return []string{"all", ym, mf.PostType()}
 }
 
 func parseYearMonth(ym string) string {
@@ -169,9 +218,97 @@ func (mf MicroFormat) GetStringSlice(key string) []string {
 	return mf.getStringSlice(key)
 }
 
+// IsPost reports whether mf is an h-entry or h-event, the posts that are
+// projected
+func (mf MicroFormat) IsPost() bool {
+	return len(mf.Type) > 0 && (mf.Type[0] == "h-entry" || mf.Type[0] == "h-event")
+}
+
+// PostTypes are the kinds of post PostType discovers
+var PostTypes = []string{
+	"note",
+	"article",
+	"photo",
+	"video",
+	"reply",
+	"like",
+	"repost",
+	"bookmark",
+	"rsvp",
+	"checkin",
+	"event",
+}
+
+// IsPostType reports whether postType is one of PostTypes
+func IsPostType(postType string) bool {
+	for _, t := range PostTypes {
+		if t == postType {
+			return true
+		}
+	}
+	return false
+}
+
+// PostType is the kind of post mf is, following the IndieWeb Post Type
+// Discovery algorithm https://www.w3.org/TR/post-type-discovery/. The
+// spec has no bookmarks or checkins, they are responses like reposts and
+// likes so are checked after those and before any media
+func (mf MicroFormat) PostType() string {
+	if len(mf.Type) > 0 && mf.Type[0] == "h-event" {
+		return "event"
+	}
+	switch {
+	case isRSVP(mf.getFirstString("rsvp")):
+		return "rsvp"
+	case len(mf.Properties["in-reply-to"]) > 0:
+		return "reply"
+	case len(mf.Properties["repost-of"]) > 0:
+		return "repost"
+	case len(mf.Properties["like-of"]) > 0:
+		return "like"
+	case len(mf.Properties["bookmark-of"]) > 0:
+		return "bookmark"
+	case len(mf.Properties["checkin"]) > 0:
+		return "checkin"
+	case len(mf.Properties["video"]) > 0:
+		return "video"
+	case len(mf.Properties["photo"]) > 0:
+		return "photo"
+	case mf.hasTitle():
+		return "article"
+	}
+	return "note"
+}
+
+func isRSVP(rsvp string) bool {
+	switch strings.ToLower(strings.TrimSpace(rsvp)) {
+	case "yes", "no", "maybe", "interested":
+		return true
+	}
+	return false
+}
+
+// hasTitle reports whether the name of mf is a title rather than the
+// implied name parsers copy from the start of the content
+func (mf MicroFormat) hasTitle() bool {
+	name := strings.Join(strings.Fields(mf.getFirstString("name")), " ")
+	if name == "" {
+		return false
+	}
+	content := mf.parseContentText()
+	if content == "" {
+		content = mf.getFirstString("summary")
+	}
+	content = strings.Join(strings.Fields(content), " ")
+	return !strings.HasPrefix(content, name)
+}
+
 func (mf MicroFormat) ToView() MicroFormatView {
 	out := MicroFormatView{}
-	out.Type = strings.Trim(mf.Type[0], "h-")
+	if len(mf.Type) > 0 {
+		out.Type = strings.Trim(mf.Type[0], "h-")
+	}
+	out.PostType = mf.PostType()
 	out.Uid = mf.getFirstString("uid")
 	out.Url = mf.getFirstString("url")
 	out.Name = mf.getFirstString("name")
@@ -181,6 +318,9 @@ func (mf MicroFormat) ToView() MicroFormatView {
 	out.Photo = mf.getStringSlice("photo")
 	out.Video = mf.getStringSlice("video")
 	out.Location = mf.parseLocation()
+	out.Checkin = mf.parseAddress("checkin")
+	out.Start = mf.getFirstString("start")
+	out.End = mf.getFirstString("end")
 	out.Author = mf.getFirstString("author")
 	out.Published = mf.parsePublishedValue()
 
@@ -258,8 +398,122 @@ func (mf MicroFormat) parseContentValue() template.HTML {
 	return template.HTML("")
 }
 
+// SearchText is the text a post is found by: its name, summary, content,
+// categories and location
+func (mf MicroFormat) SearchText() string {
+	parts := []string{
+		mf.getFirstString("name"),
+		mf.getFirstString("summary"),
+		mf.parseContentText(),
+	}
+	parts = append(parts, mf.getStringSlice("category")...)
+	for _, location := range mf.getStringSlice("location") {
+		if !strings.HasPrefix(location, "geo:") {
+			parts = append(parts, location)
+		}
+	}
+	parts = append(parts, mf.parseLocation())
+
+	out := []string{}
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// parseContentText returns content as plain text, preferring the value of
+// html content over stripping its tags
+func (mf MicroFormat) parseContentText() string {
+	s := mf.getFirstString("content")
+	if s != "" {
+		return s
+	}
+
+	for _, v := range mf.Properties["content"] {
+		if p, ok := v.(map[string]interface{}); ok {
+			if o, valueExists := p["value"].(string); valueExists {
+				return o
+			}
+			if o, htmlExists := p["html"].(string); htmlExists {
+				return bluemonday.StrictPolicy().Sanitize(o)
+			}
+		}
+	}
+
+	return ""
+}
+
 func (mf MicroFormat) parseLocation() string {
+	return mf.parseAddress("location")
+}
+
+// LatLng is where the post was made, from the first location that is a
+// geo: uri or an h-adr, h-card or h-geo with a latitude and longitude
+func (mf MicroFormat) LatLng() (float64, float64, bool) {
 	for _, v := range mf.Properties["location"] {
+		switch location := v.(type) {
+		case string:
+			if lat, lng, ok := parseGeoURI(location); ok {
+				return lat, lng, true
+			}
+		case map[string]interface{}:
+			props, ok := location["properties"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			lat, latOk := parseCoordinate(props["latitude"])
+			lng, lngOk := parseCoordinate(props["longitude"])
+			if latOk && lngOk {
+				return lat, lng, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// parseGeoURI parses a geo: uri such as geo:53.8,-1.5;u=35
+func parseGeoURI(uri string) (float64, float64, bool) {
+	if !strings.HasPrefix(uri, "geo:") {
+		return 0, 0, false
+	}
+	coords := strings.Split(strings.SplitN(strings.TrimPrefix(uri, "geo:"), ";", 2)[0], ",")
+	if len(coords) < 2 {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(coords[0], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lng, err := strconv.ParseFloat(coords[1], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+// parseCoordinate reads the first value of a latitude or longitude
+// property, which may be a number or a string
+func parseCoordinate(property interface{}) (float64, bool) {
+	values, ok := property.([]interface{})
+	if !ok || len(values) == 0 {
+		return 0, false
+	}
+	switch c := values[0].(type) {
+	case float64:
+		return c, true
+	case string:
+		f, err := strconv.ParseFloat(c, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// parseAddress joins the name and address of the first h-adr or h-card
+// in the key property
+func (mf MicroFormat) parseAddress(key string) string {
+	for _, v := range mf.Properties[key] {
 		location, ok := v.(map[string]interface{})
 		if ok {
 			locType, ok := location["type"].([]interface{})
@@ -300,6 +554,7 @@ func (mf MicroFormat) parsePublishedValue() string {
 
 type MicroFormatView struct {
 	Type        string        `json:"type,omitempty"`
+	PostType    string        `json:"post_type,omitempty"`
 	Uid         string        `json:"uid,omitempty"`
 	Url         string        `json:"url,omitempty"`
 	Published   string        `json:"published,omitempty"`
@@ -310,6 +565,9 @@ type MicroFormatView struct {
 	Content     template.HTML `json:"content,omitempty"`
 	Rsvp        string        `json:"rsvp,omitempty"`
 	Location    string        `json:"location,omitempty"`
+	Checkin     string        `json:"checkin,omitempty"`
+	Start       string        `json:"start,omitempty"`
+	End         string        `json:"end,omitempty"`
 	RepostOf    []string      `json:"repost_of,omitempty"`
 	LikeOf      []string      `json:"like_of,omitempty"`
 	BookmarkOf  []string      `json:"bookmark_of,omitempty"`
@@ -118,7 +118,7 @@ func TestFeeds(t *testing.T) {
 	mf := mf2.MicroFormat{Type: []string{"h-test"}}
 	mf.Properties = p
 
-	expected := []string{"all", "201712"}
+	expected := []string{"all", "201712", "note"}
 	res := mf.Feeds()
 	if !reflect.DeepEqual(expected, res) {
 		t.Fatalf("expected %#v, got %#v", expected, res)
@@ -365,6 +365,7 @@ func TestMf2FromJSONToView(t *testing.T) {
 			}`,
 			expected: mf2.MicroFormatView{
 				Type:      "entry",
+				PostType:  "note",
 				Published: "2018-01-28T00:00:00Z",
 				Archive:   "201801",
 			},
@@ -408,6 +409,7 @@ func TestMf2FromJSONToView(t *testing.T) {
 			}`,
 			expected: mf2.MicroFormatView{
 				Type:        "entry",
+				PostType:    "reply",
 				Uid:         "9a9ecd17-2fcf-4d91-97e2-09e2cd9e06b5",
 				Url:         "https://example.com/test1",
 				Name:        "test-name",
@@ -476,6 +478,49 @@ func createProperty(key string, properties interface{}) map[string][]interface{}
 	return p
 }
 
+func TestDiffProperties(t *testing.T) {
+	var tests = []struct {
+		name     string
+		before   mf2.MicroFormat
+		after    mf2.MicroFormat
+		expected []mf2.PropertyChange
+	}{
+		{
+			name:     "no changes",
+			before:   mf2.MicroFormat{Properties: map[string][]interface{}{"content": []interface{}{"hi"}}},
+			after:    mf2.MicroFormat{Properties: map[string][]interface{}{"content": []interface{}{"hi"}}},
+			expected: []mf2.PropertyChange{},
+		},
+		{
+			name:   "added, removed and changed",
+			before: mf2.MicroFormat{Properties: map[string][]interface{}{"content": []interface{}{"hi"}, "name": []interface{}{"title"}}},
+			after:  mf2.MicroFormat{Properties: map[string][]interface{}{"content": []interface{}{"hello"}, "category": []interface{}{"tag1"}}},
+			expected: []mf2.PropertyChange{
+				{Property: "category", After: []interface{}{"tag1"}},
+				{Property: "content", Before: []interface{}{"hi"}, After: []interface{}{"hello"}},
+				{Property: "name", Before: []interface{}{"title"}},
+			},
+		},
+		{
+			name:   "new post",
+			before: mf2.MicroFormat{},
+			after:  mf2.MicroFormat{Properties: map[string][]interface{}{"content": []interface{}{"hi"}}},
+			expected: []mf2.PropertyChange{
+				{Property: "content", After: []interface{}{"hi"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result := mf2.DiffProperties(tt.before, tt.after)
+			is.Equal(result, tt.expected)
+		})
+	}
+}
+
 func checkPropertiesContains(t *testing.T, properties []interface{}, expected string) {
 	for _, v := range properties {
 		ty, ok := v.(string)
@@ -494,3 +539,234 @@ func checkSliceContains(t *testing.T, slice []string, expected string) {
 	}
 	t.Fatalf("%s not found in slice %v", expected, slice)
 }
+
+func TestSearchText(t *testing.T) {
+	var tests = []struct {
+		name     string
+		mf       mf2.MicroFormat
+		expected string
+	}{
+		{
+			name:     "empty post",
+			mf:       mf2.MicroFormat{Type: []string{"h-entry"}},
+			expected: "",
+		},
+		{
+			name: "plain content and categories",
+			mf: mf2.MicroFormat{
+				Type: []string{"h-entry"},
+				Properties: map[string][]interface{}{
+					"name":     {"Sunday"},
+					"content":  {"walked up the hill"},
+					"category": {"walking", "hills"},
+				},
+			},
+			expected: "Sunday walked up the hill walking hills",
+		},
+		{
+			name: "html content and h-card location",
+			mf: mf2.MicroFormat{
+				Type: []string{"h-entry"},
+				Properties: map[string][]interface{}{
+					"content": {map[string]interface{}{"html": "<p>ramen <b>again</b></p>"}},
+					"location": {
+						"geo:51.5,-0.1",
+						map[string]interface{}{
+							"type": []interface{}{"h-card"},
+							"properties": map[string]interface{}{
+								"name":     []interface{}{"Bone Daddies"},
+								"locality": []interface{}{"London"},
+							},
+						},
+					},
+				},
+			},
+			expected: "ramen again Bone Daddies, London",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(tt.mf.SearchText(), tt.expected)
+		})
+	}
+}
+
+func TestPostType(t *testing.T) {
+	var tests = []struct {
+		name       string
+		mfType     string
+		properties map[string][]interface{}
+		expected   string
+	}{
+		{
+			name:       "note",
+			properties: map[string][]interface{}{"content": {"hello"}},
+			expected:   "note",
+		},
+		{
+			name:       "article",
+			properties: map[string][]interface{}{"name": {"Title"}, "content": {"hello"}},
+			expected:   "article",
+		},
+		{
+			name:       "photo",
+			properties: map[string][]interface{}{"name": {"Title"}, "photo": {"https://example.com/1.jpg"}},
+			expected:   "photo",
+		},
+		{
+			name:       "reply with photo",
+			properties: map[string][]interface{}{"in-reply-to": {"https://example.com/"}, "photo": {"https://example.com/1.jpg"}},
+			expected:   "reply",
+		},
+		{
+			name:       "like",
+			properties: map[string][]interface{}{"like-of": {"https://example.com/"}},
+			expected:   "like",
+		},
+		{
+			name:       "repost",
+			properties: map[string][]interface{}{"repost-of": {"https://example.com/"}},
+			expected:   "repost",
+		},
+		{
+			name:       "bookmark",
+			properties: map[string][]interface{}{"bookmark-of": {"https://example.com/"}, "name": {"A page"}},
+			expected:   "bookmark",
+		},
+		{
+			name:       "implied name is not a title",
+			properties: map[string][]interface{}{"name": {"hello  world"}, "content": {"hello world, how are you"}},
+			expected:   "note",
+		},
+		{
+			name:       "html content",
+			properties: map[string][]interface{}{"name": {"Title"}, "content": {map[string]interface{}{"html": "<p>hello</p>", "value": "hello"}}},
+			expected:   "article",
+		},
+		{
+			name:       "video with photo poster",
+			properties: map[string][]interface{}{"video": {"https://example.com/1.mp4"}, "photo": {"https://example.com/1.jpg"}},
+			expected:   "video",
+		},
+		{
+			name:       "rsvp",
+			properties: map[string][]interface{}{"rsvp": {"Yes"}, "in-reply-to": {"https://example.com/event"}},
+			expected:   "rsvp",
+		},
+		{
+			name:       "invalid rsvp is a reply",
+			properties: map[string][]interface{}{"rsvp": {"perhaps"}, "in-reply-to": {"https://example.com/event"}},
+			expected:   "reply",
+		},
+		{
+			name:       "reply with a repost",
+			properties: map[string][]interface{}{"repost-of": {"https://example.com/"}, "in-reply-to": {"https://example.com/"}},
+			expected:   "reply",
+		},
+		{
+			name:       "like of a bookmark",
+			properties: map[string][]interface{}{"like-of": {"https://example.com/"}, "bookmark-of": {"https://example.com/"}},
+			expected:   "like",
+		},
+		{
+			name: "checkin",
+			properties: map[string][]interface{}{
+				"checkin": {map[string]interface{}{
+					"type":       []interface{}{"h-card"},
+					"properties": map[string]interface{}{"name": []interface{}{"Nandos"}},
+				}},
+				"photo": {"https://example.com/1.jpg"},
+			},
+			expected: "checkin",
+		},
+		{
+			name:       "event",
+			mfType:     "h-event",
+			properties: map[string][]interface{}{"name": {"Party"}, "start": {"2019-06-01T20:00:00Z"}},
+			expected:   "event",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			mfType := tt.mfType
+			if mfType == "" {
+				mfType = "h-entry"
+			}
+			mf := mf2.MicroFormat{Type: []string{mfType}, Properties: tt.properties}
+			is.True(mf2.IsPostType(tt.expected))
+			is.Equal(mf.PostType(), tt.expected)
+			is.Equal(mf.ToView().PostType, tt.expected)
+		})
+	}
+}
+
+func TestLatLng(t *testing.T) {
+	var tests = []struct {
+		name        string
+		location    []interface{}
+		expectedLat float64
+		expectedLng float64
+		expectedOk  bool
+	}{
+		{
+			name:       "no location",
+			expectedOk: false,
+		},
+		{
+			name:        "geo uri",
+			location:    []interface{}{"geo:53.8,-1.55;u=35"},
+			expectedLat: 53.8,
+			expectedLng: -1.55,
+			expectedOk:  true,
+		},
+		{
+			name: "h-card with string coordinates",
+			location: []interface{}{
+				map[string]interface{}{
+					"type": []interface{}{"h-card"},
+					"properties": map[string]interface{}{
+						"name":      []interface{}{"Bone Daddies"},
+						"latitude":  []interface{}{"51.5"},
+						"longitude": []interface{}{"-0.1"},
+					},
+				},
+			},
+			expectedLat: 51.5,
+			expectedLng: -0.1,
+			expectedOk:  true,
+		},
+		{
+			name: "h-adr without coordinates",
+			location: []interface{}{
+				map[string]interface{}{
+					"type": []interface{}{"h-adr"},
+					"properties": map[string]interface{}{
+						"locality": []interface{}{"Leeds"},
+					},
+				},
+			},
+			expectedOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			mf := mf2.MicroFormat{
+				Type:       []string{"h-entry"},
+				Properties: map[string][]interface{}{},
+			}
+			if tt.location != nil {
+				mf.Properties["location"] = tt.location
+			}
+			lat, lng, ok := mf.LatLng()
+			is.Equal(ok, tt.expectedOk)
+			is.Equal(lat, tt.expectedLat)
+			is.Equal(lng, tt.expectedLng)
+		})
+	}
+}
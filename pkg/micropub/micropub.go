@@ -16,7 +16,8 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/cursor"
 	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
 	"github.com/j4y_funabashi/inari-micropub/pkg/indieauth"
 	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
@@ -56,11 +57,30 @@ type Server struct {
 	tokenEndpoint string
 	logger        *logrus.Logger
 	verifyToken   func(tokenEndpoint, bearerToken string, logger *logrus.Logger) (indieauth.TokenResponse, error)
-	selecta       db.Selecta
-	eventLog      eventlog.EventLog
+	selecta       Selecta
+	eventLog      EventLog
 	mediaServer   MediaServer
 }
 
+// Selecta is what micropub queries read, db.Selecta and memory.Selecta
+// both provide it
+type Selecta interface {
+	SelectMediaYearList() []app.Year
+	SelectMediaMonthList(year string) ([]app.Month, error)
+	SelectMediaMonth(year, month string) (mf2.MediaList, error)
+	SelectMediaList(limit int, cursor string) (mf2.MediaList, error)
+	SelectMediaByURL(url string) (app.Media, error)
+	SelectPostList(limit int, cursor string) (mf2.PostList, error)
+	SelectPostByURL(url string) (mf2.MicroFormat, error)
+	SelectYearList() ([]app.ArchiveLinkYear, error)
+	SelectMonthList(year string) ([]app.ArchiveLinkMonth, error)
+}
+
+// EventLog records the events micropub requests produce
+type EventLog interface {
+	Append(event eventlog.Event) error
+}
+
 type HttpResponse struct {
 	Body       string
 	StatusCode int
@@ -72,8 +92,8 @@ func NewServer(
 	tokenEndpoint string,
 	logger *logrus.Logger,
 	verifyToken func(tokenEndpoint, bearerToken string, logger *logrus.Logger) (indieauth.TokenResponse, error),
-	selecta db.Selecta,
-	eventLog eventlog.EventLog,
+	selecta Selecta,
+	eventLog EventLog,
 	mediaServer MediaServer,
 ) Server {
 	return Server{
@@ -118,10 +138,9 @@ func (s Server) handleMediaQuery() http.HandlerFunc {
 				if limit == 0 {
 					limit = 20
 				}
-				after := r.URL.Query().Get("after")
 				year := r.URL.Query().Get("year")
 				month := r.URL.Query().Get("month")
-				response = s.QueryMediaList(limit, after, year, month)
+				response = s.QueryMediaList(limit, pageCursor(r), year, month)
 			}
 		case "years":
 			response = s.QueryMediaYearsList()
@@ -367,8 +386,7 @@ func (s Server) handleMicropub(baseURL string) http.HandlerFunc {
 					if limit == 0 {
 						limit = 30
 					}
-					after := r.URL.Query().Get("after")
-					response = s.QuerySourceList(limit, after)
+					response = s.QuerySourceList(limit, pageCursor(r))
 				}
 			case "years":
 				response = s.QueryYearsList()
@@ -555,33 +573,41 @@ func (s Server) QueryMediaByURL(url string) HttpResponse {
 	}
 }
 
-func (s Server) QueryMediaList(limit int, after, year, month string) HttpResponse {
+// pageCursor is the cursor of the page of a list a request asks for,
+// through either its before or after parameter
+func pageCursor(r *http.Request) string {
+	if before := r.URL.Query().Get("before"); before != "" {
+		return before
+	}
+	return r.URL.Query().Get("after")
+}
+
+func (s Server) QueryMediaList(limit int, page, year, month string) HttpResponse {
 
 	var err error
 	var body mf2.MediaList
 	if month != "" && year != "" {
 		body, err = s.selecta.SelectMediaMonth(year, month)
-		if err != nil {
-			return HttpResponse{
-				Body: err.Error(),
-			}
-		}
-
 	} else {
-		body, err = s.selecta.SelectMediaList(limit, after)
-		if err != nil {
-			return HttpResponse{
-				Body: err.Error(),
-			}
+		body, err = s.selecta.SelectMediaList(limit, page)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == cursor.ErrInvalid {
+			status = http.StatusBadRequest
+		}
+		return HttpResponse{
+			Body:       err.Error(),
+			StatusCode: status,
 		}
-
 	}
 
 	buf := bytes.NewBuffer([]byte{})
 	err = json.NewEncoder(buf).Encode(body)
 	if err != nil {
 		return HttpResponse{
-			Body: err.Error(),
+			Body:       err.Error(),
+			StatusCode: http.StatusInternalServerError,
 		}
 	}
 	headers := map[string]string{
@@ -689,10 +715,20 @@ func (s Server) QueryMediaMonthsList(year string) HttpResponse {
 	}
 }
 
-func (s Server) QuerySourceList(limit int, after string) HttpResponse {
-	body := s.selecta.SelectPostList(limit, after)
+func (s Server) QuerySourceList(limit int, page string) HttpResponse {
+	body, err := s.selecta.SelectPostList(limit, page)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == cursor.ErrInvalid {
+			status = http.StatusBadRequest
+		}
+		return HttpResponse{
+			Body:       err.Error(),
+			StatusCode: status,
+		}
+	}
 	buf := bytes.NewBuffer([]byte{})
-	err := json.NewEncoder(buf).Encode(body)
+	err = json.NewEncoder(buf).Encode(body)
 	if err != nil {
 		return HttpResponse{
 			Body:       err.Error(),
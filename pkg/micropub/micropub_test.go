@@ -1,10 +1,17 @@
 package micropub_test
 
 import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
 	"github.com/j4y_funabashi/inari-micropub/pkg/micropub"
 	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
 )
 
 func TestItWorks(t *testing.T) {
@@ -49,3 +56,27 @@ func TestItWorks(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryMediaListStatus(t *testing.T) {
+	is := is.New(t)
+	dir, err := ioutil.TempDir("", "inari")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+	sqlDB, err := db.Open("sqlite://" + filepath.Join(dir, "inari.db"))
+	is.NoErr(err)
+	defer sqlDB.Close()
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	s := micropub.NewServer("", "", logger, nil, db.NewSelecta(sqlDB), eventlog.EventLog{}, micropub.MediaServer{})
+
+	res := s.QueryMediaList(10, "", "", "")
+	is.Equal(res.StatusCode, http.StatusOK)
+
+	res = s.QueryMediaList(10, "not a cursor", "", "")
+	is.Equal(res.StatusCode, http.StatusBadRequest)
+
+	sqlDB.Close()
+	res = s.QueryMediaList(10, "", "", "")
+	is.Equal(res.StatusCode, http.StatusInternalServerError)
+}
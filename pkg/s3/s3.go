@@ -137,3 +137,11 @@ func (client Client) WriteObject(key, bucket string, body io.Reader, isPrivate b
 	}
 	return nil
 }
+
+func (client Client) DeleteObject(key, bucket string) error {
+	_, err := client.s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
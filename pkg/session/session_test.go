@@ -0,0 +1,73 @@
+package session_test
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/db"
+	"github.com/j4y_funabashi/inari-micropub/pkg/session"
+	"github.com/matryer/is"
+)
+
+// forEachDatabase runs a test against a fresh SQLite file, and against
+// the Postgres database at TEST_DATABASE_URL when it is set
+func forEachDatabase(t *testing.T, test func(t *testing.T, sqlDB *sql.DB)) {
+	t.Run("sqlite", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "inari")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		sqlDB, err := db.Open("sqlite://" + filepath.Join(dir, "inari.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sqlDB.Close()
+		test(t, sqlDB)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		databaseURL := os.Getenv("TEST_DATABASE_URL")
+		if databaseURL == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		sqlDB, err := db.Open(databaseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sqlDB.Close()
+		test(t, sqlDB)
+	})
+}
+
+func TestSessionStore(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, sqlDB *sql.DB) {
+		is := is.New(t)
+		sut := session.NewSessionStore(sqlDB)
+
+		created, err := sut.Create()
+		is.NoErr(err)
+		is.True(created.Token != "")
+
+		created.UID = "https://example.com/p/a"
+		created.Content = "hello"
+		created.Media = []app.Media{{URL: "https://media.example.com/a.jpg"}}
+		err = sut.Save(created)
+		is.NoErr(err)
+
+		fetched, err := sut.Fetch(created.Token)
+		is.NoErr(err)
+		is.Equal(fetched.UID, "https://example.com/p/a")
+		is.Equal(fetched.Content, "hello")
+		is.Equal(len(fetched.Media), 1)
+
+		missing, err := sut.Fetch("nope")
+		is.NoErr(err)
+		is.Equal(missing.Token, "")
+	})
+}
@@ -2,11 +2,14 @@
 package view
 
 import (
+	"encoding/json"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
 )
 
 var humanDateLayout = "Mon Jan 02, 2006"
@@ -240,3 +243,76 @@ func parseMediaColumns(mediaRes app.ShowMediaResponse) [][]Media {
 
 	return out
 }
+
+type PropertyChangeView struct {
+	Property string
+	Before   string
+	After    string
+}
+
+type RevisionView struct {
+	Version    int
+	EventType  string
+	HumanDate  string
+	URL        string
+	IsSelected bool
+	IsLatest   bool
+	Changes    []PropertyChangeView
+}
+
+type PostRevisionsView struct {
+	URL       string
+	Revisions []RevisionView
+	Selected  mf2.MicroFormatView
+}
+
+func (pres Presenter) ParsePostRevisions(res app.PostRevisionsResponse) PostRevisionsView {
+	out := PostRevisionsView{
+		URL: res.URL,
+	}
+	if len(res.Selected.Post.Type) > 0 {
+		out.Selected = res.Selected.Post.ToView()
+	}
+
+	// newest revision first
+	for i := len(res.Revisions) - 1; i >= 0; i-- {
+		rev := res.Revisions[i]
+		urlParams := url.Values{}
+		urlParams.Add("url", res.URL)
+		urlParams.Add("revision", strconv.Itoa(rev.Version))
+		rv := RevisionView{
+			Version:    rev.Version,
+			EventType:  rev.EventType,
+			HumanDate:  rev.Timestamp.Format(humanDateLayout + " 15:04"),
+			URL:        "?" + urlParams.Encode(),
+			IsSelected: rev.Version == res.Selected.Version,
+			IsLatest:   i == len(res.Revisions)-1,
+		}
+		for _, c := range rev.Changes {
+			rv.Changes = append(rv.Changes, PropertyChangeView{
+				Property: c.Property,
+				Before:   formatPropertyValues(c.Before),
+				After:    formatPropertyValues(c.After),
+			})
+		}
+		out.Revisions = append(out.Revisions, rv)
+	}
+
+	return out
+}
+
+func formatPropertyValues(values []interface{}) string {
+	out := []string{}
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			out = append(out, str)
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		out = append(out, string(b))
+	}
+	return strings.Join(out, ", ")
+}
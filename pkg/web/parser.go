@@ -3,6 +3,9 @@ package web
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
 
 	"github.com/j4y_funabashi/inari-micropub/pkg/app"
 )
@@ -46,6 +49,42 @@ func parseUpdateAction(decoder *json.Decoder) (string, map[string][]interface{})
 	return "replace", v.Replace
 }
 
+func (p Parser) ParseRevertRequest(bodyBytes []byte) app.RevertPostRequest {
+	v := struct {
+		URL      string `json:"url"`
+		Revision int    `json:"revision"`
+	}{}
+	err := json.Unmarshal(bodyBytes, &v)
+	if err != nil {
+		return app.RevertPostRequest{}
+	}
+	return app.RevertPostRequest{
+		URL:      v.URL,
+		Revision: v.Revision,
+	}
+}
+
+// ErrInvalidIfMatch is returned for an If-Match header that is not a
+// single strong post version or a wildcard
+var ErrInvalidIfMatch = errors.New("If-Match is not a single strong post version")
+
+// ParseIfMatch reads the post version from an If-Match header, returning
+// 0 when the header is missing or a wildcard
+func (p Parser) ParseIfMatch(header string) (int, error) {
+	etag := strings.TrimSpace(header)
+	if etag == "" || etag == "*" {
+		return 0, nil
+	}
+	if len(etag) < 3 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return 0, ErrInvalidIfMatch
+	}
+	version, err := strconv.Atoi(etag[1 : len(etag)-1])
+	if err != nil || version < 1 {
+		return 0, ErrInvalidIfMatch
+	}
+	return version, nil
+}
+
 func (p Parser) ParseMicropubPostAction(bodyBytes []byte) string {
 
 	decoder := json.NewDecoder(bytes.NewBuffer(bodyBytes))
@@ -2,23 +2,30 @@ package web
 
 import (
 	"bytes"
+	htmltemplate "html/template"
 	"net/http"
+	"path/filepath"
 	"text/template"
+	"time"
 
+	"github.com/j4y_funabashi/inari-micropub/pkg/app"
 	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
 	"github.com/j4y_funabashi/inari-micropub/pkg/view"
 )
 
-func renderHomepage(outBuf *bytes.Buffer, postList []mf2.MicroFormat, afterKey string) error {
+// renderHomepage renders a page of posts. Links to other pages and to the
+// postTypes filters point at basePath
+func renderHomepage(dir string, outBuf *bytes.Buffer, postList *app.QueryPostListResponse, basePath, postType string, postTypes []string) error {
 
 	pl := []mf2.MicroFormatView{}
-	for _, mf2 := range postList {
+	for _, mf2 := range postList.PostList {
 		pl = append(pl, mf2.ToView())
 	}
 
 	t, err := template.ParseFiles(
-		"view/layout.html",
-		"view/homepage.html",
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "homepage.html"),
+		filepath.Join(dir, "post_card.html"),
 	)
 	if err != nil {
 		return err
@@ -26,21 +33,144 @@ func renderHomepage(outBuf *bytes.Buffer, postList []mf2.MicroFormat, afterKey s
 	v := struct {
 		PageTitle string
 		PostList  []mf2.MicroFormatView
+		BasePath  string
+		BeforeKey string
 		AfterKey  string
+		PostType  string
+		PostTypes []string
 	}{
 		PageTitle: "jay.funabashi",
 		PostList:  pl,
-		AfterKey:  afterKey,
+		BasePath:  basePath,
+		BeforeKey: postList.BeforeKey,
+		AfterKey:  postList.AfterKey,
+		PostType:  postType,
+		PostTypes: postTypes,
 	}
 	err = t.ExecuteTemplate(outBuf, "layout", v)
 	return err
 }
 
-func renderMediaDetail(media view.MediaDetailView, w http.ResponseWriter) error {
+// renderSearch uses html/template as the query is echoed back
+func renderSearch(dir string, outBuf *bytes.Buffer, query string, postList *app.QueryPostListResponse) error {
+
+	pl := []mf2.MicroFormatView{}
+	for _, mf2 := range postList.PostList {
+		pl = append(pl, mf2.ToView())
+	}
+
+	t, err := htmltemplate.ParseFiles(
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "search.html"),
+		filepath.Join(dir, "post_card.html"),
+	)
+	if err != nil {
+		return err
+	}
+	v := struct {
+		PageTitle string
+		Query     string
+		PostList  []mf2.MicroFormatView
+		BeforeKey string
+		AfterKey  string
+	}{
+		PageTitle: "search - jay.funabashi",
+		Query:     query,
+		PostList:  pl,
+		BeforeKey: postList.BeforeKey,
+		AfterKey:  postList.AfterKey,
+	}
+	err = t.ExecuteTemplate(outBuf, "layout", v)
+	return err
+}
+
+// archiveLink links to an archive period and counts its posts
+type archiveLink struct {
+	Name    string
+	URL     string
+	Count   int
+	Current bool
+}
+
+// renderArchive renders an archive page as an h-feed, root is the path
+// of the whole archive
+func renderArchive(dir string, outBuf *bytes.Buffer, root string, archive *app.QueryArchiveResponse) error {
+
+	pl := []mf2.MicroFormatView{}
+	for _, mf2 := range archive.PostList {
+		pl = append(pl, mf2.ToView())
+	}
+
+	title := "Archive"
+	url := root
+	years := []archiveLink{}
+	for _, y := range archive.Years {
+		years = append(years, archiveLink{
+			Name:    y.Year,
+			URL:     root + "/" + y.Year,
+			Count:   y.Count,
+			Current: y.Year == archive.Year,
+		})
+	}
+	months := []archiveLink{}
+	for _, m := range archive.Months {
+		name := m.Month
+		if t, err := time.Parse("2006-01", archive.Year+"-"+m.Month); err == nil {
+			name = t.Format("January")
+		}
+		link := archiveLink{
+			Name:    name,
+			URL:     root + "/" + archive.Year + "/" + m.Month,
+			Count:   m.Count,
+			Current: m.Month == archive.Month,
+		}
+		if link.Current {
+			title = name + " " + archive.Year
+			url = link.URL
+		}
+		months = append(months, link)
+	}
+	if archive.Month == "" && archive.Year != "" {
+		title = archive.Year
+		url = root + "/" + archive.Year
+	}
+
+	t, err := template.ParseFiles(
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "archive.html"),
+		filepath.Join(dir, "post_card.html"),
+	)
+	if err != nil {
+		return err
+	}
+	v := struct {
+		PageTitle string
+		Title     string
+		URL       string
+		Years     []archiveLink
+		Months    []archiveLink
+		PostList  []mf2.MicroFormatView
+		BeforeKey string
+		AfterKey  string
+	}{
+		PageTitle: title + " - jay.funabashi",
+		Title:     title,
+		URL:       url,
+		Years:     years,
+		Months:    months,
+		PostList:  pl,
+		BeforeKey: archive.BeforeKey,
+		AfterKey:  archive.AfterKey,
+	}
+	err = t.ExecuteTemplate(outBuf, "layout", v)
+	return err
+}
+
+func renderMediaDetail(dir string, media view.MediaDetailView, w http.ResponseWriter) error {
 	outBuf := new(bytes.Buffer)
 	t, err := template.ParseFiles(
-		"view/layout.html",
-		"view/media_detail.html",
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "media_detail.html"),
 	)
 	if err != nil {
 		return err
@@ -63,11 +193,11 @@ func renderMediaDetail(media view.MediaDetailView, w http.ResponseWriter) error
 	return err
 }
 
-func renderComposerForm(viewModel view.ComposerView, w http.ResponseWriter) error {
+func renderComposerForm(dir string, viewModel view.ComposerView, w http.ResponseWriter) error {
 	outBuf := new(bytes.Buffer)
 	t, err := template.ParseFiles(
-		"view/layout.html",
-		"view/composer.html",
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "composer.html"),
 	)
 	if err != nil {
 		return err
@@ -90,11 +220,11 @@ func renderComposerForm(viewModel view.ComposerView, w http.ResponseWriter) erro
 	return err
 }
 
-func renderLocationSearch(viewModel view.LocationSearchView, w http.ResponseWriter) error {
+func renderLocationSearch(dir string, viewModel view.LocationSearchView, w http.ResponseWriter) error {
 	outBuf := new(bytes.Buffer)
 	t, err := template.ParseFiles(
-		"view/layout.html",
-		"view/location-search.html",
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "location-search.html"),
 	)
 	if err != nil {
 		return err
@@ -116,11 +246,11 @@ func renderLocationSearch(viewModel view.LocationSearchView, w http.ResponseWrit
 	return err
 }
 
-func renderLoginForm(w http.ResponseWriter) error {
+func renderLoginForm(dir string, w http.ResponseWriter) error {
 	outBuf := new(bytes.Buffer)
 	t, err := template.ParseFiles(
-		"view/layout.html",
-		"view/login.html",
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "login.html"),
 	)
 	if err != nil {
 		return err
@@ -141,11 +271,11 @@ func renderLoginForm(w http.ResponseWriter) error {
 	return err
 }
 
-func renderMediaGallery(viewModel view.MediaGalleryView, w http.ResponseWriter) error {
+func renderMediaGallery(dir string, viewModel view.MediaGalleryView, w http.ResponseWriter) error {
 	outBuf := new(bytes.Buffer)
 	t, err := template.ParseFiles(
-		"view/layout.html",
-		"view/media_gallery.html",
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "media_gallery.html"),
 	)
 	if err != nil {
 		return err
@@ -167,3 +297,61 @@ func renderMediaGallery(viewModel view.MediaGalleryView, w http.ResponseWriter)
 	_, err = w.Write(outBuf.Bytes())
 	return err
 }
+
+// renderMediaMap renders the media map of the site mounted at root
+func renderMediaMap(dir string, root string, w http.ResponseWriter) error {
+	outBuf := new(bytes.Buffer)
+	t, err := template.ParseFiles(
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "media_map.html"),
+	)
+	if err != nil {
+		return err
+	}
+	v := struct {
+		PageTitle   string
+		GeoJSONURL  string
+		ComposerURL string
+	}{
+		PageTitle:   "media map",
+		GeoJSONURL:  root + "/admin/media.geojson",
+		ComposerURL: root + "/admin/composer/media",
+	}
+	err = t.ExecuteTemplate(outBuf, "layout", v)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(outBuf.Bytes())
+	return err
+}
+
+func renderPostRevisions(dir string, viewModel view.PostRevisionsView, w http.ResponseWriter) error {
+	outBuf := new(bytes.Buffer)
+	t, err := template.ParseFiles(
+		filepath.Join(dir, "layout.html"),
+		filepath.Join(dir, "post_revisions.html"),
+		filepath.Join(dir, "post_card.html"),
+	)
+	if err != nil {
+		return err
+	}
+	v := struct {
+		PageTitle string
+		Model     view.PostRevisionsView
+	}{
+		PageTitle: "Post history",
+		Model:     viewModel,
+	}
+	err = t.ExecuteTemplate(outBuf, "layout", v)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(outBuf.Bytes())
+	return err
+}
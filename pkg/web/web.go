@@ -7,20 +7,25 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/j4y_funabashi/inari-micropub/pkg/app"
+	"github.com/j4y_funabashi/inari-micropub/pkg/geo"
+	"github.com/j4y_funabashi/inari-micropub/pkg/mf2"
 	"github.com/j4y_funabashi/inari-micropub/pkg/view"
 	"github.com/sirupsen/logrus"
 )
 
 type Server struct {
-	App       app.Server
-	logger    *logrus.Logger
-	presenter view.Presenter
-	parser    Parser
+	App         app.Server
+	logger      *logrus.Logger
+	presenter   view.Presenter
+	parser      Parser
+	templateDir string
 }
 
 type contextKey string
@@ -35,18 +40,32 @@ func NewServer(
 	parser Parser,
 ) Server {
 	return Server{
-		App:       a,
-		logger:    logger,
-		presenter: p,
-		parser:    parser,
+		App:         a,
+		logger:      logger,
+		presenter:   p,
+		parser:      parser,
+		templateDir: "view",
 	}
 }
 
+// WithTemplateDir sets the directory the html templates are read from,
+// view in the working directory by default
+func (s Server) WithTemplateDir(dir string) Server {
+	s.templateDir = dir
+	return s
+}
+
 func (s Server) Routes(router *mux.Router) {
 
 	baseURL := os.Getenv("BASE_URL")
 
 	router.HandleFunc("/", s.handleHomepage()).Methods("GET")
+	router.HandleFunc("/search", s.handleSearch()).Methods("GET")
+	router.HandleFunc("/archive", s.handleArchive()).Methods("GET")
+	router.HandleFunc("/archive/{year:[0-9]{4}}", s.handleArchive()).Methods("GET")
+	router.HandleFunc("/archive/{year:[0-9]{4}}/{month:[0-9]{2}}", s.handleArchive()).Methods("GET")
+	router.HandleFunc("/posts.geojson", s.handlePostsGeoJSON()).Methods("GET")
+	router.HandleFunc("/media", s.adminOnly(s.handleMediaByPlace())).Methods("GET")
 	router.HandleFunc("/micropub", s.withBearerToken(s.handleMicropubCommand())).Methods("POST")
 	router.HandleFunc("/micropub", s.withBearerToken(s.handleMicropubQuery())).Methods("GET")
 	router.HandleFunc("/micropub/media", s.withBearerToken(s.handleMediaUpload(baseURL))).Methods("POST")
@@ -58,9 +77,14 @@ func (s Server) Routes(router *mux.Router) {
 	router.HandleFunc("/admin/composer/media", s.adminOnly(s.handleMediaGallery())).Methods("GET")
 	router.HandleFunc("/admin/composer/media", s.adminOnly(s.handleAddMediaToComposer())).Methods("POST")
 	router.HandleFunc("/admin/composer/media/detail", s.adminOnly(s.handleMediaDetail())).Methods("GET")
+	router.HandleFunc("/admin/composer/media/map", s.adminOnly(s.handleMediaMap())).Methods("GET")
+	router.HandleFunc("/admin/media.geojson", s.adminOnly(s.handleMediaGeoJSON())).Methods("GET")
 	router.HandleFunc("/admin/composer/location", s.adminOnly(s.handleLocationSearch())).Methods("GET")
 	router.HandleFunc("/admin/composer/location", s.adminOnly(s.handleAddLocationToComposer())).Methods("POST")
 	router.HandleFunc("/admin/media/delete", s.adminOnly(s.handleDeleteMedia())).Methods("POST")
+	router.HandleFunc("/admin/posts/revisions", s.adminOnly(s.handlePostRevisions())).Methods("GET")
+	router.HandleFunc("/admin/posts/revert", s.adminOnly(s.handleRevertPost())).Methods("POST")
+	router.HandleFunc("/admin/preview", s.adminOnly(s.handlePreview())).Methods("GET")
 }
 
 func (s Server) handleMicropubQuery() http.HandlerFunc {
@@ -75,32 +99,122 @@ func (s Server) handleMicropubQuery() http.HandlerFunc {
 
 		switch r.URL.Query().Get("q") {
 		case "source":
+			if postURL := r.URL.Query().Get("url"); postURL != "" {
+				s.writePostSource(w, postURL)
+				return
+			}
 			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 			if limit == 0 {
 				limit = 30
 			}
-			after := r.URL.Query().Get("after")
-			posts, err := s.App.QueryPostList(limit, after)
-			if err != nil {
-				s.logger.WithError(err).Error("failed to query post list")
-				w.WriteHeader(http.StatusInternalServerError)
+			cursor := pageCursor(r)
+			var posts *app.QueryPostListResponse
+			var err error
+			if search := r.URL.Query().Get("search"); search != "" {
+				posts, err = s.App.SearchPosts(search, limit, cursor)
+			} else {
+				posts, err = s.queryPostList(r.URL.Query().Get("post-type"), limit, cursor)
+			}
+			if err == app.ErrUnknownPostType || err == app.ErrInvalidCursor {
+				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-
-			buf := bytes.NewBuffer([]byte{})
-			err = json.NewEncoder(buf).Encode(posts.PostList)
 			if err != nil {
-				s.logger.WithError(err).Error("failed to encode post list")
+				s.logger.WithError(err).Error("failed to query post list")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			w.Header().Add("Content-type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write(buf.Bytes())
+			s.writePostListSource(w, posts)
+		case "revisions":
+			s.writePostRevisions(w, r)
 		}
 	}
 }
 
+// writePostListSource responds to q=source with a page of posts and the
+// cursors of the pages either side of it
+func (s Server) writePostListSource(w http.ResponseWriter, posts *app.QueryPostListResponse) {
+	body := mf2.PostList{
+		Items: posts.PostList,
+		Paging: &mf2.ListPaging{
+			Before: posts.BeforeKey,
+			After:  posts.AfterKey,
+		},
+	}
+	buf := bytes.NewBuffer([]byte{})
+	err := json.NewEncoder(buf).Encode(body)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to encode post list")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// writePostRevisions responds with the history of a post, or with the
+// post as it was at a revision when one is requested
+func (s Server) writePostRevisions(w http.ResponseWriter, r *http.Request) {
+	revision, _ := strconv.Atoi(r.URL.Query().Get("revision"))
+	revisions, err := s.App.ShowPostRevisions(r.URL.Query().Get("url"), revision)
+	switch err {
+	case nil:
+	case app.ErrPostNotFound, app.ErrRevisionNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	default:
+		s.logger.WithError(err).Error("failed to query post revisions")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var body interface{} = revisions
+	if revision != 0 {
+		body = revisions.Selected.Post
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	err = json.NewEncoder(buf).Encode(body)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to encode post revisions")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func (s Server) writePostSource(w http.ResponseWriter, postURL string) {
+	post, err := s.App.QueryPost(postURL)
+	if err == app.ErrPostNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.WithError(err).Error("failed to query post")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	err = json.NewEncoder(buf).Encode(post.Post)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to encode post")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-type", "application/json")
+	w.Header().Set("ETag", formatETag(post.Version))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func formatETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
 func (s Server) handleMicropubCommand() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -129,12 +243,58 @@ func (s Server) handleMicropubCommand() http.HandlerFunc {
 			return
 		case "update":
 			updateRequest := s.parser.ParseUpdateRequest(bodyBytes)
-			err := s.App.UpdatePost(updateRequest)
+			updateRequest.Version, err = s.parser.ParseIfMatch(r.Header.Get("If-Match"))
 			if err != nil {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			updateResponse, err := s.App.UpdatePost(updateRequest)
+			switch err {
+			case nil:
+			case app.ErrPostNotFound:
+				w.WriteHeader(http.StatusNotFound)
+				return
+			case app.ErrPreconditionFailed:
+				w.Header().Set("ETag", formatETag(updateResponse.Version))
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			case app.ErrVersionConflict:
+				w.WriteHeader(http.StatusConflict)
+				return
+			default:
 				s.logger.WithError(err).Error("failed to update post")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+			w.Header().Set("ETag", formatETag(updateResponse.Version))
+			w.WriteHeader(http.StatusOK)
+		case "revert":
+			revertRequest := s.parser.ParseRevertRequest(bodyBytes)
+			revertRequest.Version, err = s.parser.ParseIfMatch(r.Header.Get("If-Match"))
+			if err != nil {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			revertResponse, err := s.App.RevertPost(revertRequest)
+			switch err {
+			case nil:
+			case app.ErrPostNotFound, app.ErrRevisionNotFound:
+				w.WriteHeader(http.StatusNotFound)
+				return
+			case app.ErrPreconditionFailed:
+				w.Header().Set("ETag", formatETag(revertResponse.Version))
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			case app.ErrVersionConflict:
+				w.WriteHeader(http.StatusConflict)
+				return
+			default:
+				s.logger.WithError(err).Error("failed to revert post")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("ETag", formatETag(revertResponse.Version))
+			w.WriteHeader(http.StatusOK)
 		default:
 			s.logger.
 				WithField("action", action).
@@ -163,8 +323,12 @@ func (s Server) handleHomepage() http.HandlerFunc {
 
 		// fetch latest posts
 		limit := 12
-		after := r.URL.Query().Get("after")
-		postList, err := s.App.QueryPostList(limit, after)
+		postType := r.URL.Query().Get("type")
+		postList, err := s.queryPostList(postType, limit, pageCursor(r))
+		if err == app.ErrUnknownPostType || err == app.ErrInvalidCursor {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			s.logger.WithError(err).Error("failed to query post list")
 			w.WriteHeader(http.StatusInternalServerError)
@@ -173,22 +337,101 @@ func (s Server) handleHomepage() http.HandlerFunc {
 
 		// viewModel := s.presenter.ParseHomepage(postList)
 		// err := renderHomepage(viewModel, w)
+		s.writeHomepage(w, postList, "/", postType, mf2.PostTypes)
+	}
+}
+
+// queryPostList lists the posts of postType, or every post when it is
+// empty
+func (s Server) queryPostList(postType string, limit int, cursor string) (*app.QueryPostListResponse, error) {
+	if postType == "" {
+		return s.App.QueryPostList(limit, cursor)
+	}
+	return s.App.QueryPostListByType(postType, limit, cursor)
+}
+
+// pageCursor is the cursor of the page of a list a request asks for,
+// through either its before or after parameter
+func pageCursor(r *http.Request) string {
+	if before := r.URL.Query().Get("before"); before != "" {
+		return before
+	}
+	return r.URL.Query().Get("after")
+}
+
+func (s Server) handleSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		limit := 12
+		query := r.URL.Query().Get("q")
+		postList := &app.QueryPostListResponse{}
+		if query != "" {
+			var err error
+			postList, err = s.App.SearchPosts(query, limit, pageCursor(r))
+			if err == app.ErrInvalidCursor {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				s.logger.WithError(err).Error("failed to search posts")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
 		outBuf := new(bytes.Buffer)
-		err = renderHomepage(outBuf, postList.PostList, postList.AfterKey)
+		err := renderSearch(s.templateDir, outBuf, query, postList)
 		if err != nil {
-			s.logger.WithError(err).Error("failed to render homepage")
+			s.logger.WithError(err).Error("failed to render search")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		w.Header().Set("Content-type", "text/html; charset=UTF-8")
 		w.WriteHeader(http.StatusOK)
-		_, err = w.Write(outBuf.Bytes())
+		w.Write(outBuf.Bytes())
+	}
+}
+
+// handlePreview renders the homepage from the preview projection built
+// by inari-replay --until
+func (s Server) handlePreview() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		limit := 12
+		postList, err := s.App.QueryPreviewPostList(limit, pageCursor(r))
+		if err == app.ErrPreviewUnavailable {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err == app.ErrInvalidCursor {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			s.logger.WithError(err).Error("failed to write html")
+			s.logger.WithError(err).Error("failed to query preview post list")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		// the preview cannot be filtered by post type
+		s.writeHomepage(w, postList, "/admin/preview", "", nil)
+	}
+}
+
+func (s Server) writeHomepage(w http.ResponseWriter, postList *app.QueryPostListResponse, basePath, postType string, postTypes []string) {
+	outBuf := new(bytes.Buffer)
+	err := renderHomepage(s.templateDir, outBuf, postList, basePath, postType, postTypes)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to render homepage")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(outBuf.Bytes())
+	if err != nil {
+		s.logger.WithError(err).Error("failed to write html")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
@@ -198,19 +441,284 @@ func (s Server) handleMediaDetail() http.HandlerFunc {
 		mediaURL := r.URL.Query().Get("url")
 		mediaDetail := s.App.ShowMediaDetail(mediaURL)
 		viewModel := s.presenter.ParseMediaDetail(mediaDetail)
-		err := renderMediaDetail(viewModel, w)
+		err := renderMediaDetail(s.templateDir, viewModel, w)
 		if err != nil {
 			s.logger.WithError(err).Error("failed to render composer")
 		}
 	}
 }
 
+func (s Server) handlePostRevisions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postURL := r.URL.Query().Get("url")
+		revision, _ := strconv.Atoi(r.URL.Query().Get("revision"))
+
+		revisions, err := s.App.ShowPostRevisions(postURL, revision)
+		switch err {
+		case nil:
+		case app.ErrPostNotFound, app.ErrRevisionNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		default:
+			s.logger.
+				WithError(err).
+				WithField("url", postURL).
+				Error("failed to show post revisions")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		viewModel := s.presenter.ParsePostRevisions(revisions)
+		err = renderPostRevisions(s.templateDir, viewModel, w)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to render post revisions")
+		}
+	}
+}
+
+func (s Server) handleRevertPost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postURL := r.FormValue("url")
+		revision, _ := strconv.Atoi(r.FormValue("revision"))
+
+		_, err := s.App.RevertPost(app.RevertPostRequest{
+			URL:      postURL,
+			Revision: revision,
+		})
+		switch err {
+		case nil:
+		case app.ErrPostNotFound, app.ErrRevisionNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case app.ErrVersionConflict:
+			w.WriteHeader(http.StatusConflict)
+			return
+		default:
+			s.logger.
+				WithError(err).
+				WithField("url", postURL).
+				WithField("revision", revision).
+				Error("failed to revert post")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		urlParams := url.Values{}
+		urlParams.Add("url", postURL)
+		w.Header().Set("Location", "/admin/posts/revisions?"+urlParams.Encode())
+		w.WriteHeader(http.StatusSeeOther)
+	}
+}
+
+// handleArchive renders the posts of the whole archive, a year or a month
+// of a year as an h-feed
 func (s Server) handleArchive() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.logger.Error("ARCHIVE NOT YET IMPLEMENTED")
+
+		limit := 12
+		vars := mux.Vars(r)
+		archive, err := s.App.QueryArchive(vars["year"], vars["month"], limit, pageCursor(r))
+		if err == app.ErrArchiveNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err == app.ErrInvalidCursor {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.logger.WithError(err).Error("failed to query archive")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// links are made from the root as the router may be mounted
+		// under a prefix
+		root := r.URL.Path[:strings.Index(r.URL.Path, "/archive")+len("/archive")]
+		outBuf := new(bytes.Buffer)
+		err = renderArchive(s.templateDir, outBuf, root, archive)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to render archive")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-type", "text/html; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(outBuf.Bytes())
+	}
+}
+
+// handlePostsGeoJSON responds with the posts made at the place a request
+// asks for as GeoJSON points
+func (s Server) handlePostsGeoJSON() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		place, limit, err := parsePlace(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		postList, err := s.App.QueryPostsByPlace(place, limit)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to query posts by place")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		fc := geo.NewFeatureCollection()
+		for _, post := range postList.PostList {
+			lat, lng, ok := post.LatLng()
+			if !ok {
+				continue
+			}
+			v := post.ToView()
+			properties := map[string]interface{}{
+				"url":       v.Url,
+				"name":      v.Name,
+				"published": v.Published,
+				"post_type": v.PostType,
+			}
+			if len(v.Photo) > 0 {
+				properties["photo"] = v.Photo[0]
+			}
+			fc.Add(lat, lng, properties)
+		}
+		s.writeGeoJSON(w, fc)
+	}
+}
+
+// handleMediaByPlace responds with the media taken at the place a
+// request asks for
+func (s Server) handleMediaByPlace() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		place, limit, err := parsePlace(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mediaList, err := s.App.QueryMediaByPlace(place, limit)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to query media by place")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		buf := bytes.NewBuffer([]byte{})
+		err = json.NewEncoder(buf).Encode(mediaList)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to encode media list")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
+
+// handleMediaMap renders the map of geotagged media
+func (s Server) handleMediaMap() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the geojson and the composer are found beside the map as the
+		// router may be mounted under a prefix
+		root := r.URL.Path[:strings.Index(r.URL.Path, "/admin/")]
+		err := renderMediaMap(s.templateDir, root, w)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to render media map")
+		}
+	}
+}
+
+// handleMediaGeoJSON responds with the media taken at the place a
+// request asks for as GeoJSON points. One more item than the limit is
+// queried so the collection can say when it was cut short
+func (s Server) handleMediaGeoJSON() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		place, limit, err := parsePlace(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mediaList, err := s.App.QueryMediaByPlace(place, limit+1)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to query media by place")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		fc := geo.NewFeatureCollection()
+		if len(mediaList.Items) > limit {
+			mediaList.Items = mediaList.Items[:limit]
+			fc.Truncated = true
+		}
+		for _, media := range mediaList.Items {
+			fc.Add(media.Lat, media.Lng, map[string]interface{}{
+				"uid":          media.Uid,
+				"url":          media.URL,
+				"date_time":    media.DateTime,
+				"is_published": media.IsPublished,
+			})
+		}
+		s.writeGeoJSON(w, fc)
+	}
+}
+
+// writeGeoJSON responds with a feature collection
+func (s Server) writeGeoJSON(w http.ResponseWriter, fc geo.FeatureCollection) {
+	buf := bytes.NewBuffer([]byte{})
+	err := json.NewEncoder(buf).Encode(fc)
+	if err != nil {
+		s.logger.WithError(err).Error("failed to encode geojson")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// parsePlace reads the place a request asks for, either a bbox of
+// west,south,east,north or a radius in kilometres around lat and lng,
+// and how many items it wants. No place at all is the whole world
+func parsePlace(r *http.Request) (app.Place, int, error) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 500
+	}
+
+	place := app.Place{Box: geo.World}
+	if bbox := q.Get("bbox"); bbox != "" {
+		box, err := geo.ParseBox(bbox)
+		place.Box = box
+		return place, limit, err
+	}
+	if q.Get("radius") == "" {
+		return place, limit, nil
+	}
+
+	var err error
+	for _, p := range []struct {
+		key string
+		val *float64
+		min float64
+		max float64
+	}{
+		{"lat", &place.Lat, -90, 90},
+		{"lng", &place.Lng, -180, 180},
+		{"radius", &place.Radius, 0, 20040},
+	} {
+		*p.val, err = strconv.ParseFloat(q.Get(p.key), 64)
+		if err != nil || !(*p.val >= p.min && *p.val <= p.max) {
+			return place, limit, geo.ErrInvalidBox
+		}
+	}
+	if place.Radius == 0 {
+		return place, limit, geo.ErrInvalidBox
+	}
+	return place, limit, nil
 }
 
 func (s Server) handleComposerForm() http.HandlerFunc {
@@ -220,7 +728,7 @@ func (s Server) handleComposerForm() http.HandlerFunc {
 			s.logger.Error("failed fetch session from context")
 		}
 		viewModel := s.presenter.ParseComposer(sess)
-		err := renderComposerForm(viewModel, w)
+		err := renderComposerForm(s.templateDir, viewModel, w)
 		if err != nil {
 			s.logger.WithError(err).Error("failed to render composer")
 		}
@@ -298,7 +806,7 @@ func (s Server) handleLogin() http.HandlerFunc {
 
 func (s Server) handleLoginForm() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := renderLoginForm(w)
+		err := renderLoginForm(s.templateDir, w)
 		if err != nil {
 			s.logger.WithError(err).Error("failed to render media gallery")
 		}
@@ -434,29 +942,42 @@ func (s Server) handleDeleteMedia() http.HandlerFunc {
 	}
 }
 
+// handleAddMediaToComposer adds every media_url posted, one from a
+// photo's detail page or many from a cluster on the media map
 func (s Server) handleAddMediaToComposer() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		mediaURL := r.FormValue("media_url")
-		media := s.App.ShowMediaDetail(mediaURL)
+		err := r.ParseForm()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 
 		// TODO fetch session from context
 		sess, ok := r.Context().Value(contextKeySessionID).(app.SessionData)
 		if !ok {
 			s.logger.Error("failed fetch session from context")
 		}
-		sess.Media = append(sess.Media, media.Media)
-		sess.Published = media.Media.DateTime
 
-		// TODO if media has lat/lng then search for suggested Locations
-		if media.Media.Lat != 0 && media.Media.Lng != 0 {
-			locations := s.App.SearchLocationsByLatLng(media.Media.Lat, media.Media.Lng)
-			sess.AddSuggestedLocations(locations)
-			venues := s.App.SearchVenues(media.Media.Lat, media.Media.Lng)
-			sess.AddSuggestedLocations(venues)
+		suggested := false
+		for _, mediaURL := range r.PostForm["media_url"] {
+			media := s.App.ShowMediaDetail(mediaURL)
+			sess.Media = append(sess.Media, media.Media)
+			sess.Published = media.Media.DateTime
+
+			// TODO if media has lat/lng then search for suggested Locations
+			// the photos of a cluster are close together so only the first
+			// located one is searched around
+			if !suggested && media.Media.Lat != 0 && media.Media.Lng != 0 {
+				locations := s.App.SearchLocationsByLatLng(media.Media.Lat, media.Media.Lng)
+				sess.AddSuggestedLocations(locations)
+				venues := s.App.SearchVenues(media.Media.Lat, media.Media.Lng)
+				sess.AddSuggestedLocations(venues)
+				suggested = true
+			}
 		}
 
 		s.logger.WithField("sess", sess).Info("session")
-		err := s.App.SaveSession(sess)
+		err = s.App.SaveSession(sess)
 		if err != nil {
 			s.logger.WithError(err).Error("failed save session")
 		}
@@ -477,7 +998,7 @@ func (s Server) handleLocationSearch() http.HandlerFunc {
 		locationQuery := r.URL.Query().Get("location")
 		locations := s.App.SearchLocations(sess.Location, locationQuery)
 		viewModel := s.presenter.ParseLocationSearch(locationQuery, locations)
-		err := renderLocationSearch(viewModel, w)
+		err := renderLocationSearch(s.templateDir, viewModel, w)
 		if err != nil {
 			s.logger.WithError(err).Error("failed to render location search")
 		}
@@ -492,7 +1013,7 @@ func (s Server) handleMediaGallery() http.HandlerFunc {
 
 		media := s.App.ShowMediaGallery(year, month, day)
 		viewModel := s.presenter.ParseMediaGallery(media)
-		err := renderMediaGallery(viewModel, w)
+		err := renderMediaGallery(s.templateDir, viewModel, w)
 		if err != nil {
 			s.logger.WithError(err).Error("failed to render media gallery")
 		}
@@ -122,3 +122,101 @@ func TestParseUpdateRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestParseIfMatch(t *testing.T) {
+
+	var tests = []struct {
+		name     string
+		header   string
+		expected int
+		err      error
+	}{
+		{
+			name:     "empty header",
+			header:   "",
+			expected: 0,
+		},
+		{
+			name:     "strong etag",
+			header:   `"12"`,
+			expected: 12,
+		},
+		{
+			name:     "wildcard",
+			header:   "*",
+			expected: 0,
+		},
+		{
+			name:   "weak etag",
+			header: `W/"3"`,
+			err:    web.ErrInvalidIfMatch,
+		},
+		{
+			name:   "list of etags",
+			header: `"3", "4"`,
+			err:    web.ErrInvalidIfMatch,
+		},
+		{
+			name:   "unquoted version",
+			header: "3",
+			err:    web.ErrInvalidIfMatch,
+		},
+		{
+			name:   "not a version",
+			header: `"abc"`,
+			err:    web.ErrInvalidIfMatch,
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+
+			// act
+			sut := web.NewParser()
+			result, err := sut.ParseIfMatch(tt.header)
+
+			// assert
+			is.Equal(err, tt.err)
+			is.Equal(result, tt.expected)
+		})
+	}
+}
+
+func TestParseRevertRequest(t *testing.T) {
+
+	var tests = []struct {
+		name        string
+		requestBody string
+		expected    app.RevertPostRequest
+	}{
+		{
+			name:        "empty values",
+			requestBody: "",
+			expected:    app.RevertPostRequest{},
+		},
+		{
+			name:        "valid revert",
+			requestBody: `{"action": "revert", "url": "https://example.com/post/100", "revision": 3}`,
+			expected: app.RevertPostRequest{
+				URL:      "https://example.com/post/100",
+				Revision: 3,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+
+			// act
+			sut := web.NewParser()
+			result := sut.ParseRevertRequest([]byte(tt.requestBody))
+
+			// assert
+			is.Equal(result, tt.expected)
+		})
+	}
+}
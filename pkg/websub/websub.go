@@ -0,0 +1,49 @@
+package websub
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+)
+
+// Publisher pings a WebSub hub whenever a post changes so subscribers of
+// the site feed fetch it again
+type Publisher struct {
+	hubURL   string
+	topicURL string
+	client   *http.Client
+}
+
+func New(hubURL, topicURL string) Publisher {
+	return Publisher{
+		hubURL:   hubURL,
+		topicURL: topicURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Handle is an eventlog.Handler
+func (p Publisher) Handle(h eventlog.EventHeader, event eventlog.Event) error {
+	switch h.EventType {
+	case "PostCreated", "PostUpdated":
+	default:
+		return nil
+	}
+
+	resp, err := p.client.PostForm(p.hubURL, url.Values{
+		"hub.mode": {"publish"},
+		"hub.url":  {p.topicURL},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hub responded with %d", resp.StatusCode)
+	}
+	return nil
+}
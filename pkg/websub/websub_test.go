@@ -0,0 +1,68 @@
+package websub_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/j4y_funabashi/inari-micropub/pkg/eventlog"
+	"github.com/j4y_funabashi/inari-micropub/pkg/websub"
+	"github.com/matryer/is"
+)
+
+func TestHandle(t *testing.T) {
+	var tests = []struct {
+		name          string
+		eventType     string
+		hubStatus     int
+		expectedPings int
+		expectErr     bool
+	}{
+		{
+			name:          "pings hub when a post is created",
+			eventType:     "PostCreated",
+			hubStatus:     http.StatusNoContent,
+			expectedPings: 1,
+		},
+		{
+			name:          "pings hub when a post is updated",
+			eventType:     "PostUpdated",
+			hubStatus:     http.StatusAccepted,
+			expectedPings: 1,
+		},
+		{
+			name:          "ignores media events",
+			eventType:     "MediaUploaded",
+			hubStatus:     http.StatusNoContent,
+			expectedPings: 0,
+		},
+		{
+			name:          "hub errors are returned so the event is retried",
+			eventType:     "PostCreated",
+			hubStatus:     http.StatusInternalServerError,
+			expectedPings: 1,
+			expectErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		is := is.NewRelaxed(t)
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			pings := 0
+			hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				pings++
+				is.Equal(r.FormValue("hub.mode"), "publish")
+				is.Equal(r.FormValue("hub.url"), "https://example.com/")
+				w.WriteHeader(tt.hubStatus)
+			}))
+			defer hub.Close()
+
+			publisher := websub.New(hub.URL, "https://example.com/")
+			err := publisher.Handle(eventlog.EventHeader{EventType: tt.eventType}, nil)
+
+			is.Equal(err != nil, tt.expectErr)
+			is.Equal(pings, tt.expectedPings)
+		})
+	}
+}